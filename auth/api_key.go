@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const apiKeyPrefixSize = 4
+const apiKeySecretSize = 32
+
+// APIKey generates a random API key in the "<prefix>.<secret>" format
+func APIKey() (key, prefix, secret string, err error) {
+	p := make([]byte, apiKeyPrefixSize)
+	if _, err = rand.Read(p); err != nil {
+		return
+	}
+
+	s := make([]byte, apiKeySecretSize)
+	if _, err = rand.Read(s); err != nil {
+		return
+	}
+
+	prefix = hex.EncodeToString(p)
+	secret = base64.RawURLEncoding.EncodeToString(s)
+
+	return prefix + "." + secret, prefix, secret, nil
+}
+
+// ParseAPIKey splits the API key into the prefix and the secret
+func ParseAPIKey(key string) (prefix, secret string, ok bool) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
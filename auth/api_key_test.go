@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, prefix, secret, err := APIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns key which can be parsed", func(t *testing.T) {
+		p, s, ok := ParseAPIKey(key)
+
+		if !ok || p != prefix || s != secret || len(prefix) != 2*apiKeyPrefixSize {
+			t.Errorf("got unexpected parts %q and %q of %q", p, s, key)
+		}
+	})
+
+	t.Run("returns unique keys", func(t *testing.T) {
+		other, _, _, err := APIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if other == key {
+			t.Error("expected unique key")
+		}
+	})
+
+	t.Run("rejects key without secret", func(t *testing.T) {
+		if _, _, ok := ParseAPIKey(prefix + "."); ok {
+			t.Error("expected invalid key")
+		}
+	})
+}
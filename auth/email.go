@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeEmail returns the canonical form of the email used to store and look up users.
+// The address is trimmed, lowercased and NFC normalized and the domain is converted to ASCII.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(norm.NFC.String(strings.TrimSpace(email)))
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return email
+	}
+
+	return email[:at+1] + domain
+}
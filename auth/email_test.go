@@ -0,0 +1,20 @@
+package auth
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := map[string]string{
+		"  Bob@Example.COM ":  "bob@example.com",
+		"bob@example.com":     "bob@example.com",
+		"Jose\u0301@mail.com": "jos\u00e9@mail.com",
+		"user@Bücher.example": "user@xn--bcher-kva.example",
+		"invalid.mail.com":    "invalid.mail.com",
+		"a@b@Example.com":     "a@b@example.com",
+	}
+
+	for email, expected := range cases {
+		if result := NormalizeEmail(email); result != expected {
+			t.Errorf("expected %q for %q, got %q", expected, email, result)
+		}
+	}
+}
@@ -23,9 +23,14 @@ type TokenDetails struct {
 
 // Claims represents data from JWT body
 type Claims struct {
-	UserID int64    `json:"user_id"`
-	Roles  []string `json:"roles"`
-	UUID   string   `json:"uuid"`
+	UserID      int64    `json:"user_id"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Tenant is the slug of the organization the roles and the permissions belong to
+	Tenant string `json:"tenant,omitempty"`
+	UUID   string `json:"uuid"`
+	// IssuedAtNano is the issue time in nanoseconds telling apart tokens issued within the same second
+	IssuedAtNano int64 `json:"iat_nano,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -37,48 +42,52 @@ type RSAKeys struct {
 	RefreshVerify *rsa.PublicKey
 }
 
+// Lifetimes of access and refresh tokens
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
 type authErr string
 
 func (e authErr) Error() string { return string(e) }
 
 const (
-	errEmptySecret   = authErr("Token secret is empty")
-	errEmptyPassword = authErr("Password is empty")
+	errEmptySecret     = authErr("Token secret is empty")
+	errEmptyPassword   = authErr("Password is empty")
+	errInvalidAudience = authErr("Token has invalid audience")
 )
 
-// Token creates access and refresh tokens for a user with specified ID
-func Token(userID int64, roles []string, keys *RSAKeys) (*TokenDetails, error) {
+// Token creates access and refresh tokens with user ID, roles and permissions of the claims
+func Token(claims Claims, keys *RSAKeys) (*TokenDetails, error) {
 	var err error
 
 	details := &TokenDetails{}
 
-	details.AccessExpiresAt = time.Now().Add(time.Minute * 15).Unix()
-	details.RefreshExpiresAt = time.Now().Add(time.Hour * 24 * 7).Unix()
+	now := time.Now()
+	details.AccessExpiresAt = now.Add(AccessTokenTTL).Unix()
+	details.RefreshExpiresAt = now.Add(RefreshTokenTTL).Unix()
 
 	details.AccessUUID = uuid.New().String()
 	details.RefreshUUID = uuid.New().String()
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
-		userID,
-		roles,
-		details.AccessUUID,
-		jwt.StandardClaims{
-			ExpiresAt: details.AccessExpiresAt,
-		},
-	})
+	access := claims
+	access.UUID = details.AccessUUID
+	access.IssuedAtNano = now.UnixNano()
+	access.StandardClaims = jwt.StandardClaims{ExpiresAt: details.AccessExpiresAt, IssuedAt: now.Unix()}
+
+	accessToken := jwt.NewWithClaims(jwt.SigningMethodRS256, access)
 	details.Access, err = accessToken.SignedString(keys.AccessSign)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
-		userID,
-		roles,
-		details.RefreshUUID,
-		jwt.StandardClaims{
-			ExpiresAt: details.RefreshExpiresAt,
-		},
-	})
+	refresh := claims
+	refresh.UUID = details.RefreshUUID
+	refresh.IssuedAtNano = now.UnixNano()
+	refresh.StandardClaims = jwt.StandardClaims{ExpiresAt: details.RefreshExpiresAt, IssuedAt: now.Unix()}
+
+	refreshToken := jwt.NewWithClaims(jwt.SigningMethodRS256, refresh)
 	details.Refresh, err = refreshToken.SignedString(keys.RefreshSign)
 	if err != nil {
 		return nil, err
@@ -105,6 +114,10 @@ func ValidateToken(tokenString string, secret *rsa.PublicKey) (jwt.Claims, error
 	if !ok || !token.Valid {
 		return nil, err
 	}
+	// Link tokens are signed with the same keys but have an audience
+	if len(claims.Audience) > 0 {
+		return nil, errInvalidAudience
+	}
 
 	return claims, nil
 }
@@ -16,14 +16,14 @@ func TestToken(t *testing.T) {
 	keys := &RSAKeys{AccessSign: privateKey, RefreshSign: privateKey}
 
 	t.Run("without errors", func(t *testing.T) {
-		_, err := Token(0, nil, keys)
+		_, err := Token(Claims{}, keys)
 		if err != nil {
 			t.Errorf("got %q error", err.Error())
 		}
 	})
 
 	t.Run("returns non empty tokens", func(t *testing.T) {
-		details, _ := Token(0, nil, keys)
+		details, _ := Token(Claims{}, keys)
 		if details == nil || len(details.Access) == 0 || len(details.Refresh) == 0 {
 			t.Error("got empty tokens")
 		}
@@ -51,6 +51,10 @@ func TestValidateToken(t *testing.T) {
 		expired := authtest.GenerateFakeJWT(t, secret, jwt.SigningMethodRS256, expiredClaims)
 		invalidSign := authtest.GenerateFakeJWT(t, keys.RefreshSign, jwt.SigningMethodRS256, claims)
 		invalidAlg := authtest.GenerateFakeJWT(t, []byte("foobar123"), jwt.SigningMethodHS512, claims)
+		link, _, err := LinkToken(1, "magic_link", "", time.Minute, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		tokenCases := []struct {
 			title string
@@ -61,6 +65,7 @@ func TestValidateToken(t *testing.T) {
 			{title: "Invalid signature", token: invalidSign, msg: "crypto/rsa: verification error"},
 			{title: "Invalid format", token: "foobar", msg: "token contains an invalid number of segments"},
 			{title: "Invalid signing method", token: invalidAlg, msg: "Unexpected signing method: HS512"},
+			{title: "Link token", token: link, msg: errInvalidAudience.Error()},
 		}
 
 		for _, tc := range tokenCases {
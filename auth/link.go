@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// LinkClaims represents data of a single-purpose token such as a login link.
+// Link tokens are signed with refresh keys, their audience keeps them apart from access and refresh tokens.
+type LinkClaims struct {
+	UserID  int64  `json:"user_id"`
+	Purpose string `json:"purpose"`
+	Nonce   string `json:"nonce,omitempty"`
+	jwt.StandardClaims
+}
+
+// LinkAudience is the audience of link tokens, ValidateToken rejects tokens with any audience
+const LinkAudience = "tiny_goauth:link"
+
+const errInvalidPurpose = authErr("Token has invalid purpose")
+
+// LinkToken creates a signed token for the specified purpose. Returns the token and its unique ID.
+func LinkToken(userID int64, purpose, nonce string, ttl time.Duration, key *rsa.PrivateKey) (string, string, error) {
+	id := uuid.New().String()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, LinkClaims{
+		userID,
+		purpose,
+		nonce,
+		jwt.StandardClaims{
+			Id:        id,
+			Audience:  LinkAudience,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	return signed, id, nil
+}
+
+// ValidateLinkToken validates a single-purpose token
+func ValidateLinkToken(tokenString, purpose string, key *rsa.PublicKey) (*LinkClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &LinkClaims{}, func(token *jwt.Token) (interface{}, error) {
+		m, ok := token.Method.(*jwt.SigningMethodRSA)
+		if !ok || m.Alg() != "RS256" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*LinkClaims)
+	if !ok || !token.Valid || !claims.VerifyAudience(LinkAudience, true) {
+		return nil, errInvalidAudience
+	}
+	if claims.Purpose != purpose || len(claims.Id) == 0 {
+		return nil, errInvalidPurpose
+	}
+
+	return claims, nil
+}
+
+// HashNonce returns a hex encoded SHA-256 hash of the nonce
+func HashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateNonce checks that the hash has been generated from the nonce
+func ValidateNonce(nonce, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashNonce(nonce)), []byte(hash)) == 1
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestLinkToken(t *testing.T) {
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns valid token for the purpose", func(t *testing.T) {
+		token, id, err := LinkToken(1, "magic_link", "nonce", time.Minute, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := ValidateLinkToken(token, "magic_link", &key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if claims.UserID != 1 || claims.Id != id || claims.Nonce != "nonce" {
+			t.Errorf("got unexpected claims %+v", claims)
+		}
+	})
+
+	t.Run("returns error for another purpose", func(t *testing.T) {
+		token, _, _ := LinkToken(1, "magic_link", "", time.Minute, key)
+		_, err := ValidateLinkToken(token, "mfa", &key.PublicKey)
+
+		authtest.AssertError(t, errInvalidPurpose, err)
+	})
+
+	t.Run("returns error for token without link audience", func(t *testing.T) {
+		token := authtest.GenerateFakeJWT(t, key, jwt.SigningMethodRS256, jwt.MapClaims{
+			"user_id": 1, "purpose": "magic_link", "jti": "id", "exp": time.Now().Add(time.Minute).Unix(),
+		})
+		_, err := ValidateLinkToken(token, "magic_link", &key.PublicKey)
+
+		authtest.AssertError(t, errInvalidAudience, err)
+	})
+
+	t.Run("returns error for expired token", func(t *testing.T) {
+		token, _, _ := LinkToken(1, "magic_link", "", -time.Minute, key)
+
+		if _, err := ValidateLinkToken(token, "magic_link", &key.PublicKey); err == nil {
+			t.Error("expected to be invalid")
+		}
+	})
+}
+
+func TestValidateNonce(t *testing.T) {
+	hash := HashNonce("foobar")
+
+	if !ValidateNonce("foobar", hash) {
+		t.Error("nonce should be valid")
+	}
+
+	if ValidateNonce("invalid", hash) {
+		t.Error("nonce should be invalid")
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const errInvalidPhone = authErr("Phone number has invalid format")
+
+var e164Format = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// OTPCode generates a random numeric one-time code with the specified number of digits
+func OTPCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// NormalizePhone strips separators from the phone number and validates E.164 format
+func NormalizePhone(phone string) (string, error) {
+	phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+	if !e164Format.MatchString(phone) {
+		return "", errInvalidPhone
+	}
+
+	return phone, nil
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestOTPCode(t *testing.T) {
+	code, err := OTPCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != 6 {
+		t.Errorf("expected 6 digits got %q", code)
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	t.Run("strips separators", func(t *testing.T) {
+		phone, err := NormalizePhone(" +1 (555) 000-0001 ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if phone != "+15550000001" {
+			t.Errorf("expected %q got %q", "+15550000001", phone)
+		}
+	})
+
+	t.Run("returns error for invalid format", func(t *testing.T) {
+		for _, phone := range []string{"", "5550000001", "+0123456789", "+1555abc0001"} {
+			_, err := NormalizePhone(phone)
+
+			authtest.AssertError(t, errInvalidPhone, err)
+		}
+	})
+}
@@ -1,6 +1,15 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordCost = 10
+
+var dummyHash []byte
+var dummyHashOnce sync.Once
 
 // EncryptPassword generates hash from a password string
 func EncryptPassword(password string) (string, error) {
@@ -8,14 +17,29 @@ func EncryptPassword(password string) (string, error) {
 		return "", errEmptyPassword
 	}
 
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 
 	return string(bytes), err
 }
 
-// ValidatePassword validates equality of a password hash and a password string
+// ValidatePassword validates equality of a password hash and a password string.
+// An empty hash is compared with a dummy one so that unknown and passwordless
+// users take as long to check as the others.
 func ValidatePassword(password, hash string) bool {
+	if len(hash) == 0 {
+		bcrypt.CompareHashAndPassword(passwordDummyHash(), []byte(password))
+		return false
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 
 	return err == nil
 }
+
+func passwordDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), passwordCost)
+	})
+
+	return dummyHash
+}
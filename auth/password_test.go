@@ -51,3 +51,9 @@ func TestValidatePassword(t *testing.T) {
 		}
 	})
 }
+
+func TestValidatePasswordWithEmptyHash(t *testing.T) {
+	if ValidatePassword("", "") || ValidatePassword("foobar", "") {
+		t.Errorf("password should be invalid")
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for SHA1
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	secret = strings.TrimRight(secret, "=")
+
+	cases := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range cases {
+		code, err := TOTPCode(secret, tc.time/30)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != tc.code {
+			t.Errorf("expected %q got %q", tc.code, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := TOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	t.Run("accepts current and adjacent codes", func(t *testing.T) {
+		for _, d := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+			code, _ := TOTPCode(secret, now.Add(d).Unix()/30)
+
+			if _, ok := ValidateTOTP(secret, code, now); !ok {
+				t.Errorf("code with %v skew should be valid", d)
+			}
+		}
+	})
+
+	t.Run("rejects codes of empty secret", func(t *testing.T) {
+		if _, err := TOTPCode("", now.Unix()/30); err != errEmptyTOTPSecret {
+			t.Errorf("expected %q got %v", errEmptyTOTPSecret, err)
+		}
+
+		if _, ok := ValidateTOTP("", "664176", now); ok {
+			t.Error("code of empty secret should be invalid")
+		}
+	})
+
+	t.Run("rejects stale codes", func(t *testing.T) {
+		code, _ := TOTPCode(secret, now.Add(-2*time.Minute).Unix()/30)
+
+		if _, ok := ValidateTOTP(secret, code, now); ok {
+			t.Error("stale code should be invalid")
+		}
+	})
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes got %d", len(codes))
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(codes[0])) {
+		t.Error("hash should not depend on code formatting")
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const defaultRPDisplayName = "tiny_goauth"
+
+const errInvalidUserHandle = authErr("Invalid WebAuthn user handle")
+
+// PasskeyUser adapts a user and its passkeys to the webauthn.User interface
+type PasskeyUser struct {
+	User        *models.User
+	Credentials []models.WebAuthnCredential
+}
+
+// WebAuthn creates WebAuthn relying party configured from the environment
+func WebAuthn() (*webauthn.WebAuthn, error) {
+	name := os.Getenv("WEBAUTHN_RP_NAME")
+	if len(name) == 0 {
+		name = defaultRPDisplayName
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPDisplayName: name,
+		RPID:          os.Getenv("WEBAUTHN_RP_ID"),
+		RPOrigin:      os.Getenv("WEBAUTHN_RP_ORIGIN"),
+	})
+}
+
+// WebAuthnUserID encodes user ID as a WebAuthn user handle
+func WebAuthnUserID(id int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(id))
+
+	return handle
+}
+
+// UserIDFromWebAuthn decodes user ID from a WebAuthn user handle
+func UserIDFromWebAuthn(handle []byte) (int64, error) {
+	if len(handle) != 8 {
+		return 0, errInvalidUserHandle
+	}
+
+	return int64(binary.BigEndian.Uint64(handle)), nil
+}
+
+// WebAuthnID returns the user handle
+func (u *PasskeyUser) WebAuthnID() []byte {
+	return WebAuthnUserID(u.User.ID)
+}
+
+// WebAuthnName returns the user name
+func (u *PasskeyUser) WebAuthnName() string {
+	return u.User.DisplayName()
+}
+
+// WebAuthnDisplayName returns the user display name
+func (u *PasskeyUser) WebAuthnDisplayName() string {
+	return u.User.DisplayName()
+}
+
+// WebAuthnIcon returns the user icon URL
+func (u *PasskeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials returns passkeys of the user
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Credentials))
+	for i, c := range u.Credentials {
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+
+	return credentials
+}
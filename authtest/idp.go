@@ -0,0 +1,95 @@
+package authtest
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// FakeIdP is a local OpenID Connect identity provider
+type FakeIdP struct {
+	*httptest.Server
+	ClientID string
+	Key      *rsa.PrivateKey
+	// Claims are returned in ID tokens and from the userinfo endpoint
+	Claims jwt.MapClaims
+}
+
+const fakeIdPKeyID = "test"
+
+// NewFakeIdP starts a local OpenID Connect identity provider
+func NewFakeIdP(t *testing.T, clientID string) *FakeIdP {
+	t.Helper()
+
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &FakeIdP{ClientID: clientID, Key: key, Claims: jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"userinfo_endpoint":      idp.URL + "/userinfo",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("code") != "valid" || r.FormValue("client_id") != idp.ClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{"iss": idp.URL, "aud": idp.ClientID, "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range idp.Claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = fakeIdPKeyID
+		idToken, err := token.SignedString(idp.Key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(w, idp.Claims)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kid": fakeIdPKeyID,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.Key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.Key.E)).Bytes()),
+		}}})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payload)
+}
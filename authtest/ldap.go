@@ -0,0 +1,250 @@
+package authtest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAPEntry is a directory entry served by FakeLDAP
+type LDAPEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// FakeLDAP is a local LDAP server supporting simple bind and search operations
+type FakeLDAP struct {
+	URL      string
+	Entries  []LDAPEntry
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+const (
+	ldapBindRequest        = 0
+	ldapBindResponse       = 1
+	ldapUnbindRequest      = 2
+	ldapSearchRequest      = 3
+	ldapSearchResultEntry  = 4
+	ldapSearchResultDone   = 5
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+	ldapInsufficientAccess = 50
+)
+
+// NewFakeLDAP starts a local LDAP server with the given entries
+func NewFakeLDAP(t *testing.T, entries ...LDAPEntry) *FakeLDAP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &FakeLDAP{URL: "ldap://" + listener.Addr().String(), Entries: entries, listener: listener}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return s
+}
+
+// Close stops the server
+func (s *FakeLDAP) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *FakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldapBindRequest:
+			code := s.bind(op)
+			bound = code == ldapSuccess
+			conn.Write(ldapResult(id, ldapBindResponse, code).Bytes())
+		case ldapSearchRequest:
+			if !bound {
+				conn.Write(ldapResult(id, ldapSearchResultDone, ldapInsufficientAccess).Bytes())
+				continue
+			}
+
+			for _, entry := range s.search(id, op) {
+				conn.Write(entry.Bytes())
+			}
+			conn.Write(ldapResult(id, ldapSearchResultDone, ldapSuccess).Bytes())
+		case ldapUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// bind checks simple bind credentials. An empty password is accepted as an unauthenticated bind
+// the same way real directory servers do.
+func (s *FakeLDAP) bind(op *ber.Packet) int {
+	if len(op.Children) < 3 {
+		return ldapInvalidCredentials
+	}
+
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	if len(password) == 0 {
+		return ldapSuccess
+	}
+
+	for _, entry := range s.Entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password == password {
+			return ldapSuccess
+		}
+	}
+
+	return ldapInvalidCredentials
+}
+
+func (s *FakeLDAP) search(id interface{}, op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return nil
+	}
+
+	baseDN := strings.ToLower(op.Children[0].Data.String())
+	filter := op.Children[6]
+
+	var requested []string
+	for _, attr := range op.Children[7].Children {
+		requested = append(requested, attr.Data.String())
+	}
+
+	var results []*ber.Packet
+	for _, entry := range s.Entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), baseDN) || !matchFilter(filter, entry) {
+			continue
+		}
+
+		results = append(results, entry.packet(id, requested))
+	}
+
+	return results
+}
+
+func (e *LDAPEntry) packet(id interface{}, requested []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		if len(requested) > 0 && !containsFold(requested, name) {
+			continue
+		}
+
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+
+	return ldapEnvelope(id, op)
+}
+
+// matchFilter evaluates and, or, not, equality and presence filters
+func matchFilter(filter *ber.Packet, entry LDAPEntry) bool {
+	switch filter.Tag {
+	case 0:
+		for _, child := range filter.Children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, child := range filter.Children {
+			if matchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return len(filter.Children) == 1 && !matchFilter(filter.Children[0], entry)
+	case 3:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		return containsFold(entry.values(filter.Children[0].Data.String()), filter.Children[1].Data.String())
+	case 7:
+		return len(entry.values(filter.Data.String())) > 0
+	}
+
+	return false
+}
+
+func (e *LDAPEntry) values(name string) []string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+
+	return nil
+}
+
+func ldapResult(id interface{}, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return ldapEnvelope(id, op)
+}
+
+func ldapEnvelope(id interface{}, op *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	envelope.AppendChild(op)
+
+	return envelope
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,136 @@
+package authtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// FakeSAMLIdP is a SAML identity provider issuing signed responses to authentication requests
+type FakeSAMLIdP struct {
+	IDP *saml.IdentityProvider
+	sp  *saml.EntityDescriptor
+}
+
+// NewFakeSAMLIdP creates a SAML identity provider with a new key pair
+func NewFakeSAMLIdP(t *testing.T) *FakeSAMLIdP {
+	t.Helper()
+
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadataURL, _ := url.Parse("https://idp.local/metadata")
+	ssoURL, _ := url.Parse("https://idp.local/sso")
+
+	f := &FakeSAMLIdP{}
+	f.IDP = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             GenerateCertificate(t, key),
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: f,
+	}
+
+	return f
+}
+
+// GenerateCertificate returns a self-signed certificate of the key
+func GenerateCertificate(t *testing.T, key *rsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// Metadata returns identity provider metadata XML
+func (f *FakeSAMLIdP) Metadata(t *testing.T) string {
+	t.Helper()
+
+	data, err := xml.Marshal(f.IDP.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// GetServiceProvider returns metadata of the service provider passed to Response
+func (f *FakeSAMLIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	return f.sp, nil
+}
+
+// Response returns base64 encoded signed response to the authentication request from the redirect URL
+func (f *FakeSAMLIdP) Response(t *testing.T, spMetadata []byte, redirectURL string, session *saml.Session) string {
+	t.Helper()
+
+	return base64.StdEncoding.EncodeToString(f.ResponseXML(t, spMetadata, redirectURL, session))
+}
+
+// ResponseXML returns signed response XML to the authentication request from the redirect URL
+func (f *FakeSAMLIdP) ResponseXML(t *testing.T, spMetadata []byte, redirectURL string, session *saml.Session) []byte {
+	t.Helper()
+
+	sp, err := samlsp.ParseMetadata(spMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.sp = sp
+
+	r, err := http.NewRequest("GET", redirectURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := saml.NewIdpAuthnRequest(f.IDP, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = req.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatal(err)
+	}
+	if err = req.MakeResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
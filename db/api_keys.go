@@ -0,0 +1,103 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const apiKeySelect = "SELECT id, name, prefix, secret_hash, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys "
+
+// CreateAPIKey creates an API key record
+func (s *datastore) CreateAPIKey(k *models.APIKey) error {
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	if k.ExpiresAt != nil {
+		expiresAt := k.ExpiresAt.UTC()
+		k.ExpiresAt = &expiresAt
+	}
+
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO api_keys(name, prefix, secret_hash, scopes, expires_at) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at",
+		k.Name, k.Prefix, k.SecretHash, k.Scopes, k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+}
+
+// APIKeys returns all API keys including revoked ones
+func (s *datastore) APIKeys() (keys []models.APIKey, err error) {
+	rows, err := s.db.Query(ctx, apiKeySelect+"ORDER BY id")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
+
+// APIKeyByPrefix returns the API key with the prefix
+func (s *datastore) APIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(ctx, apiKeySelect+"WHERE prefix = $1", prefix))
+}
+
+// APIKeyByID returns the API key with the ID
+func (s *datastore) APIKeyByID(id int64) (*models.APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(ctx, apiKeySelect+"WHERE id = $1", id))
+}
+
+// TouchAPIKey records usage of the API key
+func (s *datastore) TouchAPIKey(id int64) error {
+	_, err := s.db.Exec(ctx, "UPDATE api_keys SET last_used_at = (NOW() AT TIME ZONE 'utc') WHERE id = $1", id)
+
+	return err
+}
+
+// RotateAPIKey replaces the prefix and the secret of the active API key
+func (s *datastore) RotateAPIKey(k *models.APIKey) error {
+	err := s.db.QueryRow(
+		ctx,
+		"UPDATE api_keys SET prefix = $2, secret_hash = $3, last_used_at = NULL WHERE id = $1 AND revoked_at IS NULL "+
+			"RETURNING name, scopes, expires_at, created_at",
+		k.ID, k.Prefix, k.SecretHash,
+	).Scan(&k.Name, &k.Scopes, &k.ExpiresAt, &k.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return zeroUpdatedRows
+	}
+
+	return err
+}
+
+// RevokeAPIKey disables the API key
+func (s *datastore) RevokeAPIKey(id int64) error {
+	commandTag, err := s.db.Exec(
+		ctx, "UPDATE api_keys SET revoked_at = (NOW() AT TIME ZONE 'utc') WHERE id = $1 AND revoked_at IS NULL", id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.SecretHash, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const auditEventSelect = "SELECT id, COALESCE(actor_id, 0), COALESCE(subject_id, 0), action, ip, user_agent, outcome, " +
+	"metadata, created_at FROM audit_events "
+
+// CreateAuditEvent appends a new record to audit_events database table
+func (s *datastore) CreateAuditEvent(e *models.AuditEvent) error {
+	if e.Metadata == nil {
+		e.Metadata = map[string]interface{}{}
+	}
+
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO audit_events(actor_id, subject_id, action, ip, user_agent, outcome, metadata) "+
+			"VALUES(NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, $7) RETURNING id, created_at",
+		e.ActorID, e.SubjectID, e.Action, e.IP, e.UserAgent, e.Outcome, e.Metadata,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// AuditEvents returns audit events matching the filter starting from the newest ones
+func (s *datastore) AuditEvents(f *models.AuditFilter) (events []models.AuditEvent, err error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.ActorID != 0 {
+		where("actor_id = $%d", f.ActorID)
+	}
+	if f.SubjectID != 0 {
+		where("subject_id = $%d", f.SubjectID)
+	}
+	if len(f.Action) > 0 {
+		where("action = $%d", f.Action)
+	}
+	if len(f.Outcome) > 0 {
+		where("outcome = $%d", f.Outcome)
+	}
+	if len(f.IP) > 0 {
+		where("ip = $%d", f.IP)
+	}
+	if !f.From.IsZero() {
+		where("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		where("created_at < $%d", f.To)
+	}
+	if f.Before != 0 {
+		where("id < $%d", f.Before)
+	}
+
+	query := auditEventSelect
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + " "
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf("ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+		err = rows.Scan(&e.ID, &e.ActorID, &e.SubjectID, &e.Action, &e.IP, &e.UserAgent, &e.Outcome, &e.Metadata, &e.CreatedAt)
+		if err != nil {
+			return
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,13 +23,67 @@ type DataLayer interface {
 	CreateUser(*models.User) error
 	UserExistsWithField(fl validator.FieldLevel) (bool, error)
 	UserByEmail(string) (*models.User, error)
+	UserByID(id int64) (*models.User, error)
+	UserByPhone(phone string) (*models.User, error)
 	StoreCache(key string, payload interface{}, exp time.Duration) error
+	IncrCache(key string, exp time.Duration) (int64, error)
+	StoreCacheNX(key string, payload interface{}, exp time.Duration) (bool, error)
 	DeleteCache(key string) (int64, error)
 	GetCacheValue(key string) (string, error)
 	DeleteUser(id int64) error
 	GetRoles() ([]string, error)
 	CreateRoles(names []string) error
 	DeleteRoles(names []string) error
+	SyncUserRoles(userID int64, managed, roles []string) error
+	SetTOTPSecret(userID int64, secret string) error
+	EnableTOTP(userID int64, codeHashes []string) error
+	DisableTOTP(userID int64) error
+	UseRecoveryCode(userID int64, codeHash string) (bool, error)
+	CreateWebAuthnCredential(c *models.WebAuthnCredential) error
+	WebAuthnCredentials(userID int64) ([]models.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(credentialID []byte, signCount uint32) error
+	CreateIdentity(identity *models.Identity) error
+	IdentityBySubject(provider, subject string) (*models.Identity, error)
+	UserIdentities(userID int64) ([]models.Identity, error)
+	DeleteIdentity(userID, id int64) error
+	SaveSAMLConnection(c *models.SAMLConnection) error
+	SAMLConnection(tenant string) (*models.SAMLConnection, error)
+	SAMLConnections() ([]models.SAMLConnection, error)
+	DeleteSAMLConnection(tenant string) error
+	SaveUserDevice(d *models.UserDevice) error
+	UserDevices(userID int64) ([]models.UserDevice, error)
+	CreateAuditEvent(e *models.AuditEvent) error
+	AuditEvents(f *models.AuditFilter) ([]models.AuditEvent, error)
+	CreateAPIKey(k *models.APIKey) error
+	APIKeys() ([]models.APIKey, error)
+	APIKeyByPrefix(prefix string) (*models.APIKey, error)
+	APIKeyByID(id int64) (*models.APIKey, error)
+	TouchAPIKey(id int64) error
+	RotateAPIKey(k *models.APIKey) error
+	RevokeAPIKey(id int64) error
+	SavePermission(p *models.Permission) error
+	Permissions() ([]models.Permission, error)
+	DeletePermission(name string) error
+	RolePermissions(role string) ([]string, error)
+	SetRolePermissions(role string, permissions []string) error
+	RoleHierarchy() ([]models.Role, error)
+	SetRoleParent(role, parent string) error
+	AddUserRoles(userIDs []int64, roles []string) error
+	RemoveUserRoles(userIDs []int64, roles []string) error
+	RoleMembers(role string, after int64, limit int) ([]models.User, error)
+	CreateInvitation(i *models.Invitation) error
+	Invitations(before int64, limit int) ([]models.Invitation, error)
+	InvitationByTokenID(tokenID string) (*models.Invitation, error)
+	AcceptInvitation(id, userID int64) error
+	RevokeInvitation(id int64) error
+	CreateOrganization(o *models.Organization) error
+	Organizations() ([]models.Organization, error)
+	Organization(slug string) (*models.Organization, error)
+	DeleteOrganization(slug string) error
+	SetMembership(slug string, userID int64, roles []string) error
+	RemoveMember(slug string, userID int64) error
+	Membership(slug string, userID int64) (*models.Membership, error)
+	Members(slug string, after int64, limit int) ([]models.Membership, error)
 	Close()
 	Migrate() error
 }
@@ -42,8 +97,12 @@ type dbErr string
 
 func (e dbErr) Error() string { return string(e) }
 
+// ErrCacheMiss is returned when the key is missing in the cache storage
+const ErrCacheMiss = dbErr("Key not found")
+
 const zeroDeleteRows = dbErr("No row found to delete")
 const zeroInsertedRows = dbErr("No rows have been inserted")
+const zeroUpdatedRows = dbErr("No rows have been updated")
 
 // Init initializes connection to the database
 func Init() (DataLayer, error) {
@@ -78,7 +137,10 @@ func (s *datastore) Migrate() error {
 	if err != nil {
 		return nil
 	}
-	sort.Strings(files)
+	// Files are applied in the order of their numeric prefixes
+	sort.Slice(files, func(i, j int) bool {
+		return migrationVersion(files[i]) < migrationVersion(files[j])
+	})
 	var sqlScript strings.Builder
 
 	for _, file := range files {
@@ -97,3 +159,9 @@ func (s *datastore) Migrate() error {
 
 	return nil
 }
+
+func migrationVersion(file string) int {
+	version, _ := strconv.Atoi(strings.SplitN(filepath.Base(file), "_", 2)[0])
+
+	return version
+}
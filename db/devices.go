@@ -0,0 +1,40 @@
+package db
+
+import (
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const userDeviceSelect = "SELECT id, user_id, device_hash, user_agent, ip, location, created_at, last_seen_at FROM user_devices "
+
+// SaveUserDevice creates a device record or updates the last login details of the known device
+func (s *datastore) SaveUserDevice(d *models.UserDevice) error {
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO user_devices(user_id, device_hash, user_agent, ip, location) VALUES($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (user_id, device_hash) DO UPDATE SET user_agent = EXCLUDED.user_agent, ip = EXCLUDED.ip, "+
+			"location = EXCLUDED.location, last_seen_at = (NOW() AT TIME ZONE 'utc') "+
+			"RETURNING id, created_at, last_seen_at",
+		d.UserID, d.DeviceHash, d.UserAgent, d.IP, d.Location,
+	).Scan(&d.ID, &d.CreatedAt, &d.LastSeenAt)
+}
+
+// UserDevices returns devices the user logged in from
+func (s *datastore) UserDevices(userID int64) (devices []models.UserDevice, err error) {
+	rows, err := s.db.Query(ctx, userDeviceSelect+"WHERE user_id = $1 ORDER BY last_seen_at DESC", userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.UserDevice
+		err = rows.Scan(&d.ID, &d.UserID, &d.DeviceHash, &d.UserAgent, &d.IP, &d.Location, &d.CreatedAt, &d.LastSeenAt)
+		if err != nil {
+			return
+		}
+
+		devices = append(devices, d)
+	}
+
+	return devices, rows.Err()
+}
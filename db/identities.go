@@ -0,0 +1,70 @@
+package db
+
+import (
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const identitySelect = "SELECT id, user_id, provider, subject, metadata, created_at FROM identities "
+
+// CreateIdentity creates a new record in identities database table
+func (s *datastore) CreateIdentity(identity *models.Identity) error {
+	if identity.Metadata == nil {
+		identity.Metadata = map[string]interface{}{}
+	}
+
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO identities(user_id, provider, subject, metadata) VALUES($1, $2, $3, $4) RETURNING id, created_at",
+		identity.UserID, identity.Provider, identity.Subject, identity.Metadata,
+	).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// IdentityBySubject returns an identity by the provider and the subject
+func (s *datastore) IdentityBySubject(provider, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := s.db.QueryRow(
+		ctx,
+		identitySelect+"WHERE provider = $1 AND subject = $2",
+		provider, subject,
+	).Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Metadata, &identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// UserIdentities returns identities linked to the user
+func (s *datastore) UserIdentities(userID int64) (identities []models.Identity, err error) {
+	rows, err := s.db.Query(ctx, identitySelect+"WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity models.Identity
+		err = rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Metadata, &identity.CreatedAt)
+		if err != nil {
+			return
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// DeleteIdentity removes an identity of the user
+func (s *datastore) DeleteIdentity(userID, id int64) error {
+	commandTag, err := s.db.Exec(ctx, "DELETE FROM identities WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroDeleteRows
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const invitationSelect = "SELECT id, email, roles, inviter, token_id, expires_at, accepted_at, user_id, revoked_at, created_at FROM invitations "
+
+// CreateInvitation creates an invitation record
+func (s *datastore) CreateInvitation(i *models.Invitation) error {
+	if i.Roles == nil {
+		i.Roles = []string{}
+	}
+	i.ExpiresAt = i.ExpiresAt.UTC()
+
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO invitations(email, roles, inviter, token_id, expires_at) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at",
+		i.Email, i.Roles, i.Inviter, i.TokenID, i.ExpiresAt,
+	).Scan(&i.ID, &i.CreatedAt)
+}
+
+// Invitations returns invitations created before the ID, newest first. Zero before returns the latest ones.
+func (s *datastore) Invitations(before int64, limit int) (invitations []models.Invitation, err error) {
+	rows, err := s.db.Query(ctx, invitationSelect+"WHERE ($1 = 0 OR id < $1) ORDER BY id DESC LIMIT $2", before, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, *i)
+	}
+
+	return invitations, rows.Err()
+}
+
+// InvitationByTokenID returns the invitation of the invite token
+func (s *datastore) InvitationByTokenID(tokenID string) (*models.Invitation, error) {
+	return scanInvitation(s.db.QueryRow(ctx, invitationSelect+"WHERE token_id = $1", tokenID))
+}
+
+// AcceptInvitation marks the pending invitation as accepted by the user
+func (s *datastore) AcceptInvitation(id, userID int64) error {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"UPDATE invitations SET accepted_at = (NOW() AT TIME ZONE 'utc'), user_id = $2 "+
+			"WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	return nil
+}
+
+// RevokeInvitation disables the pending invitation
+func (s *datastore) RevokeInvitation(id int64) error {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"UPDATE invitations SET revoked_at = (NOW() AT TIME ZONE 'utc') WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	return nil
+}
+
+func scanInvitation(row pgx.Row) (*models.Invitation, error) {
+	var i models.Invitation
+	err := row.Scan(&i.ID, &i.Email, &i.Roles, &i.Inviter, &i.TokenID, &i.ExpiresAt, &i.AcceptedAt, &i.UserID, &i.RevokedAt, &i.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+)
+
+// SetTOTPSecret stores a pending TOTP secret of the user
+func (s *datastore) SetTOTPSecret(userID int64, secret string) error {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"UPDATE users SET totp_secret = $1, totp_enabled = FALSE WHERE id = $2 AND totp_enabled = FALSE",
+		secret, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	return nil
+}
+
+// EnableTOTP enables TOTP of the user and replaces user recovery codes
+func (s *datastore) EnableTOTP(userID int64, codeHashes []string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	commandTag, err := tr.Exec(
+		ctx,
+		"UPDATE users SET totp_enabled = TRUE WHERE id = $1 AND totp_secret IS NOT NULL",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	if _, err = tr.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	for _, hash := range codeHashes {
+		batch.Queue("INSERT INTO recovery_codes(user_id, code_hash) VALUES($1, $2)", userID, hash)
+	}
+
+	br := tr.SendBatch(ctx, batch)
+
+	for i := 0; i < len(codeHashes); i++ {
+		ct, err := br.Exec()
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() != 1 {
+			return zeroInsertedRows
+		}
+	}
+
+	if err = br.Close(); err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
+
+// DisableTOTP removes TOTP secret and recovery codes of the user
+func (s *datastore) DisableTOTP(userID int64) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	_, err = tr.Exec(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tr.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used
+func (s *datastore) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"UPDATE recovery_codes SET used_at = (NOW() AT TIME ZONE 'utc') "+
+			"WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return commandTag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const unknownOrganization = dbErr("Unknown organization")
+
+// memberEffectiveRoles selects IDs of roles of the member and of all roles they inherit
+const memberEffectiveRoles = "WITH RECURSIVE effective(id) AS (SELECT role_id FROM organization_roles " +
+	"WHERE member_id = organization_members.id " + inheritedRoles
+
+const membershipSelect = "SELECT organizations.slug, organization_members.user_id, " +
+	"ARRAY(SELECT roles.name FROM organization_roles JOIN roles ON organization_roles.role_id = roles.id " +
+	"WHERE organization_roles.member_id = organization_members.id ORDER BY roles.name) AS roles, " +
+	"ARRAY(" + memberEffectiveRoles + effectiveRoleNames + ") AS effective_roles, " +
+	"ARRAY(" + memberEffectiveRoles + effectivePermissionNames + ") AS permissions, " +
+	"organization_members.created_at FROM organization_members " +
+	"JOIN organizations ON organization_members.organization_id = organizations.id "
+
+// CreateOrganization creates an organization record
+func (s *datastore) CreateOrganization(o *models.Organization) error {
+	return s.db.QueryRow(
+		ctx, "INSERT INTO organizations(slug, name) VALUES($1, $2) RETURNING id, created_at", o.Slug, o.Name,
+	).Scan(&o.ID, &o.CreatedAt)
+}
+
+// Organizations returns all organizations ordered by slug
+func (s *datastore) Organizations() (organizations []models.Organization, err error) {
+	rows, err := s.db.Query(ctx, "SELECT id, slug, name, created_at FROM organizations ORDER BY slug")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.Organization
+		if err = rows.Scan(&o.ID, &o.Slug, &o.Name, &o.CreatedAt); err != nil {
+			return
+		}
+
+		organizations = append(organizations, o)
+	}
+
+	return organizations, rows.Err()
+}
+
+// Organization returns the organization with the slug
+func (s *datastore) Organization(slug string) (*models.Organization, error) {
+	var o models.Organization
+	err := s.db.QueryRow(ctx, "SELECT id, slug, name, created_at FROM organizations WHERE slug = $1", slug).Scan(
+		&o.ID, &o.Slug, &o.Name, &o.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// DeleteOrganization removes the organization with memberships of its users
+func (s *datastore) DeleteOrganization(slug string) error {
+	commandTag, err := s.db.Exec(ctx, "DELETE FROM organizations WHERE slug = $1", slug)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroDeleteRows
+	}
+
+	return nil
+}
+
+// SetMembership adds the user to the organization and replaces roles of the user in it
+func (s *datastore) SetMembership(slug string, userID int64, roles []string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	organizationID, err := findOrganizationID(tr, slug)
+	if err != nil {
+		return err
+	}
+
+	roleIDs, err := findRoleIDs(tr, roles)
+	if err != nil {
+		return err
+	}
+
+	var memberID int64
+	err = tr.QueryRow(
+		ctx,
+		"INSERT INTO organization_members(organization_id, user_id) SELECT $1, id FROM users WHERE id = $2 "+
+			"ON CONFLICT (organization_id, user_id) DO UPDATE SET organization_id = EXCLUDED.organization_id RETURNING id",
+		organizationID, userID,
+	).Scan(&memberID)
+	if err == pgx.ErrNoRows {
+		return unknownUsers
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = tr.Exec(ctx, "DELETE FROM organization_roles WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+
+	_, err = tr.Exec(
+		ctx, "INSERT INTO organization_roles(member_id, role_id) SELECT $1, UNNEST($2::int[])", memberID, roleIDs,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
+
+// RemoveMember removes the user with roles of the user from the organization
+func (s *datastore) RemoveMember(slug string, userID int64) error {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"DELETE FROM organization_members USING organizations "+
+			"WHERE organization_members.organization_id = organizations.id AND organizations.slug = $1 AND organization_members.user_id = $2",
+		slug, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroDeleteRows
+	}
+
+	return nil
+}
+
+// Membership returns roles of the user in the organization
+func (s *datastore) Membership(slug string, userID int64) (*models.Membership, error) {
+	return scanMembership(s.db.QueryRow(
+		ctx, membershipSelect+"WHERE organizations.slug = $1 AND organization_members.user_id = $2", slug, userID,
+	))
+}
+
+// Members returns memberships of the organization ordered by user ID starting after the ID
+func (s *datastore) Members(slug string, after int64, limit int) (members []models.Membership, err error) {
+	var exists bool
+	if err = s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM organizations WHERE slug = $1)", slug).Scan(&exists); err != nil {
+		return
+	}
+	if !exists {
+		return nil, unknownOrganization
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		membershipSelect+"WHERE organizations.slug = $1 AND organization_members.user_id > $2 ORDER BY organization_members.user_id LIMIT $3",
+		slug, after, limit,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, *m)
+	}
+
+	return members, rows.Err()
+}
+
+func findOrganizationID(tr pgx.Tx, slug string) (id int64, err error) {
+	err = tr.QueryRow(ctx, "SELECT id FROM organizations WHERE slug = $1", slug).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, unknownOrganization
+	}
+
+	return
+}
+
+func scanMembership(row pgx.Row) (*models.Membership, error) {
+	var m models.Membership
+	err := row.Scan(&m.Organization, &m.UserID, &m.Roles, &m.EffectiveRoles, &m.Permissions, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
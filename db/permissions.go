@@ -0,0 +1,118 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const unknownPermissions = dbErr("Unknown permissions")
+
+// SavePermission creates a permission or updates the description of the existing one
+func (s *datastore) SavePermission(p *models.Permission) error {
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO permissions(name, description) VALUES($1, $2) "+
+			"ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description RETURNING id, created_at",
+		p.Name, p.Description,
+	).Scan(&p.ID, &p.CreatedAt)
+}
+
+// Permissions returns all permissions ordered by name
+func (s *datastore) Permissions() (permissions []models.Permission, err error) {
+	rows, err := s.db.Query(ctx, "SELECT id, name, description, created_at FROM permissions ORDER BY name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Permission
+		if err = rows.Scan(&p.ID, &p.Name, &p.Description, &p.CreatedAt); err != nil {
+			return
+		}
+
+		permissions = append(permissions, p)
+	}
+
+	return permissions, rows.Err()
+}
+
+// DeletePermission removes the permission from all roles
+func (s *datastore) DeletePermission(name string) error {
+	commandTag, err := s.db.Exec(ctx, "DELETE FROM permissions WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroDeleteRows
+	}
+
+	return nil
+}
+
+// RolePermissions returns names of permissions granted to the role
+func (s *datastore) RolePermissions(role string) (permissions []string, err error) {
+	var roleID int64
+	err = s.db.QueryRow(ctx, "SELECT id FROM roles WHERE name = $1", role).Scan(&roleID)
+	if err == pgx.ErrNoRows {
+		return nil, unknownRole
+	}
+	if err != nil {
+		return
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT permissions.name FROM role_permissions JOIN permissions ON role_permissions.permission_id = permissions.id "+
+			"WHERE role_permissions.role_id = $1 ORDER BY permissions.name",
+		roleID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return
+		}
+
+		permissions = append(permissions, name)
+	}
+
+	return permissions, rows.Err()
+}
+
+// SetRolePermissions replaces permissions of the role, all of the permissions must exist
+func (s *datastore) SetRolePermissions(role string, permissions []string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	roleID, err := findRoleID(tr, role)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tr.Exec(ctx, "DELETE FROM role_permissions WHERE role_id = $1", roleID); err != nil {
+		return err
+	}
+
+	commandTag, err := tr.Exec(
+		ctx,
+		"INSERT INTO role_permissions(role_id, permission_id) SELECT $1, id FROM permissions WHERE name = ANY($2::varchar[])",
+		roleID, permissions,
+	)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != int64(len(permissions)) {
+		return unknownPermissions
+	}
+
+	return tr.Commit(ctx)
+}
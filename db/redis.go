@@ -2,6 +2,8 @@ package db
 
 import (
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 // StoreCache stores key/value to the storage with expiration time
@@ -14,17 +16,41 @@ func (s *datastore) StoreCache(key string, payload interface{}, exp time.Duratio
 	return nil
 }
 
+// StoreCacheNX stores key/value to the storage only if the key doesn't exist yet
+func (s *datastore) StoreCacheNX(key string, payload interface{}, exp time.Duration) (bool, error) {
+	return s.rdb.SetNX(ctx, key, payload, exp).Result()
+}
+
 // DeleteCache removes key from the storage
 func (s *datastore) DeleteCache(key string) (int64, error) {
 	return s.rdb.Del(ctx, key).Result()
 }
 
-// GetCacheValue returns value from the storage by the key
+// GetCacheValue returns value from the storage by the key or ErrCacheMiss for missing keys
 func (s *datastore) GetCacheValue(key string) (string, error) {
 	v, err := s.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
 	if err != nil {
 		return "", err
 	}
 
 	return v, nil
 }
+
+// IncrCache increments a counter and sets its expiration time when the counter is created
+func (s *datastore) IncrCache(key string, exp time.Duration) (int64, error) {
+	v, err := s.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if v == 1 {
+		if err = s.rdb.Expire(ctx, key, exp).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return v, nil
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v4"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const unknownRole = dbErr("Unknown role")
+const unknownUsers = dbErr("Unknown users")
+const roleCycle = dbErr("Role can't inherit itself")
+
+// RoleHierarchy returns all roles with names of their parents
+func (s *datastore) RoleHierarchy() (roles []models.Role, err error) {
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT roles.id, roles.name, COALESCE(parents.name, '') FROM roles "+
+			"LEFT JOIN roles AS parents ON roles.parent_id = parents.id ORDER BY roles.name",
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role models.Role
+		if err = rows.Scan(&role.ID, &role.Name, &role.Parent); err != nil {
+			return
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// SetRoleParent makes the role inherited by the parent, a blank parent detaches the role.
+// Parents can't be inherited by their own ancestors.
+func (s *datastore) SetRoleParent(role, parent string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	// Concurrent changes could create a cycle passing the check below
+	if _, err = tr.Exec(ctx, "LOCK TABLE roles IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	roleID, err := findRoleID(tr, role)
+	if err != nil {
+		return err
+	}
+
+	var parentID int64
+	if len(parent) > 0 {
+		if parentID, err = findRoleID(tr, parent); err != nil {
+			return err
+		}
+
+		var cycle bool
+		err = tr.QueryRow(
+			ctx,
+			"WITH RECURSIVE ancestors(id) AS (SELECT $1::int "+
+				"UNION SELECT roles.parent_id FROM roles JOIN ancestors ON roles.id = ancestors.id WHERE roles.parent_id IS NOT NULL) "+
+				"SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)",
+			parentID, roleID,
+		).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return roleCycle
+		}
+	}
+
+	if _, err = tr.Exec(ctx, "UPDATE roles SET parent_id = NULLIF($2, 0) WHERE id = $1", roleID, parentID); err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
+
+// AddUserRoles assigns all of the roles to all of the users
+func (s *datastore) AddUserRoles(userIDs []int64, roles []string) error {
+	return s.changeUserRoles(
+		userIDs, roles,
+		"INSERT INTO user_roles(user_id, role_id) SELECT users.id, roles.id FROM UNNEST($1::bigint[]) AS users(id) "+
+			"CROSS JOIN UNNEST($2::int[]) AS roles(id) ON CONFLICT (role_id, user_id) DO NOTHING",
+	)
+}
+
+// RemoveUserRoles unassigns all of the roles from all of the users
+func (s *datastore) RemoveUserRoles(userIDs []int64, roles []string) error {
+	return s.changeUserRoles(userIDs, roles, "DELETE FROM user_roles WHERE user_id = ANY($1::bigint[]) AND role_id = ANY($2::int[])")
+}
+
+// changeUserRoles runs the statement with IDs of the users and the roles when all of them exist
+func (s *datastore) changeUserRoles(userIDs []int64, roles []string, statement string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	roleIDs, err := findRoleIDs(tr, roles)
+	if err != nil {
+		return err
+	}
+
+	var users int
+	// Users are locked so that they can't be deleted before the roles are changed
+	err = tr.QueryRow(
+		ctx, "SELECT COUNT(*) FROM (SELECT id FROM users WHERE id = ANY($1::bigint[]) FOR UPDATE) AS locked", userIDs,
+	).Scan(&users)
+	if err != nil {
+		return err
+	}
+	if users != len(userIDs) {
+		return unknownUsers
+	}
+
+	if _, err = tr.Exec(ctx, statement, userIDs, roleIDs); err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
+
+// RoleMembers returns users the role is assigned to ordered by ID starting after the ID
+func (s *datastore) RoleMembers(role string, after int64, limit int) (users []models.User, err error) {
+	var roleID int64
+	err = s.db.QueryRow(ctx, "SELECT id FROM roles WHERE name = $1", role).Scan(&roleID)
+	if err == pgx.ErrNoRows {
+		return nil, unknownRole
+	}
+	if err != nil {
+		return
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT users.id, COALESCE(email, ''), COALESCE(phone, ''), created_at FROM users "+
+			"JOIN user_roles ON users.id = user_roles.user_id WHERE user_roles.role_id = $1 AND users.id > $2 "+
+			"ORDER BY users.id LIMIT $3",
+		roleID, after, limit,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err = rows.Scan(&user.ID, &user.Email, &user.Phone, &user.CreatedAt); err != nil {
+			return
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// findRoleIDs returns IDs of all of the roles or an error when any of them is missing
+func findRoleIDs(tr pgx.Tx, names []string) (ids []int32, err error) {
+	err = tr.QueryRow(
+		ctx, "SELECT COALESCE(ARRAY_AGG(id), '{}') FROM roles WHERE name = ANY($1::varchar[])", names,
+	).Scan(&ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != len(names) {
+		return nil, unknownRole
+	}
+
+	return ids, nil
+}
+
+func findRoleID(tr pgx.Tx, name string) (id int64, err error) {
+	err = tr.QueryRow(ctx, "SELECT id FROM roles WHERE name = $1", name).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, unknownRole
+	}
+
+	return
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const samlConnectionSelect = "SELECT id, tenant, idp_entity_id, idp_metadata, domains, email_attribute, role_attribute, " +
+	"attribute_roles, default_roles, created_at FROM saml_connections "
+
+// SaveSAMLConnection creates a SAML connection or replaces settings of the existing tenant connection
+func (s *datastore) SaveSAMLConnection(c *models.SAMLConnection) error {
+	if c.AttributeRoles == nil {
+		c.AttributeRoles = map[string][]string{}
+	}
+	if c.Domains == nil {
+		c.Domains = []string{}
+	}
+	if c.DefaultRoles == nil {
+		c.DefaultRoles = []string{}
+	}
+
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO saml_connections(tenant, idp_entity_id, idp_metadata, domains, email_attribute, role_attribute, attribute_roles, default_roles) "+
+			"VALUES($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (tenant) DO UPDATE SET "+
+			"idp_entity_id = EXCLUDED.idp_entity_id, idp_metadata = EXCLUDED.idp_metadata, domains = EXCLUDED.domains, "+
+			"email_attribute = EXCLUDED.email_attribute, role_attribute = EXCLUDED.role_attribute, "+
+			"attribute_roles = EXCLUDED.attribute_roles, default_roles = EXCLUDED.default_roles "+
+			"RETURNING id, created_at",
+		c.Tenant, c.IdPEntityID, c.IdPMetadata, c.Domains, c.EmailAttribute, c.RoleAttribute, c.AttributeRoles, c.DefaultRoles,
+	).Scan(&c.ID, &c.CreatedAt)
+}
+
+// SAMLConnection returns the SAML connection of the tenant
+func (s *datastore) SAMLConnection(tenant string) (*models.SAMLConnection, error) {
+	var c models.SAMLConnection
+	err := s.db.QueryRow(ctx, samlConnectionSelect+"WHERE tenant = $1", tenant).Scan(
+		&c.ID, &c.Tenant, &c.IdPEntityID, &c.IdPMetadata, &c.Domains, &c.EmailAttribute, &c.RoleAttribute,
+		&c.AttributeRoles, &c.DefaultRoles, &c.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// SAMLConnections returns SAML connections of all tenants
+func (s *datastore) SAMLConnections() (connections []models.SAMLConnection, err error) {
+	rows, err := s.db.Query(ctx, samlConnectionSelect+"ORDER BY tenant")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.SAMLConnection
+		err = rows.Scan(
+			&c.ID, &c.Tenant, &c.IdPEntityID, &c.IdPMetadata, &c.Domains, &c.EmailAttribute, &c.RoleAttribute,
+			&c.AttributeRoles, &c.DefaultRoles, &c.CreatedAt,
+		)
+		if err != nil {
+			return
+		}
+
+		connections = append(connections, c)
+	}
+
+	return connections, rows.Err()
+}
+
+// DeleteSAMLConnection removes the SAML connection of the tenant
+func (s *datastore) DeleteSAMLConnection(tenant string) error {
+	commandTag, err := s.db.Exec(ctx, "DELETE FROM saml_connections WHERE tenant = $1", tenant)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroDeleteRows
+	}
+
+	return nil
+}
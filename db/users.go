@@ -1,8 +1,6 @@
 package db
 
 import (
-	"strings"
-
 	"github.com/go-playground/validator"
 	"github.com/jackc/pgx/v4"
 	"github.com/maxshend/tiny_goauth/models"
@@ -18,8 +16,8 @@ func (s *datastore) CreateUser(user *models.User) error {
 
 	err = tr.QueryRow(
 		ctx,
-		"INSERT INTO users(email, password) VALUES($1, $2) RETURNING id, created_at",
-		user.Email, user.Password,
+		"INSERT INTO users(email, password, phone) VALUES(NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, '')) RETURNING id, created_at",
+		user.Email, user.Password, user.Phone,
 	).Scan(&user.ID, &user.CreatedAt)
 	if err != nil {
 		return err
@@ -49,6 +47,12 @@ func (s *datastore) CreateUser(user *models.User) error {
 	if err = br.Close(); err != nil {
 		return err
 	}
+	err = tr.QueryRow(ctx, "SELECT "+userEffectiveRoles+", "+userPermissions+" FROM users WHERE id = $1", user.ID).Scan(
+		&user.EffectiveRoles, &user.Permissions,
+	)
+	if err != nil {
+		return err
+	}
 	if err = tr.Commit(ctx); err != nil {
 		return err
 	}
@@ -69,16 +73,51 @@ func (s *datastore) UserExistsWithField(fl validator.FieldLevel) (bool, error) {
 	return result, nil
 }
 
+// inheritedRoles completes the recursive effective CTE with all roles inherited by the assigned ones
+const inheritedRoles = "UNION SELECT roles.id FROM roles JOIN effective ON roles.parent_id = effective.id) "
+
+const effectiveRoleNames = "SELECT roles.name FROM roles JOIN effective ON roles.id = effective.id ORDER BY roles.name"
+
+// effectivePermissionNames selects names of permissions granted to the effective roles
+const effectivePermissionNames = "SELECT DISTINCT permissions.name FROM effective " +
+	"JOIN role_permissions ON effective.id = role_permissions.role_id " +
+	"JOIN permissions ON role_permissions.permission_id = permissions.id ORDER BY permissions.name"
+
+// effectiveRoles selects IDs of roles of the user and of all roles they inherit
+const effectiveRoles = "WITH RECURSIVE effective(id) AS (SELECT role_id FROM user_roles WHERE user_id = users.id " + inheritedRoles
+
+const userEffectiveRoles = "ARRAY(" + effectiveRoles + effectiveRoleNames + ") AS effective_roles"
+
+const userPermissions = "ARRAY(" + effectiveRoles + effectivePermissionNames + ") AS permissions"
+
+const userSelect = "SELECT users.id AS id, COALESCE(email, ''), COALESCE(password, ''), COALESCE(phone, ''), " +
+	"COALESCE(totp_secret, ''), totp_enabled, users.created_at, " +
+	"ARRAY_REMOVE(ARRAY_AGG(roles.name), NULL) AS roles, " + userEffectiveRoles + ", " + userPermissions + " FROM users " +
+	"LEFT JOIN user_roles ON users.id = user_roles.user_id " +
+	"LEFT JOIN roles ON user_roles.role_id = roles.id "
+
 func (s *datastore) UserByEmail(email string) (*models.User, error) {
+	return s.userBy("email = $1", email)
+}
+
+func (s *datastore) UserByPhone(phone string) (*models.User, error) {
+	return s.userBy("phone = $1", phone)
+}
+
+func (s *datastore) UserByID(id int64) (*models.User, error) {
+	return s.userBy("users.id = $1", id)
+}
+
+func (s *datastore) userBy(condition string, arg interface{}) (*models.User, error) {
 	var user models.User
 	err := s.db.QueryRow(
 		ctx,
-		"SELECT users.id AS id, email, password, created_at, ARRAY_REMOVE(ARRAY_AGG(roles.name), NULL) AS roles FROM users "+
-			"LEFT JOIN user_roles ON users.id = user_roles.user_id "+
-			"LEFT JOIN roles ON user_roles.role_id = roles.id WHERE email = $1 GROUP BY users.id "+
-			"LIMIT 1",
-		email,
-	).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.Roles)
+		userSelect+"WHERE "+condition+" GROUP BY users.id LIMIT 1",
+		arg,
+	).Scan(
+		&user.ID, &user.Email, &user.Password, &user.Phone, &user.TOTPSecret, &user.TOTPEnabled, &user.CreatedAt,
+		&user.Roles, &user.EffectiveRoles, &user.Permissions,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +184,7 @@ func (s *datastore) CreateRoles(names []string) (err error) {
 }
 
 func (s *datastore) DeleteRoles(names []string) error {
-	commandTag, err := s.db.Exec(ctx, "DELETE FROM roles WHERE name = ANY($1::varchar[])", "{"+strings.Join(names, ",")+"}")
+	commandTag, err := s.db.Exec(ctx, "DELETE FROM roles WHERE name = ANY($1::varchar[])", names)
 	if err != nil {
 		return err
 	}
@@ -156,3 +195,35 @@ func (s *datastore) DeleteRoles(names []string) error {
 
 	return nil
 }
+
+// SyncUserRoles replaces the managed roles of the user with the roles keeping roles granted otherwise.
+// Role names missing in roles table are ignored.
+func (s *datastore) SyncUserRoles(userID int64, managed, roles []string) error {
+	tr, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Rollback(ctx)
+
+	_, err = tr.Exec(
+		ctx,
+		"DELETE FROM user_roles USING roles WHERE user_roles.role_id = roles.id AND user_roles.user_id = $1 "+
+			"AND roles.name = ANY($2::varchar[]) AND NOT roles.name = ANY($3::varchar[])",
+		userID, managed, roles,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tr.Exec(
+		ctx,
+		"INSERT INTO user_roles(user_id, role_id) SELECT $1, id FROM roles WHERE name = ANY($2::varchar[]) "+
+			"ON CONFLICT (role_id, user_id) DO NOTHING",
+		userID, roles,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tr.Commit(ctx)
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// CreateWebAuthnCredential creates a new record in webauthn_credentials database table
+func (s *datastore) CreateWebAuthnCredential(c *models.WebAuthnCredential) error {
+	return s.db.QueryRow(
+		ctx,
+		"INSERT INTO webauthn_credentials(user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports) "+
+			"VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at",
+		c.UserID, c.CredentialID, c.PublicKey, c.AttestationType, c.AAGUID, int64(c.SignCount), c.Transports,
+	).Scan(&c.ID, &c.CreatedAt)
+}
+
+// WebAuthnCredentials returns passkeys of the user
+func (s *datastore) WebAuthnCredentials(userID int64) (credentials []models.WebAuthnCredential, err error) {
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, created_at "+
+			"FROM webauthn_credentials WHERE user_id = $1 ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.WebAuthnCredential
+		var signCount int64
+
+		err = rows.Scan(&c.ID, &c.UserID, &c.CredentialID, &c.PublicKey, &c.AttestationType, &c.AAGUID, &signCount, &c.Transports, &c.CreatedAt)
+		if err != nil {
+			return
+		}
+		c.SignCount = uint32(signCount)
+
+		credentials = append(credentials, c)
+	}
+
+	return credentials, rows.Err()
+}
+
+// UpdateWebAuthnSignCount stores the signature counter of the passkey after a successful assertion
+func (s *datastore) UpdateWebAuthnSignCount(credentialID []byte, signCount uint32) error {
+	commandTag, err := s.db.Exec(
+		ctx,
+		"UPDATE webauthn_credentials SET sign_count = $1, last_used_at = (NOW() AT TIME ZONE 'utc') WHERE credential_id = $2",
+		int64(signCount), credentialID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() != 1 {
+		return zeroUpdatedRows
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package geoip
+
+import (
+	"net"
+	"os"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator resolves IP addresses to human readable locations
+type Locator interface {
+	// Locate returns the location of the IP address or an empty string when it's unknown
+	Locate(ip string) string
+	Close() error
+}
+
+// Database is a locator backed by a local MaxMind City or Country database file
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+type record struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+const language = "en"
+
+// Load opens the database file specified in the environment.
+// It returns nil when the location lookup isn't configured.
+func Load() (Locator, error) {
+	path := os.Getenv("GEOIP_DB_PATH")
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{reader: reader}, nil
+}
+
+// Locate returns "City, Country" or only the country when the city is unknown
+func (d *Database) Locate(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	var r record
+	if err := d.reader.Lookup(addr, &r); err != nil {
+		return ""
+	}
+
+	country := r.Country.Names[language]
+	if city := r.City.Names[language]; len(city) > 0 && len(country) > 0 {
+		return city + ", " + country
+	}
+
+	return country
+}
+
+// Close closes the database file
+func (d *Database) Close() error {
+	return d.reader.Close()
+}
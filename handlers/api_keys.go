@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const apiKeyScheme = "ApiKey "
+
+const (
+	blankAPIKeyName   = handlerErr("Blank API Key Name")
+	blankScopes       = handlerErr("Blank Scopes")
+	unknownScope      = handlerErr("Unknown Scope")
+	invalidExpiration = handlerErr("Expiration must be in the future")
+	invalidAPIKeyID   = handlerErr("Invalid API Key ID")
+	inactiveAPIKey    = handlerErr("API key is revoked or expired")
+	missingScope      = handlerErr("API key doesn't have the scope of the operation")
+	exceedingScopes   = handlerErr("API key can't manage keys with scopes it doesn't have")
+)
+
+// internalScopes are scopes API keys need to call internal API paths
+var internalScopes = map[string]string{
+	"/internal/users/delete":                 "users:delete",
+	"/internal/users/unlock":                 "users:unlock",
+	"/internal/roles":                        "roles:write",
+	"/internal/roles/delete":                 "roles:write",
+	"/internal/audit-events":                 "audit:read",
+	"/internal/saml/connections":             "saml:write",
+	"/internal/saml/connections/list":        "saml:read",
+	"/internal/saml/connections/delete":      "saml:write",
+	"/internal/api-keys":                     "api_keys:write",
+	"/internal/api-keys/list":                "api_keys:read",
+	"/internal/api-keys/rotate":              "api_keys:write",
+	"/internal/api-keys/delete":              "api_keys:write",
+	"/internal/permissions":                  "permissions:write",
+	"/internal/permissions/list":             "permissions:read",
+	"/internal/permissions/delete":           "permissions:write",
+	"/internal/roles/permissions":            "roles:write",
+	"/internal/roles/permissions/list":       "roles:read",
+	"/internal/users/authorize":              "permissions:read",
+	"/internal/users/roles":                  "roles:read",
+	"/internal/roles/parent":                 "roles:write",
+	"/internal/roles/tree":                   "roles:read",
+	"/internal/roles/members":                "roles:read",
+	"/internal/users/roles/add":              "roles:write",
+	"/internal/users/roles/remove":           "roles:write",
+	"/internal/invitations":                  "invitations:write",
+	"/internal/invitations/list":             "invitations:read",
+	"/internal/invitations/delete":           "invitations:write",
+	"/internal/organizations":                "organizations:write",
+	"/internal/organizations/list":           "organizations:read",
+	"/internal/organizations/delete":         "organizations:write",
+	"/internal/organizations/members":        "organizations:write",
+	"/internal/organizations/members/list":   "organizations:read",
+	"/internal/organizations/members/delete": "organizations:write",
+}
+
+type apiKeyParams struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// apiKeyResponse contains the key itself which is shown only once
+type apiKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey creates an API key for the internal API
+func CreateAPIKey(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params apiKeyParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if err = validateAPIKeyParams(&params); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if exceedsCallerScopes(r, params.Scopes) {
+			respondError(w, http.StatusForbidden, exceedingScopes)
+			return
+		}
+
+		key, prefix, secret, err := auth.APIKey()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		apiKey := &models.APIKey{
+			Name:       params.Name,
+			Prefix:     prefix,
+			SecretHash: auth.HashNonce(secret),
+			Scopes:     params.Scopes,
+			ExpiresAt:  params.ExpiresAt,
+		}
+		if err = deps.DB.CreateAPIKey(apiKey); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditAPIKeyCreate, Outcome: auditSuccess, Metadata: apiKeyMetadata(apiKey)})
+		respond(w, http.StatusOK, &apiKeyResponse{APIKey: apiKey, Key: key})
+	})))))
+}
+
+// ListAPIKeys returns all API keys without their secrets
+func ListAPIKeys(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := deps.DB.APIKeys()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if keys == nil {
+			keys = []models.APIKey{}
+		}
+
+		respond(w, http.StatusOK, map[string][]models.APIKey{"api_keys": keys})
+	}))))
+}
+
+// RotateAPIKey replaces the secret of the API key, the previous secret stops working immediately
+func RotateAPIKey(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidAPIKeyID)
+			return
+		}
+
+		if err = checkManagedAPIKey(deps, r, id); err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		key, prefix, secret, err := auth.APIKey()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		apiKey := &models.APIKey{ID: id, Prefix: prefix, SecretHash: auth.HashNonce(secret)}
+		if err = deps.DB.RotateAPIKey(apiKey); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditAPIKeyRotate, Outcome: auditSuccess, Metadata: apiKeyMetadata(apiKey)})
+		respond(w, http.StatusOK, &apiKeyResponse{APIKey: apiKey, Key: key})
+	}))))
+}
+
+// RevokeAPIKey disables the API key
+func RevokeAPIKey(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidAPIKeyID)
+			return
+		}
+
+		if err = checkManagedAPIKey(deps, r, id); err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		if err = deps.DB.RevokeAPIKey(id); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditAPIKeyRevoke, Outcome: auditSuccess, Metadata: map[string]interface{}{"api_key_id": id}})
+	}))))
+}
+
+// exceedsCallerScopes reports whether the request is made with a scoped API key missing any of the scopes
+func exceedsCallerScopes(r *http.Request, scopes []string) bool {
+	caller, ok := r.Context().Value(apiKeyKey).(*models.APIKey)
+	if !ok {
+		return false
+	}
+
+	for _, scope := range scopes {
+		if !caller.HasScope(scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkManagedAPIKey returns an error when a scoped API key tries to manage a key with scopes it doesn't have.
+// Unknown keys get the same error so that scoped keys can't probe IDs of other keys.
+func checkManagedAPIKey(deps *Deps, r *http.Request, id int64) error {
+	if _, ok := r.Context().Value(apiKeyKey).(*models.APIKey); !ok {
+		return nil
+	}
+
+	target, err := deps.DB.APIKeyByID(id)
+	if err != nil || exceedsCallerScopes(r, target.Scopes) {
+		return exceedingScopes
+	}
+
+	return nil
+}
+
+func validateAPIKeyParams(params *apiKeyParams) error {
+	if params.Name = strings.TrimSpace(params.Name); len(params.Name) == 0 {
+		return blankAPIKeyName
+	}
+	if len(params.Scopes) == 0 {
+		return blankScopes
+	}
+	for _, scope := range params.Scopes {
+		if !knownScope(scope) {
+			return unknownScope
+		}
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		return invalidExpiration
+	}
+
+	return nil
+}
+
+func knownScope(scope string) bool {
+	for _, s := range internalScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func apiKeyMetadata(k *models.APIKey) map[string]interface{} {
+	return map[string]interface{}{"api_key_id": k.ID, "prefix": k.Prefix, "scopes": k.Scopes}
+}
+
+// verifyScopedAPIKey authenticates "Authorization: ApiKey <prefix>.<secret>" headers
+// of active API keys having the scope of the requested path
+func verifyScopedAPIKey(deps *Deps, r *http.Request) (*models.APIKey, error) {
+	prefix, secret, ok := auth.ParseAPIKey(strings.TrimPrefix(r.Header.Get(auhtorizationHeader), apiKeyScheme))
+	if !ok {
+		return nil, invalidInternalAPIKey
+	}
+
+	apiKey, err := deps.DB.APIKeyByPrefix(prefix)
+	if err != nil || !auth.ValidateNonce(secret, apiKey.SecretHash) {
+		return nil, invalidInternalAPIKey
+	}
+	if !apiKey.Active(time.Now()) {
+		return nil, inactiveAPIKey
+	}
+	if !apiKey.HasScope(internalScopes[r.URL.Path]) {
+		return nil, missingScope
+	}
+
+	if err = deps.DB.TouchAPIKey(apiKey.ID); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+
+	return apiKey, nil
+}
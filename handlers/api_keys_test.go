@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const testAPIKeySecret = "secret"
+
+var testAPIKeyExpiredAt = time.Now().Add(-time.Hour)
+var testAPIKeyRevokedAt = time.Now().Add(-time.Hour)
+
+// testAPIKeys are returned by their prefixes, all of them have the testAPIKeySecret secret
+var testAPIKeys = map[string]models.APIKey{
+	"a1b2c3d4": {ID: 1, Name: "deploy", Prefix: "a1b2c3d4", SecretHash: auth.HashNonce(testAPIKeySecret), Scopes: []string{"roles:write", "api_keys:read"}},
+	"b1b2b3b4": {ID: 4, Name: "provisioner", Prefix: "b1b2b3b4", SecretHash: auth.HashNonce(testAPIKeySecret), Scopes: []string{"api_keys:write", "roles:read"}},
+	"e1e2e3e4": {ID: 2, Name: "expired", Prefix: "e1e2e3e4", SecretHash: auth.HashNonce(testAPIKeySecret), Scopes: []string{"roles:write"}, ExpiresAt: &testAPIKeyExpiredAt},
+	"f1f2f3f4": {ID: 3, Name: "revoked", Prefix: "f1f2f3f4", SecretHash: auth.HashNonce(testAPIKeySecret), Scopes: []string{"roles:write"}, RevokedAt: &testAPIKeyRevokedAt},
+}
+
+func scopedKeyHeaders(prefix, secret string) map[string]string {
+	return map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: apiKeyScheme + prefix + "." + secret}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	path := "/internal/api-keys"
+
+	t.Run("returns key only once", func(t *testing.T) {
+		body := []byte(`{"name": "deploy", "scopes": ["roles:write"]}`)
+		recorder := performRequest(t, "POST", path, CreateAPIKey, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var response map[string]interface{}
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		prefix, _, ok := auth.ParseAPIKey(response["key"].(string))
+		if !ok || prefix != response["prefix"] {
+			t.Errorf("got unexpected key %v", response)
+		}
+		if _, ok := response["secret_hash"]; ok {
+			t.Error("expected response without secret hash")
+		}
+		if e := lastAuditEvent(t); e.Action != auditAPIKeyCreate || e.Metadata["prefix"] != prefix {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns key with scopes of the calling API key", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "reader", "scopes": ["roles:read"]}`)
+		recorder := performRequest(t, "POST", path, CreateAPIKey, body, scopedKeyHeaders("b1b2b3b4", testAPIKeySecret), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns Forbidden with scopes the calling API key doesn't have", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "deploy", "scopes": ["roles:read", "users:delete"]}`)
+		recorder := performRequest(t, "POST", path, CreateAPIKey, body, scopedKeyHeaders("b1b2b3b4", testAPIKeySecret), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+
+		if !strings.Contains(recorder.Body.String(), exceedingScopes.Error()) {
+			t.Errorf("expected %q error, got %q", exceedingScopes, recorder.Body.String())
+		}
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  handlerErr
+	}{
+		"blank name":      {`{"name": " ", "scopes": ["roles:write"]}`, blankAPIKeyName},
+		"blank scopes":    {`{"name": "deploy"}`, blankScopes},
+		"unknown scope":   {`{"name": "deploy", "scopes": ["users:create"]}`, unknownScope},
+		"past expiration": {`{"name": "deploy", "scopes": ["roles:write"], "expires_at": "2020-01-01T00:00:00Z"}`, invalidExpiration},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, CreateAPIKey, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err.Error()) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	recorder := performRequest(t, "GET", "/internal/api-keys/list", ListAPIKeys, nil, internalHeaders, nil)
+
+	authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+	var response map[string][]map[string]interface{}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response["api_keys"]) != len(testAPIKeys) {
+		t.Errorf("got unexpected keys %v", response)
+	}
+	for _, k := range response["api_keys"] {
+		if _, ok := k["secret_hash"]; ok {
+			t.Errorf("expected key without secret hash, got %v", k)
+		}
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	t.Run("returns new key", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/api-keys/rotate?id=1", RotateAPIKey, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var response map[string]interface{}
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		if prefix, _, ok := auth.ParseAPIKey(response["key"].(string)); !ok || prefix == "a1b2c3d4" || response["name"] != "deploy" {
+			t.Errorf("got unexpected key %v", response)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity for revoked key", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/api-keys/rotate?id=3", RotateAPIKey, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns new key of the calling API key", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/api-keys/rotate?id=4", RotateAPIKey, nil, scopedKeyHeaders("b1b2b3b4", testAPIKeySecret), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns Forbidden for key with scopes the calling API key doesn't have", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/api-keys/rotate?id=1", RotateAPIKey, nil, scopedKeyHeaders("b1b2b3b4", testAPIKeySecret), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+
+		if strings.Contains(recorder.Body.String(), `"key"`) {
+			t.Errorf("expected no key, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	t.Run("returns OK", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/api-keys/delete?id=1", RevokeAPIKey, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditAPIKeyRevoke {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns Forbidden for key with scopes the calling API key doesn't have", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/api-keys/delete?id=1", RevokeAPIKey, nil, scopedKeyHeaders("b1b2b3b4", testAPIKeySecret), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+	})
+
+	t.Run("returns UnprocessableEntity with invalid ID", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/api-keys/delete?id=key", RevokeAPIKey, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestScopedAPIKeyAuthentication(t *testing.T) {
+	body := []byte(`{"roles": ["staff"]}`)
+
+	t.Run("records API key actor", func(t *testing.T) {
+		headers := scopedKeyHeaders("a1b2c3d4", testAPIKeySecret)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, bytes.NewBuffer(body), headers, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditRolesCreated || e.Metadata["actor"] != "api_key:a1b2c3d4" {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	unauthorized := map[string]struct {
+		headers map[string]string
+		path    string
+		handler func(*Deps) http.Handler
+		err     handlerErr
+	}{
+		"invalid secret": {scopedKeyHeaders("a1b2c3d4", "invalid"), "/internal/roles", CreateRoles, invalidInternalAPIKey},
+		"unknown prefix": {scopedKeyHeaders("00000000", testAPIKeySecret), "/internal/roles", CreateRoles, invalidInternalAPIKey},
+		"expired key":    {scopedKeyHeaders("e1e2e3e4", testAPIKeySecret), "/internal/roles", CreateRoles, inactiveAPIKey},
+		"revoked key":    {scopedKeyHeaders("f1f2f3f4", testAPIKeySecret), "/internal/roles", CreateRoles, inactiveAPIKey},
+		"missing scope":  {scopedKeyHeaders("a1b2c3d4", testAPIKeySecret), "/internal/users/delete?id=1", DeleteUser, missingScope},
+	}
+	for name, tc := range unauthorized {
+		t.Run("returns Unauthorized with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", tc.path, tc.handler, bytes.NewBuffer(body), tc.headers, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+
+			if e := lastAuditEvent(t); e.Action != auditInternalAuthFailed || e.Metadata["reason"] != tc.err.Error() {
+				t.Errorf("got unexpected audit event %+v", e)
+			}
+		})
+	}
+}
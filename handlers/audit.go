@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const (
+	auditRegister     = "register"
+	auditLogin        = "login"
+	auditLoginFailed  = "login_failed"
+	auditRefresh      = "refresh"
+	auditLogout       = "logout"
+	auditRolesCreated = "roles_created"
+	auditRolesDeleted = "roles_deleted"
+	auditUserRoles    = "user_roles_changed"
+	auditUserDeleted  = "user_deleted"
+	auditUserUnlocked = "user_unlocked"
+	auditAPIKeyCreate = "api_key_created"
+	auditAPIKeyRotate = "api_key_rotated"
+	auditAPIKeyRevoke = "api_key_revoked"
+
+	auditPermissionSaved   = "permission_saved"
+	auditPermissionDeleted = "permission_deleted"
+	auditRolePermissions   = "role_permissions_changed"
+	auditRoleParent        = "role_parent_changed"
+
+	auditInvitationCreate = "invitation_created"
+	auditInvitationRevoke = "invitation_revoked"
+	auditInvitationAccept = "invitation_accepted"
+
+	auditOrganizationCreate = "organization_created"
+	auditOrganizationDelete = "organization_deleted"
+	auditMemberSet          = "organization_member_set"
+	auditMemberRemove       = "organization_member_removed"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+const defaultAuditLimit = 50
+const maxAuditLimit = 200
+
+const invalidAuditFilter = handlerErr("Invalid audit events filter")
+
+type auditEventsPage struct {
+	Events     []models.AuditEvent `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// AuditEvents returns audit events matching the query filters, newest first
+func AuditEvents(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := auditFilter(r)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidAuditFilter)
+			return
+		}
+
+		limit := filter.Limit
+		// One more event is requested to know whether there is a next page
+		filter.Limit++
+
+		events, err := deps.DB.AuditEvents(filter)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		page := &auditEventsPage{Events: events}
+		if page.Events == nil {
+			page.Events = []models.AuditEvent{}
+		}
+		if len(events) > limit {
+			page.Events = events[:limit]
+			page.NextCursor = strconv.FormatInt(page.Events[limit-1].ID, 10)
+		}
+
+		respond(w, http.StatusOK, page)
+	}))))
+}
+
+func auditFilter(r *http.Request) (*models.AuditFilter, error) {
+	q := r.URL.Query()
+	f := &models.AuditFilter{
+		Action:  q.Get("action"),
+		Outcome: q.Get("outcome"),
+		IP:      q.Get("ip"),
+		Limit:   defaultAuditLimit,
+	}
+
+	var err error
+	ids := map[string]*int64{"actor_id": &f.ActorID, "subject_id": &f.SubjectID, "cursor": &f.Before}
+	for name, dst := range ids {
+		if v := q.Get(name); len(v) > 0 {
+			if *dst, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	times := map[string]*time.Time{"from": &f.From, "to": &f.To}
+	for name, dst := range times {
+		if v := q.Get(name); len(v) > 0 {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, err
+			}
+
+			*dst = t.UTC()
+		}
+	}
+
+	if v := q.Get("limit"); len(v) > 0 {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return nil, invalidAuditFilter
+		}
+		if f.Limit > maxAuditLimit {
+			f.Limit = maxAuditLimit
+		}
+	}
+
+	return f, nil
+}
+
+// audit records the event with the client details of the request.
+// Failures to record are logged without interrupting the request.
+func audit(deps *Deps, r *http.Request, e *models.AuditEvent) {
+	if claims, ok := claimsFromContext(r); ok && e.ActorID == 0 {
+		e.ActorID = claims.UserID
+	}
+	if actor, ok := r.Context().Value(internalActorKey).(string); ok {
+		if e.Metadata == nil {
+			e.Metadata = map[string]interface{}{}
+		}
+		e.Metadata["actor"] = actor
+	}
+	e.IP = clientIP(r)
+	e.UserAgent = r.UserAgent()
+	if len(e.UserAgent) > 512 {
+		e.UserAgent = strings.ToValidUTF8(e.UserAgent[:512], "")
+	}
+
+	if err := deps.DB.CreateAuditEvent(e); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+}
+
+// auditLoginSuccess records a login of the user with the authentication method
+func auditLoginSuccess(deps *Deps, r *http.Request, userID int64, method string) {
+	audit(deps, r, &models.AuditEvent{
+		ActorID:   userID,
+		SubjectID: userID,
+		Action:    auditLogin,
+		Outcome:   auditSuccess,
+		Metadata:  map[string]interface{}{"method": method},
+	})
+}
+
+// auditLoginFailure records a failed login attempt, userID is zero for unknown accounts
+func auditLoginFailure(deps *Deps, r *http.Request, userID int64, method string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["method"] = method
+
+	audit(deps, r, &models.AuditEvent{
+		SubjectID: userID,
+		Action:    auditLoginFailed,
+		Outcome:   auditFailure,
+		Metadata:  metadata,
+	})
+}
+
+// auditRolesChange records roles synchronized from an external source when they differ from the previous ones
+func auditRolesChange(deps *Deps, r *http.Request, userID int64, previous, current []string, source string) {
+	if sameRoles(previous, current) {
+		return
+	}
+
+	audit(deps, r, &models.AuditEvent{
+		SubjectID: userID,
+		Action:    auditUserRoles,
+		Outcome:   auditSuccess,
+		Metadata:  map[string]interface{}{"source": source, "previous": previous, "roles": current},
+	})
+}
+
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	names := make(map[string]bool, len(a))
+	for _, name := range a {
+		names[name] = true
+	}
+	for _, name := range b {
+		if !names[name] {
+			return false
+		}
+	}
+
+	return true
+}
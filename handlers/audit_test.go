@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+var auditedEvents []models.AuditEvent
+
+func lastAuditEvent(t *testing.T) models.AuditEvent {
+	t.Helper()
+
+	if len(auditedEvents) == 0 {
+		t.Fatal("expected audit event")
+	}
+
+	return auditedEvents[len(auditedEvents)-1]
+}
+
+func TestAuditEvents(t *testing.T) {
+	t.Run("returns MethodNotAllowed for non-GET requests", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/audit-events", AuditEvents, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns UnprocessableEntity with invalid filter", func(t *testing.T) {
+		for _, query := range []string{"actor_id=abc", "from=yesterday", "limit=0", "cursor=-"} {
+			recorder := performRequest(t, "GET", "/internal/audit-events?"+query, AuditEvents, nil, internalHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("returns pages of events with cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/audit-events?action=login&limit=2", AuditEvents, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var page auditEventsPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Events) != 2 || page.NextCursor != "2" {
+			t.Fatalf("got unexpected page %+v", page)
+		}
+
+		recorder = performRequest(t, "GET", "/internal/audit-events?action=login&limit=2&cursor="+page.NextCursor, AuditEvents, nil, internalHeaders, nil)
+
+		page = auditEventsPage{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Events) != 1 || page.Events[0].ID != 1 || len(page.NextCursor) != 0 {
+			t.Errorf("got unexpected page %+v", page)
+		}
+	})
+
+	t.Run("returns empty list without matching events", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/audit-events?action=logout", AuditEvents, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if recorder.Body.String() != `{"events":[]}` {
+			t.Errorf("got unexpected body %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestAuditFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/internal/audit-events?from=2020-01-01T12:00:00%2B03:00&to=2020-01-02T00:00:00Z", nil)
+
+	f, err := auditFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	if f.From != from || f.From.Location() != time.UTC || f.To.Location() != time.UTC {
+		t.Errorf("expected times in UTC, got %v and %v", f.From, f.To)
+	}
+}
+
+func TestLoginAudit(t *testing.T) {
+	t.Run("records successful login", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		e := lastAuditEvent(t)
+		if e.Action != auditLogin || e.Outcome != auditSuccess || e.SubjectID != 1 || e.Metadata["method"] != "password" {
+			t.Errorf("got unexpected event %+v", e)
+		}
+	})
+
+	t.Run("records failed login", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "invalid"}`))
+		performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		e := lastAuditEvent(t)
+		if e.Action != auditLoginFailed || e.Outcome != auditFailure || e.SubjectID != 1 {
+			t.Errorf("got unexpected event %+v", e)
+		}
+	})
+
+	t.Run("records user deletion", func(t *testing.T) {
+		performRequest(t, "DELETE", "/internal/users/delete?id=1", DeleteUser, nil, internalJSONHeaders, nil)
+
+		e := lastAuditEvent(t)
+		if e.Action != auditUserDeleted || e.SubjectID != 1 {
+			t.Errorf("got unexpected event %+v", e)
+		}
+	})
+}
@@ -8,23 +8,43 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/duo-labs/webauthn/webauthn"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	"github.com/maxshend/tiny_goauth/auth"
 	"github.com/maxshend/tiny_goauth/db"
+	"github.com/maxshend/tiny_goauth/geoip"
+	"github.com/maxshend/tiny_goauth/ldapauth"
 	"github.com/maxshend/tiny_goauth/logwrapper"
+	"github.com/maxshend/tiny_goauth/mailer"
 	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/oauth"
+	"github.com/maxshend/tiny_goauth/policy"
+	"github.com/maxshend/tiny_goauth/samlauth"
+	"github.com/maxshend/tiny_goauth/sms"
 )
 
 // Deps contains dependencies of the http handlers
 type Deps struct {
-	DB         db.DataLayer
-	Validator  *validator.Validate
-	Translator ut.Translator
-	Logger     *logwrapper.StandardLogger
-	Keys       *auth.RSAKeys
+	DB           db.DataLayer
+	Validator    *validator.Validate
+	Translator   ut.Translator
+	Logger       *logwrapper.StandardLogger
+	Keys         *auth.RSAKeys
+	Mailer       mailer.Mailer
+	WebAuthn     *webauthn.WebAuthn
+	SMS          sms.Sender
+	Providers    oauth.Registry
+	Directories  ldapauth.Registry
+	SAML         *samlauth.SP
+	RateLimits   RateLimits
+	GeoIP        geoip.Locator
+	InternalAuth *InternalAuth
+	RolePolicy   *RolePolicy
+	Policy       *policy.Engine
 }
 
 type contextKey int
@@ -37,6 +57,8 @@ const invalidTokenMsg = "Invalid Authorization token."
 const postMethod = "POST"
 const (
 	tokenClaimsKey contextKey = iota
+	internalActorKey
+	apiKeyKey
 )
 const maxBodySize = 1048576
 const defaultUsersEndpoint = "/internal/tiny_goauth/registrations"
@@ -68,6 +90,7 @@ func Logout(deps *Deps) http.Handler {
 			return
 		}
 
+		audit(deps, r, &models.AuditEvent{ActorID: claims.UserID, SubjectID: claims.UserID, Action: auditLogout, Outcome: auditSuccess})
 		respond(w, http.StatusOK, nil)
 	})))))
 }
@@ -99,7 +122,25 @@ func Refresh(deps *Deps) http.Handler {
 			return
 		}
 
-		td, err := auth.Token(claims.UserID, claims.Roles, deps.Keys)
+		if tokensRevoked(deps, claims) {
+			respondInvalidToken(w)
+			return
+		}
+
+		// Tokens get current roles of the user so that role changes take effect
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		userClaims, err := tokenClaims(deps, user, refreshTenant(r, claims))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		td, err := auth.Token(userClaims, deps.Keys)
 		if err != nil {
 			respondInvalidToken(w)
 			return
@@ -118,10 +159,17 @@ func Refresh(deps *Deps) http.Handler {
 			return
 		}
 
+		audit(deps, r, &models.AuditEvent{ActorID: claims.UserID, SubjectID: claims.UserID, Action: auditRefresh, Outcome: auditSuccess})
 		respond(w, http.StatusOK, payload)
 	}))))
 }
 
+func claimsFromContext(r *http.Request) (*auth.Claims, bool) {
+	claims, ok := r.Context().Value(tokenClaimsKey).(*auth.Claims)
+
+	return claims, ok
+}
+
 func respond(w http.ResponseWriter, status int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
@@ -181,6 +229,38 @@ func saveTokenDetails(deps *Deps, userID int64, td *auth.TokenDetails) error {
 	return nil
 }
 
+// revokeTokens invalidates tokens of the user issued up to now
+func revokeTokens(deps *Deps, userID int64) error {
+	return deps.DB.StoreCache(
+		"tokens_revoked:"+strconv.FormatInt(userID, 10), time.Now().UnixNano(), auth.RefreshTokenTTL,
+	)
+}
+
+// tokensRevoked reports whether tokens of the user have been revoked after the token was issued.
+// Tokens are treated as revoked when the revocation time can't be read.
+func tokensRevoked(deps *Deps, claims *auth.Claims) bool {
+	value, err := deps.DB.GetCacheValue("tokens_revoked:" + strconv.FormatInt(claims.UserID, 10))
+	if err == db.ErrCacheMiss {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return true
+	}
+
+	// Tokens issued before the claim with the issue time in nanoseconds are compared in whole seconds
+	issuedAt := claims.IssuedAtNano
+	if issuedAt == 0 {
+		issuedAt = time.Unix(claims.IssuedAt, 0).UnixNano()
+	}
+
+	return issuedAt <= revokedAt
+}
+
 func postJSON(url string, body io.Reader) (result io.Reader, code int, err error) {
 	code = http.StatusServiceUnavailable
 
@@ -204,6 +284,31 @@ func postJSON(url string, body io.Reader) (result io.Reader, code int, err error
 	return bytes.NewReader(respBody), code, nil
 }
 
+// registerUser stores a new user and registers it in the external service.
+// Returns the external service response body when the service rejects the user.
+func registerUser(deps *Deps, r *http.Request, user *models.User) ([]byte, error) {
+	err := deps.DB.CreateUser(user)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		return nil, err
+	}
+
+	responseBody, err := createExternalUser(user)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+
+		if err := deps.DB.DeleteUser(user.ID); err != nil {
+			deps.Logger.RequestError(r, err)
+		}
+
+		return responseBody, err
+	}
+
+	audit(deps, r, &models.AuditEvent{ActorID: user.ID, SubjectID: user.ID, Action: auditRegister, Outcome: auditSuccess})
+
+	return responseBody, nil
+}
+
 func createExternalUser(user *models.User) ([]byte, error) {
 	endpoint, found := os.LookupEnv("API_USERS_ENDPOINT")
 	if !found || len(endpoint) == 0 {
@@ -213,6 +318,7 @@ func createExternalUser(user *models.User) ([]byte, error) {
 	userParams := &models.User{
 		ID:        user.ID,
 		Email:     user.Email,
+		Phone:     user.Phone,
 		Payload:   user.Payload,
 		Roles:     user.Roles,
 		CreatedAt: user.CreatedAt,
@@ -7,15 +7,22 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/duo-labs/webauthn/webauthn"
 	"github.com/go-playground/validator"
 	"github.com/maxshend/tiny_goauth/auth"
 	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/db"
+	"github.com/maxshend/tiny_goauth/ldapauth"
 	"github.com/maxshend/tiny_goauth/logwrapper"
 	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/oauth"
+	"github.com/maxshend/tiny_goauth/samlauth"
 	"github.com/maxshend/tiny_goauth/validations"
 )
 
@@ -36,7 +43,7 @@ func TestLogout(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	claims := jwt.MapClaims{"exp": time.Now().Add(time.Minute * 15).Unix()}
+	claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute * 15).Unix()}
 	expiredClaims := jwt.MapClaims{"exp": time.Now().Add(time.Minute * -15).Unix()}
 	token := authtest.GenerateFakeJWT(t, privateKey, jwt.SigningMethodRS256, claims)
 	expired := authtest.GenerateFakeJWT(t, privateKey, jwt.SigningMethodRS256, expiredClaims)
@@ -75,7 +82,7 @@ func TestRefresh(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	claims := jwt.MapClaims{"exp": time.Now().Add(time.Minute * 15).Unix()}
+	claims := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute * 15).Unix()}
 	expiredClaims := jwt.MapClaims{"exp": time.Now().Add(time.Minute * -15).Unix()}
 	token := authtest.GenerateFakeJWT(t, privateKey, jwt.SigningMethodRS256, claims)
 	expired := authtest.GenerateFakeJWT(t, privateKey, jwt.SigningMethodRS256, expiredClaims)
@@ -97,12 +104,45 @@ func TestRefresh(t *testing.T) {
 
 		authtest.AssertStatusCode(t, recorder, http.StatusOK)
 	})
+
+	t.Run("returns Unauthorized with revoked Refresh token", func(t *testing.T) {
+		revokedClaims := jwt.MapClaims{"user_id": revokedTestUserID, "iat": time.Now().Add(-time.Minute).Unix(), "exp": time.Now().Add(time.Minute * 15).Unix()}
+		h := jsonHeaders
+		h[auhtorizationHeader] = authtest.GenerateFakeJWT(t, privateKey, jwt.SigningMethodRS256, revokedClaims)
+		recorder := performRequest(t, "POST", "/refresh", Refresh, nil, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+}
+
+func TestTokensRevoked(t *testing.T) {
+	deps := &Deps{DB: &testDL{}}
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		claims  *auth.Claims
+		revoked bool
+	}{
+		{"revokes tokens issued before", &auth.Claims{UserID: revokedTestUserID, IssuedAtNano: now.Add(-time.Millisecond).UnixNano()}, true},
+		{"keeps tokens issued after within the same second", &auth.Claims{UserID: revokedTestUserID, IssuedAtNano: now.Add(500 * time.Millisecond).UnixNano()}, false},
+		{"compares tokens without nanoseconds in seconds", &auth.Claims{UserID: revokedTestUserID, StandardClaims: jwt.StandardClaims{IssuedAt: now.Unix()}}, true},
+		{"keeps tokens of other users", &auth.Claims{UserID: 1, IssuedAtNano: now.Add(-time.Millisecond).UnixNano()}, false},
+		{"revokes tokens when the cache fails", &auth.Claims{UserID: cacheErrorTestUserID, IssuedAtNano: now.UnixNano()}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tokensRevoked(deps, tc.claims); got != tc.revoked {
+				t.Errorf("expected %v, got %v", tc.revoked, got)
+			}
+		})
+	}
 }
 
 func performRequest(t *testing.T, method, path string, h func(deps *Deps) http.Handler, body io.Reader, headers map[string]string, key *rsa.PrivateKey) (recorder *httptest.ResponseRecorder) {
 	t.Helper()
 
-	testUser := models.User{ID: 1, Email: "test@mail.com", Password: "password", CreatedAt: time.Now()}
+	testUser := models.User{ID: 1, Email: "test@mail.com", Password: "password", Roles: []string{"editor"}, EffectiveRoles: []string{"editor", "viewer"}, Permissions: []string{testPermission}, CreatedAt: time.Now()}
 	db := &testDL{User: testUser}
 	validator, translator, err := validations.Init(db)
 	if err != nil {
@@ -120,7 +160,17 @@ func performRequest(t *testing.T, method, path string, h func(deps *Deps) http.H
 	}
 	keys := &auth.RSAKeys{AccessSign: key, AccessVerify: &key.PublicKey, RefreshSign: key, RefreshVerify: &key.PublicKey}
 
-	deps := &Deps{DB: db, Validator: validator, Translator: translator, Logger: logger, Keys: keys}
+	relyingParty, err := webauthn.New(&webauthn.Config{RPDisplayName: "test", RPID: "localhost", RPOrigin: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rateLimits, err := LoadRateLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps := &Deps{DB: db, Validator: validator, Translator: translator, Logger: logger, Keys: keys, Mailer: &testMailer{}, WebAuthn: relyingParty, SMS: &testSMS{}, Providers: testProviders, Directories: testDirectories, SAML: testSAML, RateLimits: rateLimits, InternalAuth: testInternalAuth, RolePolicy: testRolePolicy, Policy: testPolicy}
 
 	request, err := http.NewRequest(method, path, body)
 	if err != nil {
@@ -139,6 +189,28 @@ func performRequest(t *testing.T, method, path string, h func(deps *Deps) http.H
 	return recorder
 }
 
+// testProviders are identity providers used by handlers in tests
+var testProviders = oauth.Registry{}
+
+// testDirectories are LDAP directories used by handlers in tests
+var testDirectories = ldapauth.Registry{}
+
+// testSAML is the SAML service provider used by handlers in tests
+var testSAML *samlauth.SP
+
+// testSAMLConnection is returned for its tenant and testSAMLRequestID is the request ID
+// stored for testSAMLRelayState
+var testSAMLConnection *models.SAMLConnection
+var testSAMLRequestID string
+
+const testSAMLRelayState = "saml"
+
+// usedSAMLAssertions emulates the assertion and signature nonce replay cache
+var usedSAMLAssertions = map[string]bool{}
+
+// newDirectoryEmail belongs to a directory user without a local account
+const newDirectoryEmail = "new@corp.local"
+
 type testDL struct {
 	User models.User
 }
@@ -159,12 +231,326 @@ func (t *testDL) DeleteUser(id int64) error {
 }
 
 func (t *testDL) GetRoles() ([]string, error) {
-	return make([]string, 0), nil
+	roles := make([]string, 0, len(testRoles))
+	for _, role := range testRoles {
+		roles = append(roles, role.Name)
+	}
+
+	return roles, nil
+}
+
+func (t *testDL) SaveSAMLConnection(c *models.SAMLConnection) error {
+	c.ID = 1
+
+	return nil
+}
+
+func (t *testDL) SAMLConnection(tenant string) (*models.SAMLConnection, error) {
+	if testSAMLConnection == nil || tenant != testSAMLConnection.Tenant {
+		return nil, errors.New("not found")
+	}
+
+	c := *testSAMLConnection
+	return &c, nil
+}
+
+func (t *testDL) SAMLConnections() ([]models.SAMLConnection, error) {
+	if testSAMLConnection == nil {
+		return nil, nil
+	}
+
+	return []models.SAMLConnection{*testSAMLConnection}, nil
+}
+
+func (t *testDL) DeleteSAMLConnection(tenant string) error {
+	if _, err := t.SAMLConnection(tenant); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *testDL) CreateAPIKey(k *models.APIKey) error {
+	k.ID = 1
+
+	return nil
+}
+
+func (t *testDL) APIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	for _, k := range testAPIKeys {
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (t *testDL) APIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	k, ok := testAPIKeys[prefix]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+
+	return &k, nil
+}
+
+func (t *testDL) APIKeyByID(id int64) (*models.APIKey, error) {
+	for _, k := range testAPIKeys {
+		if k.ID == id {
+			return &k, nil
+		}
+	}
+
+	return nil, errors.New("not found")
+}
+
+func (t *testDL) TouchAPIKey(id int64) error {
+	return nil
+}
+
+func (t *testDL) RotateAPIKey(k *models.APIKey) error {
+	for _, existing := range testAPIKeys {
+		if existing.ID == k.ID && existing.RevokedAt == nil {
+			k.Name, k.Scopes = existing.Name, existing.Scopes
+			return nil
+		}
+	}
+
+	return errors.New("not found")
+}
+
+func (t *testDL) RevokeAPIKey(id int64) error {
+	return t.RotateAPIKey(&models.APIKey{ID: id})
+}
+
+func (t *testDL) SavePermission(p *models.Permission) error {
+	p.ID = 1
+
+	return nil
+}
+
+func (t *testDL) Permissions() ([]models.Permission, error) {
+	return []models.Permission{{ID: 1, Name: testPermission}}, nil
+}
+
+func (t *testDL) DeletePermission(name string) error {
+	if name != testPermission {
+		return errors.New("not found")
+	}
+
+	return nil
+}
+
+func (t *testDL) RolePermissions(role string) ([]string, error) {
+	if role != "staff" {
+		return nil, errors.New("unknown role")
+	}
+
+	return []string{testPermission}, nil
+}
+
+func (t *testDL) SetRolePermissions(role string, permissions []string) error {
+	if role != "staff" {
+		return errors.New("unknown role")
+	}
+	for _, p := range permissions {
+		if p != testPermission {
+			return errors.New("unknown permissions")
+		}
+	}
+
+	return nil
+}
+
+func (t *testDL) RoleHierarchy() ([]models.Role, error) {
+	return testRoles, nil
+}
+
+func (t *testDL) SetRoleParent(role, parent string) error {
+	if role == "rejected" {
+		return errors.New("Role can't inherit itself")
+	}
+
+	return nil
+}
+
+func (t *testDL) AddUserRoles(userIDs []int64, roles []string) error {
+	return testRoleAssignment(t, userIDs, roles)
+}
+
+func (t *testDL) RemoveUserRoles(userIDs []int64, roles []string) error {
+	return testRoleAssignment(t, userIDs, roles)
+}
+
+func testRoleAssignment(t *testDL, userIDs []int64, roles []string) error {
+	for _, role := range roles {
+		if !testRoleExists(role) {
+			return errors.New("unknown role")
+		}
+	}
+	for _, id := range userIDs {
+		if _, err := t.UserByID(id); err != nil {
+			return errors.New("unknown users")
+		}
+	}
+
+	return nil
+}
+
+func (t *testDL) RoleMembers(role string, after int64, limit int) ([]models.User, error) {
+	if !testRoleExists(role) {
+		return nil, errors.New("unknown role")
+	}
+
+	var users []models.User
+	for _, user := range []models.User{t.User, mfaTestUser, socialTestUser} {
+		if user.ID > after && len(users) < limit {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func testRoleExists(name string) bool {
+	for _, role := range testRoles {
+		if role.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *testDL) CreateInvitation(i *models.Invitation) error {
+	i.ID = int64(len(testInvitations) + 1)
+	i.CreatedAt = time.Now()
+
+	return nil
+}
+
+func (t *testDL) Invitations(before int64, limit int) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	for i := len(testInvitations); i > 0 && len(invitations) < limit; i-- {
+		if before == 0 || int64(i) < before {
+			invitations = append(invitations, *testInvitationByID(int64(i)))
+		}
+	}
+
+	return invitations, nil
+}
+
+func (t *testDL) InvitationByTokenID(tokenID string) (*models.Invitation, error) {
+	invitation, ok := testInvitations[tokenID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+
+	return &invitation, nil
+}
+
+func (t *testDL) AcceptInvitation(id, userID int64) error {
+	return nil
+}
+
+func (t *testDL) RevokeInvitation(id int64) error {
+	if invitation := testInvitationByID(id); invitation == nil || !invitation.Pending(time.Now()) {
+		return errors.New("No rows have been updated")
+	}
+
+	return nil
+}
+
+func (t *testDL) CreateOrganization(o *models.Organization) error {
+	for _, organization := range testOrganizations {
+		if organization.Slug == o.Slug {
+			return errors.New("duplicate key value violates unique constraint")
+		}
+	}
+
+	o.ID = int64(len(testOrganizations) + 1)
+	o.CreatedAt = time.Now()
+
+	return nil
+}
+
+func (t *testDL) Organizations() ([]models.Organization, error) {
+	return testOrganizations, nil
+}
+
+func (t *testDL) Organization(slug string) (*models.Organization, error) {
+	for _, o := range testOrganizations {
+		if o.Slug == slug {
+			return &o, nil
+		}
+	}
+
+	return nil, errors.New("not found")
+}
+
+func (t *testDL) DeleteOrganization(slug string) error {
+	if !testOrganizationExists(slug) {
+		return errors.New("No row found to delete")
+	}
+
+	return nil
+}
+
+func (t *testDL) SetMembership(slug string, userID int64, roles []string) error {
+	if !testOrganizationExists(slug) {
+		return errors.New("Unknown organization")
+	}
+
+	return testRoleAssignment(t, []int64{userID}, roles)
+}
+
+func (t *testDL) RemoveMember(slug string, userID int64) error {
+	if _, err := t.Membership(slug, userID); err != nil {
+		return errors.New("No row found to delete")
+	}
+
+	return nil
+}
+
+func (t *testDL) Membership(slug string, userID int64) (*models.Membership, error) {
+	for _, m := range testMemberships {
+		if m.Organization == slug && m.UserID == userID {
+			return &m, nil
+		}
+	}
+
+	return nil, errors.New("not found")
+}
+
+func (t *testDL) Members(slug string, after int64, limit int) ([]models.Membership, error) {
+	if !testOrganizationExists(slug) {
+		return nil, errors.New("Unknown organization")
+	}
+
+	var members []models.Membership
+	for _, m := range testMemberships {
+		if m.Organization == slug && m.UserID > after && len(members) < limit {
+			members = append(members, m)
+		}
+	}
+
+	return members, nil
+}
+
+func (t *testDL) SyncUserRoles(userID int64, managed, roles []string) error {
+	return nil
 }
 
 func (t *testDL) UserByEmail(email string) (*models.User, error) {
 	var err error
 
+	if email == mfaTestUser.Email {
+		return mfaUser()
+	}
+	if email == newDirectoryEmail || email == newInvitedEmail || email == newSAMLEmail || email == newOAuthEmail {
+		return nil, errors.New("not found")
+	}
+
 	t.User.Password, err = auth.EncryptPassword(t.User.Password)
 	if err != nil {
 		return nil, err
@@ -173,24 +559,139 @@ func (t *testDL) UserByEmail(email string) (*models.User, error) {
 	return &t.User, nil
 }
 
+func (t *testDL) UserByID(id int64) (*models.User, error) {
+	if id == mfaTestUser.ID {
+		return mfaUser()
+	}
+	if id == socialTestUser.ID {
+		user := socialTestUser
+		return &user, nil
+	}
+	if id == passkeyTestUser.ID {
+		user := passkeyTestUser
+		return &user, nil
+	}
+	if id != t.User.ID {
+		return nil, errors.New("not found")
+	}
+
+	return &t.User, nil
+}
+
+func (t *testDL) UserByPhone(phone string) (*models.User, error) {
+	if phone != testPhone {
+		return nil, errors.New("not found")
+	}
+
+	return &models.User{ID: 3, Phone: phone}, nil
+}
+
 func (t *testDL) Close() {}
 func (t *testDL) Migrate() error {
 	return nil
 }
 
 func (t *testDL) UserExistsWithField(fl validator.FieldLevel) (bool, error) {
-	return false, nil
+	return fl.Field().String() == t.User.Email, nil
+}
+
+func (t *testDL) SaveUserDevice(d *models.UserDevice) error {
+	return nil
+}
+
+func (t *testDL) UserDevices(userID int64) ([]models.UserDevice, error) {
+	// The MFA user hasn't logged in yet
+	if userID == mfaTestUser.ID {
+		return nil, nil
+	}
+
+	return []models.UserDevice{
+		{UserID: userID, DeviceHash: auth.HashNonce(testDeviceID), UserAgent: testDeviceUserAgent, IP: "127.0.0.1"},
+	}, nil
+}
+
+func (t *testDL) CreateAuditEvent(e *models.AuditEvent) error {
+	auditedEvents = append(auditedEvents, *e)
+
+	return nil
+}
+
+func (t *testDL) AuditEvents(f *models.AuditFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for id := int64(3); id > 0 && len(events) < f.Limit; id-- {
+		if (f.Before == 0 || id < f.Before) && (len(f.Action) == 0 || f.Action == auditLogin) {
+			events = append(events, models.AuditEvent{ID: id, ActorID: 1, SubjectID: 1, Action: auditLogin, Outcome: auditSuccess})
+		}
+	}
+
+	return events, nil
 }
 
 func (t *testDL) StoreCache(key string, payload interface{}, exp time.Duration) error {
+	storedCacheKeys = append(storedCacheKeys, key)
+
 	return nil
 }
 
+// incrementedCacheKeys, deletedCacheKeys and storedCacheKeys record cache keys changed by handlers
+var incrementedCacheKeys, deletedCacheKeys, storedCacheKeys []string
+
 func (t *testDL) DeleteCache(key string) (int64, error) {
+	deletedCacheKeys = append(deletedCacheKeys, key)
+
 	return 1, nil
 }
 
+func (t *testDL) IncrCache(key string, exp time.Duration) (int64, error) {
+	incrementedCacheKeys = append(incrementedCacheKeys, key)
+
+	if key == "login_failures:email:"+failingEmail {
+		return loginLockoutThreshold, nil
+	}
+	if strings.HasSuffix(key, "limited@mail.com") {
+		return 100, nil
+	}
+
+	return 1, nil
+}
+
+func (t *testDL) StoreCacheNX(key string, payload interface{}, exp time.Duration) (bool, error) {
+	if strings.HasPrefix(key, "saml_assertion:") || strings.HasPrefix(key, "internal_nonce:") {
+		if usedSAMLAssertions[key] {
+			return false, nil
+		}
+		usedSAMLAssertions[key] = true
+	}
+
+	return true, nil
+}
+
 func (t *testDL) GetCacheValue(key string) (string, error) {
+	if key == "oauth_state:"+testOAuthState {
+		return `{"provider": "test", "nonce": "nonce"}`, nil
+	}
+	if key == "oauth_state:"+testOAuthLinkState {
+		return `{"provider": "test", "nonce": "nonce", "user_id": 1}`, nil
+	}
+	if key == "tokens_revoked:"+strconv.FormatInt(revokedTestUserID, 10) {
+		return strconv.FormatInt(time.Now().UnixNano(), 10), nil
+	}
+	if key == "tokens_revoked:"+strconv.FormatInt(cacheErrorTestUserID, 10) {
+		return "", errors.New("connection refused")
+	}
+	if strings.HasPrefix(key, "tokens_revoked:") {
+		return "", db.ErrCacheMiss
+	}
+	if key == "login_blocked:email:"+lockedEmail {
+		return strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10), nil
+	}
+	if key == "phone_otp:"+testPhone || strings.HasPrefix(key, "mfa_email:") {
+		return auth.HashNonce(testOTPCode), nil
+	}
+	if key == "saml_state:"+testSAMLRelayState && testSAMLConnection != nil {
+		return `{"tenant": "` + testSAMLConnection.Tenant + `", "request_id": "` + testSAMLRequestID + `"}`, nil
+	}
+
 	return "", nil
 }
 
@@ -209,3 +710,117 @@ func (t *testDL) DeleteRoles(names []string) error {
 
 	return nil
 }
+
+func (t *testDL) SetTOTPSecret(userID int64, secret string) error {
+	return nil
+}
+
+func (t *testDL) EnableTOTP(userID int64, codeHashes []string) error {
+	return nil
+}
+
+func (t *testDL) DisableTOTP(userID int64) error {
+	return nil
+}
+
+func (t *testDL) UseRecoveryCode(userID int64, codeHash string) (bool, error) {
+	return codeHash == auth.HashRecoveryCode(testRecoveryCode), nil
+}
+
+func (t *testDL) CreateWebAuthnCredential(c *models.WebAuthnCredential) error {
+	return nil
+}
+
+func (t *testDL) WebAuthnCredentials(userID int64) ([]models.WebAuthnCredential, error) {
+	if userID == mfaTestUser.ID || userID == passkeyTestUser.ID {
+		return []models.WebAuthnCredential{{ID: 1, UserID: userID, CredentialID: []byte("credential")}}, nil
+	}
+
+	return nil, nil
+}
+
+func (t *testDL) UpdateWebAuthnSignCount(credentialID []byte, signCount uint32) error {
+	return nil
+}
+
+func (t *testDL) CreateIdentity(identity *models.Identity) error {
+	identity.ID = 2
+
+	return nil
+}
+
+func (t *testDL) IdentityBySubject(provider, subject string) (*models.Identity, error) {
+	if subject != "linked" {
+		return nil, errors.New("not found")
+	}
+
+	return &models.Identity{ID: 1, UserID: socialTestUser.ID, Provider: provider, Subject: subject}, nil
+}
+
+func (t *testDL) UserIdentities(userID int64) ([]models.Identity, error) {
+	return []models.Identity{{ID: 1, UserID: userID, Provider: "test", Subject: "linked"}}, nil
+}
+
+func (t *testDL) DeleteIdentity(userID, id int64) error {
+	if id != 1 {
+		return errors.New("not found")
+	}
+
+	return nil
+}
+
+const testOAuthState = "valid"
+const testOAuthLinkState = "link"
+const testPhone = "+15550000001"
+const testOTPCode = "123456"
+
+// revokedTestUserID has tokens revoked right now
+const revokedTestUserID = 5
+
+// cacheErrorTestUserID has revoked tokens which can't be read from the cache
+const cacheErrorTestUserID = 7
+
+// socialTestUser can log in only through a linked identity
+var socialTestUser = models.User{ID: 4, Email: "social@mail.com"}
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+const testRecoveryCode = "abcd-efgh"
+
+var mfaTestUser = models.User{ID: 2, Email: "mfa@mail.com", Password: "password", TOTPSecret: testTOTPSecret, TOTPEnabled: true}
+
+// passkeyTestUser has only a passkey as the second factor and a pending TOTP enrollment
+var passkeyTestUser = models.User{ID: 6, Email: "passkey@mail.com", TOTPSecret: testTOTPSecret}
+
+func mfaUser() (*models.User, error) {
+	user := mfaTestUser
+
+	hash, err := auth.EncryptPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+
+	return &user, nil
+}
+
+type testMailer struct {
+	deliveries []string
+}
+
+func (m *testMailer) Deliver(to, template, locale string, data interface{}) error {
+	m.deliveries = append(m.deliveries, template+":"+to)
+
+	return nil
+}
+
+func (m *testMailer) Close() {}
+
+type testSMS struct {
+	messages []string
+}
+
+func (s *testSMS) Send(to, body string) error {
+	s.messages = append(s.messages, to+":"+body)
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const deviceCookie = "device_id"
+const deviceCookieMaxAge = 2 * 365 * 24 * time.Hour
+const mfaPolicyRisky = "risky"
+
+// loginAssessment compares the device and location of the login with the user history
+type loginAssessment struct {
+	device      *models.UserDevice
+	newDevice   bool
+	newLocation bool
+}
+
+func (a *loginAssessment) risky() bool {
+	return a.newDevice || a.newLocation
+}
+
+// assessLogin identifies the device of the request and compares it with devices the user logged in from.
+// A device ID cookie is set for browsers which don't have one yet.
+func assessLogin(deps *Deps, w http.ResponseWriter, r *http.Request, user *models.User) (*loginAssessment, error) {
+	deviceID := ""
+	if cookie, err := r.Cookie(deviceCookie); err == nil {
+		if _, err = uuid.Parse(cookie.Value); err == nil {
+			deviceID = cookie.Value
+		}
+	}
+	if len(deviceID) == 0 {
+		deviceID = uuid.New().String()
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceCookie,
+			Value:    deviceID,
+			Path:     "/",
+			MaxAge:   int(deviceCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	ip := clientIP(r)
+	device := &models.UserDevice{
+		UserID:     user.ID,
+		DeviceHash: auth.HashNonce(deviceID),
+		UserAgent:  userAgentFamily(r.UserAgent()),
+		IP:         ip,
+	}
+	if deps.GeoIP != nil {
+		device.Location = deps.GeoIP.Locate(ip)
+	}
+
+	devices, err := deps.DB.UserDevices(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first login of the user has nothing to be compared with
+	a := &loginAssessment{device: device}
+	if len(devices) == 0 {
+		return a, nil
+	}
+
+	a.newDevice = true
+	a.newLocation = len(device.Location) > 0
+	for _, d := range devices {
+		if d.DeviceHash == device.DeviceHash && d.UserAgent == device.UserAgent {
+			a.newDevice = false
+		}
+		if d.Location == device.Location {
+			a.newLocation = false
+		}
+	}
+
+	return a, nil
+}
+
+// loginMFAMethods returns second factors the login has to be confirmed with.
+// Enrolled factors are always required, the risky policy only asks users without them
+// for a code sent by email when they log in from a new device or location.
+func loginMFAMethods(user *models.User, methods []string, a *loginAssessment) []string {
+	if len(methods) > 0 {
+		return methods
+	}
+	if os.Getenv("LOGIN_MFA_POLICY") == mfaPolicyRisky && a.risky() && len(user.Email) > 0 {
+		return []string{mfaMethodEmail}
+	}
+
+	return nil
+}
+
+// recordLogin saves the device and alerts the user by email about logins from new devices or locations
+func recordLogin(deps *Deps, r *http.Request, user *models.User, a *loginAssessment) {
+	if err := deps.DB.SaveUserDevice(a.device); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+
+	if !a.risky() {
+		return
+	}
+
+	event := "new_device_login"
+	if !a.newDevice {
+		event = "new_location_login"
+	}
+	deps.Logger.SecurityEvent(r, event, user.ID, a.device.UserAgent+" "+a.device.Location)
+
+	// Users registered by phone have no email to alert
+	if len(user.Email) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Device":   a.device.UserAgent,
+		"IP":       a.device.IP,
+		"Location": a.device.Location,
+		"Time":     time.Now().UTC().Format(time.RFC1123),
+	}
+	if err := deps.Mailer.Deliver(user.Email, "new_login", "", data); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+}
+
+var browserFamilies = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+var osFamilies = []struct{ token, name string }{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "Chrome OS"},
+	{"Linux", "Linux"},
+}
+
+// userAgentFamily returns the browser and the operating system of the user agent, e.g. "Firefox on Linux"
+func userAgentFamily(ua string) string {
+	browser, system := "Unknown browser", "unknown OS"
+	for _, f := range browserFamilies {
+		if strings.Contains(ua, f.token) {
+			browser = f.name
+			break
+		}
+	}
+	for _, f := range osFamilies {
+		if strings.Contains(ua, f.token) {
+			system = f.name
+			break
+		}
+	}
+
+	return browser + " on " + system
+}
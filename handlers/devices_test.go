@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/logwrapper"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const testDeviceID = "6f1c1c52-2ab0-4c4b-8f3e-3c8d2b0c2e65"
+const testDeviceUserAgent = "Firefox on Linux"
+const firefoxUserAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:82.0) Gecko/20100101 Firefox/82.0"
+
+func TestUserAgentFamily(t *testing.T) {
+	cases := map[string]string{
+		firefoxUserAgent: "Firefox on Linux",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.111 Safari/537.36 Edg/86.0.622.51":     "Edge on Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1": "Safari on iOS",
+		"Mozilla/5.0 (Linux; Android 10; SM-G975F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.110 Mobile Safari/537.36":              "Chrome on Android",
+		"curl/7.68.0": "Unknown browser on unknown OS",
+	}
+
+	for ua, expected := range cases {
+		if family := userAgentFamily(ua); family != expected {
+			t.Errorf("expected %q for %q, got %q", expected, ua, family)
+		}
+	}
+}
+
+func TestEmailLoginDevices(t *testing.T) {
+	knownDevice := map[string]string{
+		"Content-Type": jsonContentType,
+		"Cookie":       deviceCookie + "=" + testDeviceID,
+		"User-Agent":   firefoxUserAgent,
+	}
+
+	t.Run("sets device cookie for a new device", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != deviceCookie || len(cookies[0].Value) == 0 {
+			t.Errorf("expected device cookie, got %v", cookies)
+		}
+	})
+
+	t.Run("doesn't set device cookie for a known device", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, knownDevice, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if len(recorder.Result().Cookies()) != 0 {
+			t.Errorf("expected no cookies, got %v", recorder.Result().Cookies())
+		}
+	})
+
+	t.Run("with risk based MFA policy", func(t *testing.T) {
+		os.Setenv("LOGIN_MFA_POLICY", mfaPolicyRisky)
+		defer os.Unsetenv("LOGIN_MFA_POLICY")
+
+		t.Run("returns MFA challenge for enrolled user without devices", func(t *testing.T) {
+			body := bytes.NewBuffer([]byte(`{"email": "mfa@mail.com", "password": "password"}`))
+			recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, knownDevice, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if !strings.Contains(recorder.Body.String(), `"mfa_required":true`) || !strings.Contains(recorder.Body.String(), `"totp"`) {
+				t.Errorf("expected MFA challenge, got %q", recorder.Body.String())
+			}
+		})
+
+		t.Run("skips MFA challenge for a known device of user without second factors", func(t *testing.T) {
+			body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+			recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, knownDevice, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if !strings.Contains(recorder.Body.String(), `"access_token"`) {
+				t.Errorf("expected tokens, got %q", recorder.Body.String())
+			}
+		})
+
+		t.Run("returns email challenge for a new device of user without second factors", func(t *testing.T) {
+			body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+			recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if !strings.Contains(recorder.Body.String(), `"methods":["email"]`) {
+				t.Errorf("expected email challenge, got %q", recorder.Body.String())
+			}
+		})
+	})
+}
+
+func TestRecordLogin(t *testing.T) {
+	logger := logwrapper.New()
+	logger.SetOutput(ioutil.Discard)
+	r := httptest.NewRequest("POST", "/email/login", nil)
+	login := &loginAssessment{device: &models.UserDevice{UserAgent: testDeviceUserAgent}, newDevice: true}
+
+	t.Run("alerts the user about a new device", func(t *testing.T) {
+		mailer := &testMailer{}
+		recordLogin(&Deps{DB: &testDL{}, Logger: logger, Mailer: mailer}, r, &models.User{ID: 1, Email: "test@mail.com"}, login)
+
+		if len(mailer.deliveries) != 1 || mailer.deliveries[0] != "new_login:test@mail.com" {
+			t.Errorf("got unexpected deliveries %v", mailer.deliveries)
+		}
+	})
+
+	t.Run("doesn't alert users without email", func(t *testing.T) {
+		mailer := &testMailer{}
+		recordLogin(&Deps{DB: &testDL{}, Logger: logger, Mailer: mailer}, r, &models.User{ID: 3, Phone: testPhone}, login)
+
+		if len(mailer.deliveries) != 0 {
+			t.Errorf("got unexpected deliveries %v", mailer.deliveries)
+		}
+	})
+}
@@ -3,9 +3,12 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/ldapauth"
 	"github.com/maxshend/tiny_goauth/models"
 )
 
@@ -23,61 +26,100 @@ func EmailRegister(deps *Deps) http.Handler {
 			return
 		}
 
-		err = deps.Validator.Struct(&user)
-		if err != nil {
-			respondModelError(deps, w, err.(validator.ValidationErrors))
-			return
-		}
+		user.Email = auth.NormalizeEmail(user.Email)
 
-		hash, err := auth.EncryptPassword(user.Password)
+		user.Roles, err = deps.RolePolicy.signupRoles(user.Roles)
 		if err != nil {
-			deps.Logger.RequestError(r, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
 
-		user.Password = hash
+		emailRegistration(deps, w, r, &user, safeRegistration(), nil)
+	}))))
+}
 
-		err = deps.DB.CreateUser(&user)
-		if err != nil {
-			deps.Logger.RequestError(r, err)
-			w.WriteHeader(http.StatusInternalServerError)
+// emailRegistration validates and registers the user responding with tokens.
+// The registered callback runs after the user is stored, before tokens are issued.
+func emailRegistration(deps *Deps, w http.ResponseWriter, r *http.Request, user *models.User, safe bool, registered func(user *models.User) error) {
+	taken := false
+
+	err := deps.Validator.Struct(user)
+	if err != nil {
+		errs := err.(validator.ValidationErrors)
+		if safe {
+			errs, taken = withoutUniqueEmail(errs)
+		}
+		if len(errs) > 0 {
+			respondModelError(deps, w, errs)
 			return
 		}
+	}
 
-		responseBody, err := createExternalUser(&user)
-		if err != nil {
-			deps.Logger.RequestError(r, err)
+	// Phone numbers are attached only after verification through an SMS code
+	user.Phone = ""
 
-			err = deps.DB.DeleteUser(user.ID)
-			if err != nil {
-				deps.Logger.RequestError(r, err)
-			}
+	hash, err := auth.EncryptPassword(user.Password)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-			if responseBody != nil {
-				respondExternal(w, http.StatusUnprocessableEntity, responseBody)
-				return
-			}
+	user.Password = hash
 
-			w.WriteHeader(http.StatusInternalServerError)
+	if taken {
+		notifyRegistrationAttempt(deps, r, user.Email)
+		respond(w, http.StatusOK, nil)
+		return
+	}
+
+	responseBody, err := registerUser(deps, r, user)
+	if err != nil {
+		if responseBody != nil {
+			respondExternal(w, http.StatusUnprocessableEntity, responseBody)
 			return
 		}
 
-		token, err := auth.Token(user.ID, user.Roles, deps.Keys)
-		if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if registered != nil {
+		if err = registered(user); err != nil {
 			deps.Logger.RequestError(r, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	}
 
-		err = saveTokenDetails(deps, user.ID, token)
-		if err != nil {
-			respondError(w, http.StatusUnauthorized, err.Error())
-			return
-		}
+	// Tokens would reveal that the email wasn't taken, the user logs in afterwards
+	if safe {
+		respond(w, http.StatusOK, nil)
+		return
+	}
 
-		respond(w, http.StatusOK, token)
-	}))))
+	// New users aren't members of any organization yet
+	userClaims, err := tokenClaims(deps, user, "")
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.Token(userClaims, deps.Keys)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = saveTokenDetails(deps, user.ID, token)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, token)
 }
 
 // EmailLogin validates user email and password combination
@@ -94,19 +136,77 @@ func EmailLogin(deps *Deps) http.Handler {
 			return
 		}
 
-		user, err := deps.DB.UserByEmail(loginUser.Email)
+		loginUser.Email = auth.NormalizeEmail(loginUser.Email)
+
+		if wait := loginRetryAfter(deps, loginUser.Email, clientIP(r)); wait > 0 {
+			auditLoginFailure(deps, r, 0, "password", map[string]interface{}{"email": loginUser.Email, "reason": "locked"})
+			respondLoginLocked(w, wait)
+			return
+		}
+
+		var user *models.User
+		method := "password"
+		if directory, ok := deps.Directories.ForEmail(loginUser.Email); ok {
+			method = "directory:" + directory.Name
+			user, err = directoryUser(deps, r, directory, loginUser.Email, loginUser.Password)
+			if err == ldapauth.ErrInvalidCredentials {
+				auditLoginFailure(deps, r, 0, method, map[string]interface{}{"email": loginUser.Email})
+				registerLoginFailure(deps, r, loginUser.Email)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+		} else {
+			user, err = deps.DB.UserByEmail(loginUser.Email)
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				// Spend as much time as for existing users to not reveal registered emails
+				auth.ValidatePassword(loginUser.Password, "")
+				auditLoginFailure(deps, r, 0, method, map[string]interface{}{"email": loginUser.Email})
+				registerLoginFailure(deps, r, loginUser.Email)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !auth.ValidatePassword(loginUser.Password, user.Password) {
+				auditLoginFailure(deps, r, user.ID, method, nil)
+				registerLoginFailure(deps, r, loginUser.Email)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		login, err := assessLogin(deps, w, r, user)
 		if err != nil {
 			deps.Logger.RequestError(r, err)
-			w.WriteHeader(http.StatusUnauthorized)
+			respondInternalError(w)
 			return
 		}
 
-		if !auth.ValidatePassword(loginUser.Password, user.Password) {
-			w.WriteHeader(http.StatusUnauthorized)
+		methods, err := mfaMethods(deps, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
 			return
 		}
+		if methods = loginMFAMethods(user, methods, login); len(methods) > 0 {
+			respondMFAChallenge(deps, w, r, user, methods)
+			return
+		}
+		// Failures are reset only when the login is complete, after the second factor otherwise
+		resetLoginFailures(deps, r, loginUser.Email)
+		recordLogin(deps, r, user, login)
 
-		token, err := auth.Token(user.ID, user.Roles, deps.Keys)
+		userClaims, err := tokenClaims(deps, user, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
 		if err != nil {
 			respondError(w, http.StatusUnauthorized, err.Error())
 			return
@@ -118,6 +218,43 @@ func EmailLogin(deps *Deps) http.Handler {
 			return
 		}
 
+		auditLoginSuccess(deps, r, user.ID, method)
 		respond(w, http.StatusOK, token)
 	}))))
 }
+
+// safeRegistration reports whether registration responds the same way for taken emails
+func safeRegistration() bool {
+	safe, _ := strconv.ParseBool(os.Getenv("SAFE_REGISTRATION"))
+
+	return safe
+}
+
+// withoutUniqueEmail removes the taken email error and reports whether it was present
+func withoutUniqueEmail(errs validator.ValidationErrors) (validator.ValidationErrors, bool) {
+	taken := false
+	result := make(validator.ValidationErrors, 0, len(errs))
+	for _, e := range errs {
+		if e.Tag() == "unique_user" {
+			taken = true
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	return result, taken
+}
+
+func notifyRegistrationAttempt(deps *Deps, r *http.Request, email string) {
+	user, err := deps.DB.UserByEmail(email)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		return
+	}
+
+	data := map[string]interface{}{"Email": user.Email}
+	if err = deps.Mailer.Deliver(user.Email, "registration_attempt", "", data); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+}
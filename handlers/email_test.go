@@ -50,6 +50,54 @@ func TestEmailRegister(t *testing.T) {
 
 		authtest.AssertStatusCode(t, recorder, http.StatusOK)
 	})
+
+	t.Run("returns UnprocessableEntity with taken email", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "12345678"}`))
+		recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns UnprocessableEntity with taken email in another case", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": " Test@MAIL.com", "password": "12345678"}`))
+		recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestSafeEmailRegister(t *testing.T) {
+	os.Setenv("SAFE_REGISTRATION", "true")
+	defer os.Unsetenv("SAFE_REGISTRATION")
+
+	externalApp := testServer()
+	defer externalApp.Close()
+
+	os.Setenv("API_HOST", externalApp.URL)
+
+	t.Run("returns UnprocessableEntity with invalid user data", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "short"}`))
+		recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+		if strings.Contains(recorder.Body.String(), "taken") {
+			t.Errorf("expected taken email to be hidden, got %q", recorder.Body.String())
+		}
+	})
+
+	for _, email := range []string{"test@mail.com", "valid@mail.com"} {
+		t.Run("returns OK without tokens for "+email, func(t *testing.T) {
+			body := bytes.NewBuffer([]byte(`{"email": "` + email + `", "password": "12345678"}`))
+			recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if recorder.Body.Len() > 0 {
+				t.Errorf("expected empty response for every email, got %q", recorder.Body.String())
+			}
+		})
+	}
 }
 
 func TestEmailLogin(t *testing.T) {
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const invalidIdentityID = handlerErr("Invalid Identity ID")
+const lastLoginMethod = handlerErr("Cannot unlink the last login method")
+
+// ListIdentities returns external identities linked to the current user
+func ListIdentities(deps *Deps) http.Handler {
+	return logHandler(deps, getHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		identities, err := deps.DB.UserIdentities(claims.UserID)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if identities == nil {
+			identities = []models.Identity{}
+		}
+
+		respond(w, http.StatusOK, map[string][]models.Identity{"identities": identities})
+	}))))
+}
+
+// LinkIdentity returns the provider authorization URL to link an identity to the current user
+func LinkIdentity(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		body := make(map[string]string)
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&body)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		provider, err := deps.Providers.Get(body["provider"])
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		location, err := startOAuth(deps, w, r, provider, claims.UserID)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, map[string]string{"url": location})
+	})))))
+}
+
+// UnlinkIdentity removes an external identity of the current user
+// unless it's the last way for the user to log in
+func UnlinkIdentity(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(deleteHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidIdentityID)
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		methods, err := loginMethods(deps, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if methods <= 1 {
+			respondError(w, http.StatusUnprocessableEntity, lastLoginMethod)
+			return
+		}
+
+		if err = deps.DB.DeleteIdentity(user.ID, id); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, nil)
+	})))))
+}
+
+// loginMethods counts independent ways for the user to log in
+func loginMethods(deps *Deps, user *models.User) (int, error) {
+	count := 0
+	if len(user.Password) > 0 {
+		count++
+	}
+	if len(user.Phone) > 0 {
+		count++
+	}
+
+	credentials, err := deps.DB.WebAuthnCredentials(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	count += len(credentials)
+
+	identities, err := deps.DB.UserIdentities(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	count += len(identities)
+
+	return count, nil
+}
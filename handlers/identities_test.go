@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestListIdentities(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns Unauthorized without token", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/identities", ListIdentities, nil, nil, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with linked identities", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/identities", ListIdentities, nil, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), `"subject":"linked"`) {
+			t.Errorf("expected linked identity, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestLinkIdentity(t *testing.T) {
+	idp := authtest.NewFakeIdP(t, "client")
+	defer idp.Close()
+	setTestProvider(t, idp)
+
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns UnprocessableEntity for unknown provider", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"provider": "unknown"}`))
+		recorder := performRequest(t, "POST", "/identities/link", LinkIdentity, body, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with authorization URL", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"provider": "test"}`))
+		recorder := performRequest(t, "POST", "/identities/link", LinkIdentity, body, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("links identity on callback", func(t *testing.T) {
+		idp.Claims["sub"] = "new"
+		idp.Claims["nonce"] = "nonce"
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthLinkState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthLinkState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns UnprocessableEntity when identity is linked to another user", func(t *testing.T) {
+		idp.Claims["sub"] = "linked"
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthLinkState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthLinkState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns UnprocessableEntity with invalid ID", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/identities/delete?id=", UnlinkIdentity, nil, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns UnprocessableEntity for the last login method", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/identities/delete?id=1", UnlinkIdentity, nil, authHeaders(t, privateKey, socialTestUser.ID), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK when user has a password", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/identities/delete?id=1", UnlinkIdentity, nil, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+
+	"github.com/maxshend/tiny_goauth/models"
 )
 
 const invalidUserID = handlerErr("Invalid User ID")
@@ -12,7 +14,7 @@ const blankRole = handlerErr("Blank Role Name")
 
 // DeleteUser removes a user record
 func DeleteUser(deps *Deps) http.Handler {
-	return logHandler(deps, jsonHandler(deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return logHandler(deps, internalHandler(deps, jsonHandler(deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
 		if err != nil {
 			respondError(w, http.StatusUnprocessableEntity, invalidUserID)
@@ -20,15 +22,18 @@ func DeleteUser(deps *Deps) http.Handler {
 		}
 
 		if err = deps.DB.DeleteUser(userID); err != nil {
+			audit(deps, r, &models.AuditEvent{SubjectID: userID, Action: auditUserDeleted, Outcome: auditFailure})
 			respondError(w, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
-	}))))
+
+		audit(deps, r, &models.AuditEvent{SubjectID: userID, Action: auditUserDeleted, Outcome: auditSuccess})
+	})))))
 }
 
 // CreateRoles creates a role record
 func CreateRoles(deps *Deps) http.Handler {
-	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 
 		body := make(map[string][]string)
@@ -58,12 +63,14 @@ func CreateRoles(deps *Deps) http.Handler {
 			respondError(w, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
-	}))))
+
+		audit(deps, r, &models.AuditEvent{Action: auditRolesCreated, Outcome: auditSuccess, Metadata: map[string]interface{}{"roles": roles}})
+	})))))
 }
 
 // DeleteRoles removes a role record
 func DeleteRoles(deps *Deps) http.Handler {
-	return logHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 
 		roles := r.Form["roles"]
@@ -83,5 +90,7 @@ func DeleteRoles(deps *Deps) http.Handler {
 			respondError(w, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
-	})))
+
+		audit(deps, r, &models.AuditEvent{Action: auditRolesDeleted, Outcome: auditSuccess, Metadata: map[string]interface{}{"roles": roles}})
+	}))))
 }
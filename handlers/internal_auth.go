@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/mtls"
+)
+
+// InternalAuth configures how callers of the internal API are authenticated.
+// A request is accepted when it passes any of the enabled methods.
+type InternalAuth struct {
+	Methods []string
+	// HMACSecret signs requests of the "hmac" method
+	HMACSecret []byte
+	// APIKeyHashes are hex encoded SHA-256 hashes of keys of the "api_key" method
+	APIKeyHashes []string
+	// AdminRole is the role access tokens of the "admin_token" method must have
+	AdminRole string
+	// ClientPermissions map verified client certificates of the "mtls" method to allowed internal paths
+	ClientPermissions mtls.Permissions
+}
+
+const (
+	internalAuthHMAC       = "hmac"
+	internalAuthAPIKey     = "api_key"
+	internalAuthAdminToken = "admin_token"
+	internalAuthMTLS       = "mtls"
+	internalAuthScopedKey  = "scoped_api_key"
+)
+
+const (
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+	apiKeyHeader             = "X-API-Key"
+)
+
+const signatureMaxSkew = 5 * time.Minute
+const defaultAdminRole = "admin"
+const auditInternalAuthFailed = "internal_auth_failed"
+
+const (
+	internalUnauthorized      = handlerErr("Invalid internal API credentials")
+	missingSignatureNonce     = handlerErr("Missing signature timestamp or nonce")
+	invalidSignatureTimestamp = handlerErr("Invalid signature timestamp")
+	expiredSignature          = handlerErr("Expired signature")
+	invalidSignature          = handlerErr("Invalid signature")
+	reusedSignatureNonce      = handlerErr("Reused signature nonce")
+	invalidInternalAPIKey     = handlerErr("Invalid API key")
+	invalidAdminToken         = handlerErr("Invalid access token")
+	missingAdminRole          = handlerErr("Access token doesn't have the admin role")
+	forbiddenClientCert       = handlerErr("Client certificate isn't allowed to perform the operation")
+)
+
+// LoadInternalAuth loads internal API authentication settings from the environment.
+// Access tokens with the admin role and scoped API keys are accepted when no methods are specified.
+func LoadInternalAuth() (*InternalAuth, error) {
+	a := &InternalAuth{
+		Methods:    []string{internalAuthAdminToken, internalAuthScopedKey},
+		HMACSecret: []byte(os.Getenv("INTERNAL_HMAC_SECRET")),
+		AdminRole:  os.Getenv("INTERNAL_ADMIN_ROLE"),
+	}
+	if methods := os.Getenv("INTERNAL_AUTH_METHODS"); len(methods) > 0 {
+		a.Methods = splitList(methods)
+	}
+	if hashes := os.Getenv("INTERNAL_API_KEY_HASHES"); len(hashes) > 0 {
+		a.APIKeyHashes = splitList(hashes)
+	}
+	if len(a.AdminRole) == 0 {
+		a.AdminRole = defaultAdminRole
+	}
+
+	for _, hash := range a.APIKeyHashes {
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("Invalid internal API key hash %q", hash)
+		}
+	}
+
+	for _, method := range a.Methods {
+		switch method {
+		case internalAuthHMAC:
+			if len(a.HMACSecret) == 0 {
+				return nil, fmt.Errorf("INTERNAL_HMAC_SECRET is required for %s internal authentication", method)
+			}
+		case internalAuthAPIKey:
+			if len(a.APIKeyHashes) == 0 {
+				return nil, fmt.Errorf("INTERNAL_API_KEY_HASHES is required for %s internal authentication", method)
+			}
+		case internalAuthMTLS:
+			path := os.Getenv("TLS_CLIENT_PERMISSIONS_PATH")
+			if len(path) == 0 {
+				return nil, fmt.Errorf("TLS_CLIENT_PERMISSIONS_PATH is required for %s internal authentication", method)
+			}
+
+			permissions, err := mtls.LoadPermissions(path)
+			if err != nil {
+				return nil, err
+			}
+			a.ClientPermissions = permissions
+		case internalAuthAdminToken, internalAuthScopedKey:
+		default:
+			return nil, fmt.Errorf("Unknown internal authentication method %q", method)
+		}
+	}
+
+	return a, nil
+}
+
+func (a *InternalAuth) enabled(method string) bool {
+	for _, m := range a.Methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// internalHandler rejects requests to the internal API without valid credentials.
+// The authenticated actor is stored in the request context for the audit trail.
+func internalHandler(deps *Deps, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := deps.InternalAuth
+		if a == nil {
+			respondError(w, http.StatusUnauthorized, internalUnauthorized)
+			return
+		}
+
+		var actor string
+		var claims *auth.Claims
+		var apiKey *models.APIKey
+		var err error
+
+		switch {
+		case r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && a.enabled(internalAuthMTLS):
+			actor, err = verifyClientCertificate(a, r)
+		case len(r.Header.Get(signatureHeader)) > 0 && a.enabled(internalAuthHMAC):
+			actor, err = "hmac", verifySignature(deps, r)
+		case len(r.Header.Get(apiKeyHeader)) > 0 && a.enabled(internalAuthAPIKey):
+			actor, err = verifyInternalAPIKey(a, r.Header.Get(apiKeyHeader))
+		case strings.HasPrefix(r.Header.Get(auhtorizationHeader), apiKeyScheme) && a.enabled(internalAuthScopedKey):
+			apiKey, err = verifyScopedAPIKey(deps, r)
+			if err == nil {
+				actor = "api_key:" + apiKey.Prefix
+			}
+		case len(r.Header.Get(auhtorizationHeader)) > 0 && a.enabled(internalAuthAdminToken):
+			claims, err = verifyAdminToken(deps, r.Header.Get(auhtorizationHeader))
+			if err == nil && len(claims.Tenant) > 0 && !tenantInternalPaths[r.URL.Path] {
+				err = tenantOutOfScope
+			}
+			if err == nil {
+				actor = "user:" + strconv.FormatInt(claims.UserID, 10)
+			}
+		default:
+			err = internalUnauthorized
+		}
+
+		if err != nil {
+			audit(deps, r, &models.AuditEvent{
+				Action:   auditInternalAuthFailed,
+				Outcome:  auditFailure,
+				Metadata: map[string]interface{}{"path": r.URL.Path, "reason": err.Error()},
+			})
+			respondError(w, http.StatusUnauthorized, internalUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), internalActorKey, actor)
+		if claims != nil {
+			ctx = context.WithValue(ctx, tokenClaimsKey, claims)
+		}
+		if apiKey != nil {
+			ctx = context.WithValue(ctx, apiKeyKey, apiKey)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// verifySignature checks the HMAC-SHA256 signature of the method, the URI, the timestamp,
+// the nonce and the body hash separated by new lines. Every nonce is accepted only once.
+func verifySignature(deps *Deps, r *http.Request) error {
+	timestamp := r.Header.Get(signatureTimestampHeader)
+	nonce := r.Header.Get(signatureNonceHeader)
+	if len(timestamp) == 0 || len(nonce) == 0 {
+		return missingSignatureNonce
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return invalidSignatureTimestamp
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return expiredSignature
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+	}
+
+	expected := SignInternalRequest(deps.InternalAuth.HMACSecret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil || !hmac.Equal(signature, expected) {
+		return invalidSignature
+	}
+
+	fresh, err := deps.DB.StoreCacheNX("internal_nonce:"+nonce, true, 2*signatureMaxSkew)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return reusedSignatureNonce
+	}
+
+	return nil
+}
+
+// SignInternalRequest returns the HMAC-SHA256 signature of an internal API request
+func SignInternalRequest(secret []byte, method, uri, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")))
+
+	return mac.Sum(nil)
+}
+
+func verifyInternalAPIKey(a *InternalAuth, key string) (string, error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+
+	for _, expected := range a.APIKeyHashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(strings.ToLower(expected))) == 1 {
+			return "api_key:" + expected[:8], nil
+		}
+	}
+
+	return "", invalidInternalAPIKey
+}
+
+// verifyClientCertificate checks that the verified client certificate is allowed to call the path
+func verifyClientCertificate(a *InternalAuth, r *http.Request) (string, error) {
+	identity, ok := a.ClientPermissions.Allowed(r.TLS.VerifiedChains[0][0], r.URL.Path)
+	if !ok {
+		return "", forbiddenClientCert
+	}
+
+	return "cert:" + identity, nil
+}
+
+func verifyAdminToken(deps *Deps, token string) (*auth.Claims, error) {
+	c, err := auth.ValidateToken(token, deps.Keys.AccessVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := c.(*auth.Claims)
+	if !ok || claims == nil || tokensRevoked(deps, claims) {
+		return nil, invalidAdminToken
+	}
+
+	roles := claims.Roles
+	// Tokens with only permissions in claims are checked against current roles of the user
+	if roles == nil && len(claims.Tenant) > 0 {
+		membership, err := deps.DB.Membership(claims.Tenant, claims.UserID)
+		if err != nil {
+			return nil, invalidAdminToken
+		}
+
+		roles = membership.EffectiveRoles
+	} else if roles == nil {
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			return nil, invalidAdminToken
+		}
+
+		roles = user.EffectiveRoles
+	}
+
+	for _, role := range roles {
+		if role == deps.InternalAuth.AdminRole {
+			return claims, nil
+		}
+	}
+
+	return nil, missingAdminRole
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
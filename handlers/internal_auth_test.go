@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/mtls"
+)
+
+const testInternalAPIKey = "internal-api-key"
+
+var testInternalAPIKeyHash = func() string {
+	sum := sha256.Sum256([]byte(testInternalAPIKey))
+	return hex.EncodeToString(sum[:])
+}()
+
+var testInternalAuth = &InternalAuth{
+	Methods:      []string{internalAuthHMAC, internalAuthAPIKey, internalAuthAdminToken, internalAuthScopedKey},
+	HMACSecret:   []byte("secret"),
+	APIKeyHashes: []string{testInternalAPIKeyHash},
+	AdminRole:    defaultAdminRole,
+}
+
+var internalHeaders = map[string]string{apiKeyHeader: testInternalAPIKey}
+var internalJSONHeaders = map[string]string{contentTypeHeader: jsonContentType, apiKeyHeader: testInternalAPIKey}
+var internalFormHeaders = map[string]string{contentTypeHeader: "application/x-www-form-urlencoded", apiKeyHeader: testInternalAPIKey}
+
+func signedHeaders(method, uri, nonce string, timestamp time.Time, body []byte) map[string]string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	return map[string]string{
+		contentTypeHeader:        jsonContentType,
+		signatureTimestampHeader: ts,
+		signatureNonceHeader:     nonce,
+		signatureHeader:          hex.EncodeToString(SignInternalRequest(testInternalAuth.HMACSecret, method, uri, ts, nonce, body)),
+	}
+}
+
+func TestInternalHandler(t *testing.T) {
+	path := "/internal/roles"
+	body := []byte(`{"roles": ["staff"]}`)
+
+	t.Run("returns Unauthorized without credentials", func(t *testing.T) {
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+
+		if e := lastAuditEvent(t); e.Action != auditInternalAuthFailed || e.Metadata["path"] != path {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns Unauthorized with invalid API key", func(t *testing.T) {
+		headers := map[string]string{contentTypeHeader: jsonContentType, apiKeyHeader: "invalid"}
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("records API key actor", func(t *testing.T) {
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditRolesCreated || e.Metadata["actor"] != "api_key:"+testInternalAPIKeyHash[:8] {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("accepts signed request only once", func(t *testing.T) {
+		headers := signedHeaders("POST", path, "nonce-1", time.Now(), body)
+
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, nil)
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		recorder = performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, nil)
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with tampered body", func(t *testing.T) {
+		headers := signedHeaders("POST", path, "nonce-2", time.Now(), body)
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBufferString(`{"roles": ["admin"]}`), headers, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with expired signature", func(t *testing.T) {
+		headers := signedHeaders("POST", path, "nonce-3", time.Now().Add(-time.Hour), body)
+		recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("with access tokens", func(t *testing.T) {
+		privateKey, err := authtest.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := &auth.RSAKeys{AccessSign: privateKey, AccessVerify: &privateKey.PublicKey, RefreshSign: privateKey, RefreshVerify: &privateKey.PublicKey}
+
+		admin, err := auth.Token(auth.Claims{UserID: 1, Roles: []string{defaultAdminRole}}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := auth.Token(auth.Claims{UserID: 1, Roles: []string{"user"}}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("returns OK for admin", func(t *testing.T) {
+			headers := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: admin.Access}
+			recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, privateKey)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if e := lastAuditEvent(t); e.ActorID != 1 {
+				t.Errorf("expected admin actor, got %+v", e)
+			}
+		})
+
+		t.Run("returns Unauthorized without admin role", func(t *testing.T) {
+			headers := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: user.Access}
+			recorder := performRequest(t, "POST", path, CreateRoles, bytes.NewBuffer(body), headers, privateKey)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestLoadInternalAuth(t *testing.T) {
+	defer os.Unsetenv("INTERNAL_AUTH_METHODS")
+	defer os.Unsetenv("INTERNAL_API_KEY_HASHES")
+
+	t.Run("accepts admin tokens by default", func(t *testing.T) {
+		a, err := LoadInternalAuth()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !a.enabled(internalAuthAdminToken) || a.enabled(internalAuthAPIKey) || a.AdminRole != defaultAdminRole {
+			t.Errorf("got unexpected settings %+v", a)
+		}
+	})
+
+	t.Run("returns error without HMAC secret", func(t *testing.T) {
+		os.Setenv("INTERNAL_AUTH_METHODS", "hmac")
+
+		if _, err := LoadInternalAuth(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error with invalid API key hash", func(t *testing.T) {
+		os.Setenv("INTERNAL_AUTH_METHODS", "api_key")
+		os.Setenv("INTERNAL_API_KEY_HASHES", "plain-key")
+
+		if _, err := LoadInternalAuth(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error without client certificate permissions", func(t *testing.T) {
+		os.Setenv("INTERNAL_AUTH_METHODS", "mtls")
+
+		if _, err := LoadInternalAuth(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error for unknown method", func(t *testing.T) {
+		os.Setenv("INTERNAL_AUTH_METHODS", "api_key, basic")
+		os.Setenv("INTERNAL_API_KEY_HASHES", testInternalAPIKeyHash)
+
+		if _, err := LoadInternalAuth(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestInternalHandlerClientCertificate(t *testing.T) {
+	a := &InternalAuth{
+		Methods:           []string{internalAuthMTLS, internalAuthAPIKey},
+		APIKeyHashes:      []string{testInternalAPIKeyHash},
+		ClientPermissions: mtls.Permissions{"cn:billing": {"/internal/users/delete"}},
+	}
+	deps := &Deps{DB: &testDL{}, InternalAuth: a}
+
+	var actor interface{}
+	h := internalHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = r.Context().Value(internalActorKey)
+	}))
+	request := func(path string, cert *x509.Certificate) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("DELETE", path, nil)
+		r.Header.Set(apiKeyHeader, testInternalAPIKey)
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+
+	t.Run("records certificate actor for allowed operation", func(t *testing.T) {
+		recorder := request("/internal/users/delete", billing)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if actor != "cert:cn:billing" {
+			t.Errorf("got unexpected actor %v", actor)
+		}
+	})
+
+	t.Run("returns Unauthorized for other operations", func(t *testing.T) {
+		recorder := request("/internal/roles/delete", billing)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+
+		if e := lastAuditEvent(t); e.Metadata["reason"] != forbiddenClientCert.Error() {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("falls back to other methods without client certificate", func(t *testing.T) {
+		recorder := request("/internal/roles/delete", nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
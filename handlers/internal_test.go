@@ -10,31 +10,31 @@ import (
 
 func TestDeleteUser(t *testing.T) {
 	t.Run("returns MethodNotAllowed for non-delete requests", func(t *testing.T) {
-		recorder := performRequest(t, "GET", "/internal/users/delete", DeleteUser, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "GET", "/internal/users/delete", DeleteUser, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
 	})
 
 	t.Run("returns BadRequest without json 'Content-Type' header", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/users/delete", DeleteUser, nil, nil, nil)
+		recorder := performRequest(t, "DELETE", "/internal/users/delete", DeleteUser, nil, internalHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusBadRequest)
 	})
 
 	t.Run("returns UnprocessableEntity when User with ID doesn't exist", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=0", DeleteUser, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=0", DeleteUser, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns UnprocessableEntity with invalid User ID", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=", DeleteUser, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=", DeleteUser, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns OK with valid User ID", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=1", DeleteUser, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/users/delete?id=1", DeleteUser, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusOK)
 	})
@@ -42,48 +42,48 @@ func TestDeleteUser(t *testing.T) {
 
 func TestCreateRoles(t *testing.T) {
 	t.Run("returns MethodNotAllowed for non-post requests", func(t *testing.T) {
-		recorder := performRequest(t, "GET", "/internal/roles", CreateRoles, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "GET", "/internal/roles", CreateRoles, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
 	})
 
 	t.Run("returns BadRequest without json 'Content-Type' header", func(t *testing.T) {
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, nil, nil, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, nil, internalHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusBadRequest)
 	})
 
 	t.Run("returns UnprocessableEntity when Role already exists", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{"roles": ["duplicate"]}`))
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, jsonHeaders, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns UnprocessableEntity with blank roles array", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{"roles": []}`))
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, jsonHeaders, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns UnprocessableEntity with invalid roles type", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{"roles": "invalid"}`))
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, jsonHeaders, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns UnprocessableEntity with invalid Role Name", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{"roles": [""]}`))
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, jsonHeaders, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns OK with valid Role", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{"roles": ["test"]}`))
-		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, jsonHeaders, nil)
+		recorder := performRequest(t, "POST", "/internal/roles", CreateRoles, body, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusOK)
 	})
@@ -91,25 +91,25 @@ func TestCreateRoles(t *testing.T) {
 
 func TestDeleteRole(t *testing.T) {
 	t.Run("returns MethodNotAllowed for non-delete requests", func(t *testing.T) {
-		recorder := performRequest(t, "GET", "/internal/roles/delete", DeleteRoles, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "GET", "/internal/roles/delete", DeleteRoles, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
 	})
 
 	t.Run("returns UnprocessableEntity when Role with name doesn't exist", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=not_found", DeleteRoles, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=not_found", DeleteRoles, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns UnprocessableEntity with invalid Role Name", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=", DeleteRoles, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=", DeleteRoles, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
 	})
 
 	t.Run("returns OK with valid Role Name", func(t *testing.T) {
-		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=test", DeleteRoles, nil, jsonHeaders, nil)
+		recorder := performRequest(t, "DELETE", "/internal/roles/delete?roles=test", DeleteRoles, nil, internalJSONHeaders, nil)
 
 		authtest.AssertStatusCode(t, recorder, http.StatusOK)
 	})
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const invitationPurpose = "invitation"
+const defaultInvitationTTL = 7 * 24 * time.Hour
+const defaultInvitationEndpoint = "/invitation"
+const defaultInvitationsLimit = 50
+const maxInvitationsLimit = 200
+
+const invalidInvitationEmail = handlerErr("Invalid Email")
+const invalidInvitationID = handlerErr("Invalid Invitation ID")
+const invalidInvitation = handlerErr("Invitation is invalid or has expired")
+const invitedUserExists = handlerErr("User with this email already exists")
+
+type invitationParams struct {
+	Email     string     `json:"email"`
+	Roles     []string   `json:"roles"`
+	Inviter   string     `json:"inviter"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Locale    string     `json:"locale"`
+}
+
+// invitationResponse contains the invite token which is shown only once
+type invitationResponse struct {
+	*models.Invitation
+	Token string `json:"token"`
+}
+
+type invitationsPage struct {
+	Invitations []models.Invitation `json:"invitations"`
+	NextCursor  string              `json:"next_cursor,omitempty"`
+}
+
+type acceptInvitationParams struct {
+	Token    string                 `json:"token"`
+	Password string                 `json:"password"`
+	Payload  map[string]interface{} `json:"payload"`
+}
+
+// CreateInvitation invites the email to register with the roles and sends the invite link.
+// The inviter defaults to the caller of the internal API.
+func CreateInvitation(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params invitationParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		params.Email = auth.NormalizeEmail(params.Email)
+		if err = deps.Validator.Var(params.Email, "required,email"); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidInvitationEmail)
+			return
+		}
+		if _, err = deps.DB.UserByEmail(params.Email); err == nil {
+			respondError(w, http.StatusUnprocessableEntity, invitedUserExists)
+			return
+		}
+
+		if _, err = deps.RolePolicy.invitationRoles(params.Roles); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if err = deps.Validator.Var(params.Roles, "roles"); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, unknownRole)
+			return
+		}
+
+		expiresAt := time.Now().Add(defaultInvitationTTL)
+		if params.ExpiresAt != nil {
+			if !params.ExpiresAt.After(time.Now()) {
+				respondError(w, http.StatusUnprocessableEntity, invalidExpiration)
+				return
+			}
+
+			expiresAt = *params.ExpiresAt
+		}
+
+		// Emails name only the given inviter rather than the caller of the internal API
+		data := map[string]interface{}{"Inviter": params.Inviter, "ExpiresAt": expiresAt.UTC().Format(time.RFC1123)}
+		if len(params.Inviter) == 0 {
+			params.Inviter, _ = r.Context().Value(internalActorKey).(string)
+		}
+
+		token, id, err := auth.LinkToken(0, invitationPurpose, "", time.Until(expiresAt), deps.Keys.RefreshSign)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		invitation := &models.Invitation{
+			Email:     params.Email,
+			Roles:     params.Roles,
+			Inviter:   params.Inviter,
+			TokenID:   id,
+			ExpiresAt: expiresAt,
+		}
+		if err = deps.DB.CreateInvitation(invitation); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		data["URL"] = invitationURL(token)
+		if err = deps.Mailer.Deliver(invitation.Email, "invitation", params.Locale, data); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditInvitationCreate, Outcome: auditSuccess, Metadata: invitationMetadata(invitation)})
+		respond(w, http.StatusOK, &invitationResponse{Invitation: invitation, Token: token})
+	})))))
+}
+
+// ListInvitations returns invitations including accepted and revoked ones, newest first
+func ListInvitations(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before, limit, err := pageParams(r, defaultInvitationsLimit, maxInvitationsLimit)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		// One more invitation is requested to know whether there is a next page
+		invitations, err := deps.DB.Invitations(before, limit+1)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		page := &invitationsPage{Invitations: invitations}
+		if page.Invitations == nil {
+			page.Invitations = []models.Invitation{}
+		}
+		if len(invitations) > limit {
+			page.Invitations = invitations[:limit]
+			page.NextCursor = strconv.FormatInt(page.Invitations[limit-1].ID, 10)
+		}
+
+		respond(w, http.StatusOK, page)
+	}))))
+}
+
+// RevokeInvitation disables the pending invitation so its token can't be accepted
+func RevokeInvitation(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidInvitationID)
+			return
+		}
+
+		if err = deps.DB.RevokeInvitation(id); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditInvitationRevoke, Outcome: auditSuccess, Metadata: map[string]interface{}{"invitation_id": id}})
+	}))))
+}
+
+// AcceptInvitation registers the invited user with the invited roles and returns access and refresh tokens.
+// The email is taken from the invitation since receiving the invite token verifies it.
+func AcceptInvitation(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params acceptInvitationParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if len(params.Token) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankToken)
+			return
+		}
+
+		claims, err := auth.ValidateLinkToken(params.Token, invitationPurpose, deps.Keys.RefreshVerify)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		invitation, err := deps.DB.InvitationByTokenID(claims.Id)
+		if err != nil || !invitation.Pending(time.Now()) {
+			respondError(w, http.StatusUnauthorized, invalidInvitation)
+			return
+		}
+
+		roles, err := deps.RolePolicy.invitationRoles(invitation.Roles)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		user := &models.User{Email: invitation.Email, Password: params.Password, Payload: params.Payload, Roles: roles}
+
+		emailRegistration(deps, w, r, user, false, func(user *models.User) error {
+			if err := deps.DB.AcceptInvitation(invitation.ID, user.ID); err != nil {
+				return err
+			}
+
+			audit(deps, r, &models.AuditEvent{
+				ActorID:   user.ID,
+				SubjectID: user.ID,
+				Action:    auditInvitationAccept,
+				Outcome:   auditSuccess,
+				Metadata:  invitationMetadata(invitation),
+			})
+
+			return nil
+		})
+	}))))
+}
+
+func invitationMetadata(invitation *models.Invitation) map[string]interface{} {
+	return map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"email":         invitation.Email,
+		"roles":         invitation.Roles,
+		"inviter":       invitation.Inviter,
+	}
+}
+
+// invitationURL points to the page of the client application (APP_URL) which posts the token to AcceptInvitation
+func invitationURL(token string) string {
+	endpoint, found := os.LookupEnv("INVITATION_URL")
+	if !found || len(endpoint) == 0 {
+		endpoint = os.Getenv("APP_URL") + defaultInvitationEndpoint
+	}
+
+	return endpoint + "?token=" + url.QueryEscape(token)
+}
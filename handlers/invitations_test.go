@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// newInvitedEmail doesn't belong to any user
+const newInvitedEmail = "new@mail.com"
+
+var testInvitationRevokedAt = time.Now().Add(-time.Hour)
+
+// testInvitations are returned by their token IDs
+var testInvitations = map[string]models.Invitation{
+	"pending":  {ID: 1, Email: "invited@mail.com", Roles: []string{"editor"}, Inviter: "admin", TokenID: "pending", ExpiresAt: time.Now().Add(time.Hour)},
+	"expired":  {ID: 2, Email: "expired@mail.com", TokenID: "expired", ExpiresAt: time.Now().Add(-time.Hour)},
+	"revoked":  {ID: 3, Email: "revoked@mail.com", TokenID: "revoked", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &testInvitationRevokedAt},
+	"internal": {ID: 4, Email: "internal@mail.com", Roles: []string{"admin"}, TokenID: "internal", ExpiresAt: time.Now().Add(time.Hour)},
+}
+
+func testInvitationByID(id int64) *models.Invitation {
+	for _, invitation := range testInvitations {
+		if invitation.ID == id {
+			return &invitation
+		}
+	}
+
+	return nil
+}
+
+func testInviteToken(t *testing.T, key *rsa.PrivateKey, tokenID, purpose string) string {
+	claims := auth.LinkClaims{Purpose: purpose, StandardClaims: jwt.StandardClaims{Id: tokenID, Audience: auth.LinkAudience, ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+
+	return authtest.GenerateFakeJWT(t, key, jwt.SigningMethodRS256, claims)
+}
+
+func TestCreateInvitation(t *testing.T) {
+	path := "/internal/invitations"
+
+	t.Run("returns the invitation with the token", func(t *testing.T) {
+		body := []byte(`{"email": " New@Mail.com", "roles": ["editor"], "inviter": "Jane"}`)
+		recorder := performRequest(t, "POST", path, CreateInvitation, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var response struct {
+			Email   string `json:"email"`
+			Inviter string `json:"inviter"`
+			Token   string `json:"token"`
+			TokenID string `json:"token_id"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Email != "new@mail.com" || response.Inviter != "Jane" || len(response.Token) == 0 || len(response.TokenID) > 0 {
+			t.Errorf("got unexpected response %s", recorder.Body.String())
+		}
+
+		if e := lastAuditEvent(t); e.Action != auditInvitationCreate || e.Metadata["email"] != "new@mail.com" {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("records the caller as the inviter by default", func(t *testing.T) {
+		body := []byte(`{"email": "new@mail.com"}`)
+		recorder := performRequest(t, "POST", path, CreateInvitation, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Metadata["inviter"] != e.Metadata["actor"] || e.Metadata["inviter"] == "" {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  handlerErr
+	}{
+		"invalid email":   {`{"email": "invalid.mail.com"}`, invalidInvitationEmail},
+		"existing user":   {`{"email": "test@mail.com"}`, invitedUserExists},
+		"internal role":   {`{"email": "new@mail.com", "roles": ["admin"]}`, forbiddenRole},
+		"past expiration": {`{"email": "new@mail.com", "expires_at": "2020-01-01T00:00:00Z"}`, invalidExpiration},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, CreateInvitation, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err.Error()) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestListInvitations(t *testing.T) {
+	t.Run("returns invitations newest first with the next cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/invitations/list?limit=3", ListInvitations, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var page invitationsPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Invitations) != 3 || page.Invitations[0].ID != 4 || page.NextCursor != "2" {
+			t.Errorf("got unexpected page %+v", page)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity with invalid cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/invitations/list?cursor=abc", ListInvitations, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	t.Run("returns OK for pending invitation", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/invitations/delete?id=1", RevokeInvitation, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditInvitationRevoke {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity for revoked invitation", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/invitations/delete?id=3", RevokeInvitation, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	path := "/invitations/accept"
+
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	externalApp := testServer()
+	defer externalApp.Close()
+
+	os.Setenv("API_HOST", externalApp.URL)
+
+	t.Run("registers the invited user and returns tokens", func(t *testing.T) {
+		body := `{"token": "` + testInviteToken(t, key, "pending", invitationPurpose) + `", "password": "12345678"}`
+		recorder := performRequest(t, "POST", path, AcceptInvitation, strings.NewReader(body), jsonHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var token auth.TokenDetails
+		if err := json.Unmarshal(recorder.Body.Bytes(), &token); err != nil || len(token.Access) == 0 {
+			t.Errorf("expected tokens, got %s", recorder.Body.String())
+		}
+
+		if e := lastAuditEvent(t); e.Action != auditInvitationAccept || e.Metadata["invitation_id"] != int64(1) {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity with short password", func(t *testing.T) {
+		body := `{"token": "` + testInviteToken(t, key, "pending", invitationPurpose) + `", "password": "short"}`
+		recorder := performRequest(t, "POST", path, AcceptInvitation, strings.NewReader(body), jsonHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns UnprocessableEntity when invited roles became internal", func(t *testing.T) {
+		body := `{"token": "` + testInviteToken(t, key, "internal", invitationPurpose) + `", "password": "12345678"}`
+		recorder := performRequest(t, "POST", path, AcceptInvitation, strings.NewReader(body), jsonHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	unauthorized := map[string]string{
+		"expired invitation": testInviteToken(t, key, "expired", invitationPurpose),
+		"revoked invitation": testInviteToken(t, key, "revoked", invitationPurpose),
+		"unknown invitation": testInviteToken(t, key, "unknown", invitationPurpose),
+		"another purpose":    testInviteToken(t, key, "pending", magicLinkPurpose),
+	}
+	for name, token := range unauthorized {
+		t.Run("returns Unauthorized with "+name, func(t *testing.T) {
+			body := `{"token": "` + token + `", "password": "12345678"}`
+			recorder := performRequest(t, "POST", path, AcceptInvitation, strings.NewReader(body), jsonHeaders, key)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestInvitationURL(t *testing.T) {
+	defer os.Unsetenv("INVITATION_URL")
+	defer os.Unsetenv("APP_URL")
+	os.Setenv("APP_URL", "https://app.example.com")
+	os.Setenv("APP_HOST", "https://auth.example.com")
+	defer os.Unsetenv("APP_HOST")
+
+	t.Run("points to the page of the application by default", func(t *testing.T) {
+		os.Unsetenv("INVITATION_URL")
+
+		if got := invitationURL("a+b"); got != "https://app.example.com/invitation?token=a%2Bb" {
+			t.Errorf("got unexpected URL %q", got)
+		}
+	})
+
+	t.Run("uses the configured URL", func(t *testing.T) {
+		os.Setenv("INVITATION_URL", "https://example.com/join")
+
+		if got := invitationURL("a"); got != "https://example.com/join?token=a" {
+			t.Errorf("got unexpected URL %q", got)
+		}
+	})
+}
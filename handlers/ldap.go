@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/maxshend/tiny_goauth/ldapauth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// directoryUser authenticates the user against the directory and returns the local user
+// provisioning it on the first login. Roles mapped from directory groups are synchronized with groups of the user,
+// other roles of the user are kept.
+func directoryUser(deps *Deps, r *http.Request, directory *ldapauth.Directory, email, password string) (*models.User, error) {
+	entry, err := directory.Authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := deps.DB.UserByEmail(email)
+	if err != nil {
+		user = &models.User{Email: email}
+		if _, err = registerUser(deps, r, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = deps.DB.SyncUserRoles(user.ID, directory.ManagedRoles(), directory.Roles(entry)); err != nil {
+		return nil, err
+	}
+
+	updated, err := deps.DB.UserByID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	auditRolesChange(deps, r, user.ID, user.Roles, updated.Roles, "directory:"+directory.Name)
+
+	return updated, nil
+}
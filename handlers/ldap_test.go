@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/ldapauth"
+)
+
+func setTestDirectory(t *testing.T, server *authtest.FakeLDAP) {
+	t.Helper()
+
+	d := &ldapauth.Directory{
+		Name:         "corp",
+		Domains:      []string{"corp.local"},
+		URL:          server.URL,
+		BindDN:       "cn=service,dc=corp,dc=local",
+		BindPassword: "service",
+		BaseDN:       "dc=corp,dc=local",
+		GroupRoles:   map[string][]string{"cn=admins,dc=corp,dc=local": {"admin"}},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	testDirectories["corp.local"] = d
+	t.Cleanup(func() { delete(testDirectories, "corp.local") })
+}
+
+func TestDirectoryLogin(t *testing.T) {
+	server := authtest.NewFakeLDAP(t,
+		authtest.LDAPEntry{DN: "cn=service,dc=corp,dc=local", Password: "service"},
+		authtest.LDAPEntry{
+			DN:         "cn=staff,dc=corp,dc=local",
+			Password:   "secret",
+			Attributes: map[string][]string{"mail": {"staff@corp.local"}, "memberOf": {"cn=admins,dc=corp,dc=local"}},
+		},
+		authtest.LDAPEntry{
+			DN:         "cn=new,dc=corp,dc=local",
+			Password:   "secret",
+			Attributes: map[string][]string{"mail": {newDirectoryEmail}},
+		},
+	)
+	defer server.Close()
+	setTestDirectory(t, server)
+
+	t.Run("returns Unauthorized with invalid password", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "staff@corp.local", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized for user missing in directory", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@corp.local", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with valid directory credentials", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "staff@corp.local", "password": "secret"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("provisions user on the first login", func(t *testing.T) {
+		externalApp := testServer()
+		defer externalApp.Close()
+
+		os.Setenv("API_HOST", externalApp.URL)
+
+		body := bytes.NewBuffer([]byte(`{"email": "` + newDirectoryEmail + `", "password": "secret"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns InternalServerError when directory is unavailable", func(t *testing.T) {
+		testDirectories["corp.local"].URL = "ldap://127.0.0.1:1"
+
+		body := bytes.NewBuffer([]byte(`{"email": "staff@corp.local", "password": "secret"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusInternalServerError)
+	})
+}
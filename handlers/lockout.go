@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const loginLockoutThreshold = 10
+const loginIPLockoutThreshold = 100
+const loginLockoutDuration = 15 * time.Minute
+const loginFailuresWindow = time.Hour
+const loginBackoffStart = 3
+const loginBackoffBase = time.Second
+
+const loginLocked = handlerErr("Too many failed login attempts. Try again later")
+
+// UnlockUser clears failed login attempts and the temporary lock of the email
+func UnlockUser(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email := r.FormValue("email")
+		if len(email) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankEmail)
+			return
+		}
+
+		resetLoginFailures(deps, r, email)
+		audit(deps, r, &models.AuditEvent{Action: auditUserUnlocked, Outcome: auditSuccess, Metadata: map[string]interface{}{"email": email}})
+	})))))
+}
+
+// loginRetryAfter returns how long login attempts for the email or from the IP are blocked
+func loginRetryAfter(deps *Deps, email, ip string) time.Duration {
+	var wait time.Duration
+	for _, key := range []string{loginBlockedKey("email", auth.NormalizeEmail(email)), loginBlockedKey("ip", ip)} {
+		value, err := deps.DB.GetCacheValue(key)
+		if err != nil || len(value) == 0 {
+			continue
+		}
+
+		until, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if d := time.Until(time.Unix(until, 0)); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// respondLoginLocked responds the same way for existing and unknown accounts
+func respondLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	respondError(w, http.StatusTooManyRequests, loginLocked)
+}
+
+// registerLoginFailure counts the failed attempt and delays further attempts exponentially.
+// The account owner is notified when the account gets locked.
+func registerLoginFailure(deps *Deps, r *http.Request, email string) {
+	email = auth.NormalizeEmail(email)
+	ip := clientIP(r)
+
+	failures, err := deps.DB.IncrCache(loginFailuresKey("email", email), loginFailuresWindow)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		return
+	}
+	if delay := loginDelay(failures); delay > 0 {
+		blockLogin(deps, r, loginBlockedKey("email", email), delay)
+	}
+	if failures == loginLockoutThreshold {
+		notifyAccountLocked(deps, r, email, ip)
+	}
+
+	ipFailures, err := deps.DB.IncrCache(loginFailuresKey("ip", ip), loginFailuresWindow)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		return
+	}
+	if ipFailures >= loginIPLockoutThreshold {
+		blockLogin(deps, r, loginBlockedKey("ip", ip), loginLockoutDuration)
+	}
+}
+
+// resetLoginFailures clears failed attempts of the email after a successful login
+func resetLoginFailures(deps *Deps, r *http.Request, email string) {
+	email = auth.NormalizeEmail(email)
+
+	for _, key := range []string{loginFailuresKey("email", email), loginBlockedKey("email", email)} {
+		if _, err := deps.DB.DeleteCache(key); err != nil {
+			deps.Logger.RequestError(r, err)
+		}
+	}
+}
+
+// loginDelay returns the wait time before the next attempt after the number of failures
+func loginDelay(failures int64) time.Duration {
+	if failures >= loginLockoutThreshold {
+		return loginLockoutDuration
+	}
+	if failures < loginBackoffStart {
+		return 0
+	}
+
+	delay := loginBackoffBase << uint(failures-loginBackoffStart)
+	if delay > loginLockoutDuration {
+		return loginLockoutDuration
+	}
+
+	return delay
+}
+
+func blockLogin(deps *Deps, r *http.Request, key string, delay time.Duration) {
+	until := time.Now().Add(delay).Unix()
+	if err := deps.DB.StoreCache(key, until, delay); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+}
+
+func notifyAccountLocked(deps *Deps, r *http.Request, email, ip string) {
+	user, err := deps.DB.UserByEmail(email)
+	if err != nil {
+		return
+	}
+
+	data := map[string]interface{}{"LockedFor": loginLockoutDuration.String(), "IP": ip}
+	if err = deps.Mailer.Deliver(user.Email, "account_locked", "", data); err != nil {
+		deps.Logger.RequestError(r, err)
+	}
+}
+
+// loginName returns the email failed attempts of the user are counted for or the phone of users without email
+func loginName(user *models.User) string {
+	if len(user.Email) > 0 {
+		return user.Email
+	}
+
+	return user.Phone
+}
+
+func loginFailuresKey(kind, value string) string {
+	return "login_failures:" + kind + ":" + value
+}
+
+func loginBlockedKey(kind, value string) string {
+	return "login_blocked:" + kind + ":" + value
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+const lockedEmail = "locked@mail.com"
+const failingEmail = "failing@mail.com"
+
+func TestEmailLoginLockout(t *testing.T) {
+	t.Run("returns TooManyRequests for locked email", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "Locked@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusTooManyRequests)
+
+		if len(recorder.Header().Get("Retry-After")) == 0 {
+			t.Error("expected Retry-After header")
+		}
+	})
+
+	t.Run("returns Unauthorized with invalid password after too many failures", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "failing@mail.com", "password": "invalid"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+}
+
+func TestMFALoginFailures(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failuresKey := loginFailuresKey("email", mfaTestUser.Email)
+
+	t.Run("doesn't reset failures before the second factor", func(t *testing.T) {
+		deletedCacheKeys = nil
+		body := bytes.NewBuffer([]byte(`{"email": "mfa@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if containsString(deletedCacheKeys, failuresKey) {
+			t.Errorf("expected failures to be kept, got deleted %v", deletedCacheKeys)
+		}
+	})
+
+	t.Run("counts wrong codes as failed logins", func(t *testing.T) {
+		challenge, _, err := auth.LinkToken(mfaTestUser.ID, mfaPurpose, "", time.Minute, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		incrementedCacheKeys = nil
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "recovery_code": "invalid"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+
+		if !containsString(incrementedCacheKeys, failuresKey) {
+			t.Errorf("expected failure to be counted, got %v", incrementedCacheKeys)
+		}
+	})
+
+	t.Run("resets failures after the second factor", func(t *testing.T) {
+		challenge, _, err := auth.LinkToken(mfaTestUser.ID, mfaPurpose, "", time.Minute, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := auth.TOTPCode(testTOTPSecret, time.Now().Unix()/30)
+
+		deletedCacheKeys = nil
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "code": "` + code + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !containsString(deletedCacheKeys, failuresKey) {
+			t.Errorf("expected failures to be reset, got deleted %v", deletedCacheKeys)
+		}
+	})
+}
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestLoginDelay(t *testing.T) {
+	cases := map[int64]time.Duration{
+		1:                     0,
+		loginBackoffStart:     loginBackoffBase,
+		loginBackoffStart + 2: 4 * loginBackoffBase,
+		loginLockoutThreshold: loginLockoutDuration,
+		1000:                  loginLockoutDuration,
+	}
+
+	for failures, expected := range cases {
+		if delay := loginDelay(failures); delay != expected {
+			t.Errorf("expected %v delay after %d failures, got %v", expected, failures, delay)
+		}
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	t.Run("returns MethodNotAllowed for non-DELETE requests", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/internal/users/unlock", UnlockUser, nil, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns UnprocessableEntity with blank email", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/users/unlock", UnlockUser, nil, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with email", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/users/unlock?email="+lockedEmail, UnlockUser, nil, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
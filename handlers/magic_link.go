@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxshend/tiny_goauth/auth"
+)
+
+const magicLinkPurpose = "magic_link"
+const magicLinkCookie = "magic_link_nonce"
+const magicLinkTTL = 15 * time.Minute
+const magicLinkRateLimit = 3
+const magicLinkRateWindow = 15 * time.Minute
+const defaultMagicLinkEndpoint = "/magic-link"
+
+const blankEmail = handlerErr("Blank Email")
+const blankToken = handlerErr("Blank Token")
+const tooManyRequests = handlerErr("Too many requests")
+
+type magicLinkParams struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+	Token  string `json:"token"`
+}
+
+// MagicLink sends a single-use login link to the user email
+func MagicLink(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params magicLinkParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		params.Email = auth.NormalizeEmail(params.Email)
+		if len(params.Email) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankEmail)
+			return
+		}
+
+		count, err := deps.DB.IncrCache("magic_link_rate:"+params.Email, magicLinkRateWindow)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if count > magicLinkRateLimit {
+			respondError(w, http.StatusTooManyRequests, tooManyRequests)
+			return
+		}
+
+		nonce := uuid.New().String()
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    nonce,
+			Path:     "/email/magic-link",
+			MaxAge:   int(magicLinkTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		user, err := deps.DB.UserByEmail(params.Email)
+		if err != nil {
+			// Respond the same way to not reveal whether the account exists
+			respond(w, http.StatusOK, nil)
+			return
+		}
+
+		token, id, err := auth.LinkToken(user.ID, magicLinkPurpose, auth.HashNonce(nonce), magicLinkTTL, deps.Keys.RefreshSign)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if err = deps.DB.StoreCache(magicLinkPurpose+":"+id, user.ID, magicLinkTTL); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		data := map[string]interface{}{"URL": magicLinkURL(token), "ExpiresIn": magicLinkTTL.String()}
+		if err = deps.Mailer.Deliver(user.Email, "magic_link", params.Locale, data); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, nil)
+	}))))
+}
+
+// ConsumeMagicLink exchanges a login link token for access and refresh tokens.
+// Users with second factors get the same MFA challenge as on password login.
+func ConsumeMagicLink(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params magicLinkParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if len(params.Token) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankToken)
+			return
+		}
+
+		cookie, err := r.Cookie(magicLinkCookie)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		claims, err := auth.ValidateLinkToken(params.Token, magicLinkPurpose, deps.Keys.RefreshVerify)
+		if err != nil || !auth.ValidateNonce(cookie.Value, claims.Nonce) {
+			respondInvalidToken(w)
+			return
+		}
+
+		del, err := deps.DB.DeleteCache(magicLinkPurpose + ":" + claims.Id)
+		if del == 0 {
+			respondInvalidToken(w)
+			return
+		}
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: magicLinkCookie, Path: "/email/magic-link", MaxAge: -1})
+
+		login, err := assessLogin(deps, w, r, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		// Access to the inbox replaces only the password, the second factor is still required
+		methods, err := mfaMethods(deps, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if len(methods) > 0 {
+			respondMFAChallenge(deps, w, r, user, methods)
+			return
+		}
+		recordLogin(deps, r, user, login)
+
+		userClaims, err := tokenClaims(deps, user, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		err = saveTokenDetails(deps, user.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.ID, "magic_link")
+		respond(w, http.StatusOK, token)
+	}))))
+}
+
+// magicLinkURL points to the page of the client application (APP_URL) which posts the token to ConsumeMagicLink
+func magicLinkURL(token string) string {
+	endpoint, found := os.LookupEnv("MAGIC_LINK_URL")
+	if !found || len(endpoint) == 0 {
+		endpoint = os.Getenv("APP_URL") + defaultMagicLinkEndpoint
+	}
+
+	return endpoint + "?token=" + url.QueryEscape(token)
+}
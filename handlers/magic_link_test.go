@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestMagicLink(t *testing.T) {
+	t.Run("returns MethodNotAllowed for non-POST requests", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/email/magic-link", MagicLink, nil, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns UnprocessableEntity with blank email", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": ""}`))
+		recorder := performRequest(t, "POST", "/email/magic-link", MagicLink, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns TooManyRequests when rate limit is exceeded", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "limited@mail.com"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link", MagicLink, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusTooManyRequests)
+	})
+
+	t.Run("returns OK and sets nonce cookie", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link", MagicLink, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if len(recorder.Result().Cookies()) != 1 {
+			t.Error("expected nonce cookie to be set")
+		}
+	})
+}
+
+func TestConsumeMagicLink(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := auth.LinkToken(1, magicLinkPurpose, auth.HashNonce("nonce"), time.Minute, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns Unauthorized without nonce cookie", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"token": "` + token + `"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link/consume", ConsumeMagicLink, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with another browser nonce", func(t *testing.T) {
+		h := map[string]string{contentTypeHeader: jsonContentType, "Cookie": magicLinkCookie + "=invalid"}
+		body := bytes.NewBuffer([]byte(`{"token": "` + token + `"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link/consume", ConsumeMagicLink, body, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with valid token and nonce", func(t *testing.T) {
+		h := map[string]string{contentTypeHeader: jsonContentType, "Cookie": magicLinkCookie + "=nonce"}
+		body := bytes.NewBuffer([]byte(`{"token": "` + token + `"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link/consume", ConsumeMagicLink, body, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns MFA challenge for enrolled user", func(t *testing.T) {
+		token, _, err := auth.LinkToken(mfaTestUser.ID, magicLinkPurpose, auth.HashNonce("nonce"), time.Minute, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := map[string]string{contentTypeHeader: jsonContentType, "Cookie": magicLinkCookie + "=nonce"}
+		body := bytes.NewBuffer([]byte(`{"token": "` + token + `"}`))
+		recorder := performRequest(t, "POST", "/email/magic-link/consume", ConsumeMagicLink, body, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), `"mfa_required":true`) {
+			t.Errorf("expected MFA challenge, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestMagicLinkURL(t *testing.T) {
+	defer os.Unsetenv("MAGIC_LINK_URL")
+	defer os.Unsetenv("APP_URL")
+	os.Setenv("APP_URL", "https://app.example.com")
+	os.Setenv("API_HOST", "https://users.example.com")
+
+	t.Run("points to the page of the application by default", func(t *testing.T) {
+		os.Unsetenv("MAGIC_LINK_URL")
+
+		if got := magicLinkURL("a+b"); got != "https://app.example.com/magic-link?token=a%2Bb" {
+			t.Errorf("got unexpected URL %q", got)
+		}
+	})
+
+	t.Run("uses the configured URL", func(t *testing.T) {
+		os.Setenv("MAGIC_LINK_URL", "https://example.com/login")
+
+		if got := magicLinkURL("a"); got != "https://example.com/login?token=a" {
+			t.Errorf("got unexpected URL %q", got)
+		}
+	})
+}
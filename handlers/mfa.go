@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const mfaPurpose = "mfa"
+const mfaChallengeTTL = 5 * time.Minute
+const mfaMaxAttempts = 5
+const mfaMethodEmail = "email"
+const mfaEmailCodeDigits = 6
+const totpUsedTTL = 2 * time.Minute
+const recoveryCodesCount = 10
+const defaultTOTPIssuer = "tiny_goauth"
+
+const totpAlreadyEnabled = handlerErr("TOTP is already enabled")
+const totpNotEnrolled = handlerErr("TOTP is not enrolled")
+const invalidMFACode = handlerErr("Invalid MFA code")
+
+type mfaParams struct {
+	Token        string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type mfaChallenge struct {
+	MFARequired bool     `json:"mfa_required"`
+	Token       string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode []byte `json:"qr_png"`
+}
+
+// EnrollTOTP generates a new pending TOTP secret for the current user
+func EnrollTOTP(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		if user.TOTPEnabled {
+			respondError(w, http.StatusUnprocessableEntity, totpAlreadyEnabled)
+			return
+		}
+
+		secret, err := auth.TOTPSecret()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if err = deps.DB.SetTOTPSecret(user.ID, secret); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		uri := auth.TOTPURI(totpIssuer(), user.DisplayName(), secret)
+		qr, err := auth.TOTPQRCode(uri)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, &totpEnrollment{Secret: secret, URI: uri, QRCode: qr})
+	})))))
+}
+
+// ConfirmTOTP enables TOTP after the user proves possession of the secret
+func ConfirmTOTP(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		var params mfaParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		if user.TOTPEnabled {
+			respondError(w, http.StatusUnprocessableEntity, totpAlreadyEnabled)
+			return
+		}
+		if len(user.TOTPSecret) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, totpNotEnrolled)
+			return
+		}
+
+		valid, err := useTOTPCode(deps, user, params.Code)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if !valid {
+			respondError(w, http.StatusUnprocessableEntity, invalidMFACode)
+			return
+		}
+
+		codes, err := auth.RecoveryCodes(recoveryCodesCount)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		hashes := make([]string, len(codes))
+		for i, code := range codes {
+			hashes[i] = auth.HashRecoveryCode(code)
+		}
+
+		if err = deps.DB.EnableTOTP(user.ID, hashes); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+	})))))
+}
+
+// DisableTOTP disables TOTP of the current user
+func DisableTOTP(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(deleteHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		if !user.TOTPEnabled {
+			respondError(w, http.StatusUnprocessableEntity, totpNotEnrolled)
+			return
+		}
+
+		valid, err := validateMFACode(deps, user, r.FormValue("code"), r.FormValue("recovery_code"))
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if !valid {
+			respondError(w, http.StatusUnprocessableEntity, invalidMFACode)
+			return
+		}
+
+		if err = deps.DB.DisableTOTP(user.ID); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, nil)
+	})))))
+}
+
+// EmailLoginMFA exchanges MFA challenge token and a code for access and refresh tokens
+func EmailLoginMFA(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params mfaParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		claims, err := auth.ValidateLinkToken(params.Token, mfaPurpose, deps.Keys.RefreshVerify)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		key := mfaPurpose + ":" + claims.Id
+		if _, err = deps.DB.GetCacheValue(key); err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		attempts, err := deps.DB.IncrCache(mfaPurpose+"_attempts:"+claims.Id, mfaChallengeTTL)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if attempts > mfaMaxAttempts {
+			deps.DB.DeleteCache(key)
+			respondInvalidToken(w)
+			return
+		}
+
+		user, err := deps.DB.UserByID(claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		// Wrong codes count as failed logins so that new challenges don't allow guessing codes further
+		name := loginName(user)
+		if wait := loginRetryAfter(deps, name, clientIP(r)); wait > 0 {
+			auditLoginFailure(deps, r, user.ID, "mfa", map[string]interface{}{"reason": "locked"})
+			respondLoginLocked(w, wait)
+			return
+		}
+
+		valid, err := validateMFACode(deps, user, params.Code, params.RecoveryCode)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if !valid && len(params.RecoveryCode) == 0 {
+			valid = validateMFAEmailCode(deps, claims.Id, params.Code)
+		}
+		if !valid {
+			auditLoginFailure(deps, r, user.ID, "mfa", nil)
+			registerLoginFailure(deps, r, name)
+			respondError(w, http.StatusUnauthorized, invalidMFACode)
+			return
+		}
+
+		del, err := deps.DB.DeleteCache(key)
+		if del == 0 {
+			respondInvalidToken(w)
+			return
+		}
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		resetLoginFailures(deps, r, name)
+
+		login, err := assessLogin(deps, w, r, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		recordLogin(deps, r, user, login)
+
+		userClaims, err := tokenClaims(deps, user, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		err = saveTokenDetails(deps, user.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.ID, "mfa")
+		respond(w, http.StatusOK, token)
+	}))))
+}
+
+func respondMFAChallenge(deps *Deps, w http.ResponseWriter, r *http.Request, user *models.User, methods []string) {
+	token, id, err := auth.LinkToken(user.ID, mfaPurpose, "", mfaChallengeTTL, deps.Keys.RefreshSign)
+	if err != nil {
+		deps.Logger.RequestError(r, err)
+		respondInternalError(w)
+		return
+	}
+
+	if err = deps.DB.StoreCache(mfaPurpose+":"+id, user.ID, mfaChallengeTTL); err != nil {
+		deps.Logger.RequestError(r, err)
+		respondInternalError(w)
+		return
+	}
+
+	if len(methods) == 1 && methods[0] == mfaMethodEmail {
+		if err = sendMFAEmailCode(deps, user, id); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+	}
+
+	respond(w, http.StatusOK, &mfaChallenge{MFARequired: true, Token: token, Methods: methods})
+}
+
+// sendMFAEmailCode sends a one-time code confirming the challenge to users without enrolled second factors
+func sendMFAEmailCode(deps *Deps, user *models.User, challengeID string) error {
+	code, err := auth.OTPCode(mfaEmailCodeDigits)
+	if err != nil {
+		return err
+	}
+
+	if err = deps.DB.StoreCache(mfaPurpose+"_email:"+challengeID, auth.HashNonce(code), mfaChallengeTTL); err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{"Code": code, "ExpiresIn": mfaChallengeTTL.String()}
+
+	return deps.Mailer.Deliver(user.Email, "login_code", "", data)
+}
+
+// validateMFAEmailCode checks the code sent by email for the challenge
+func validateMFAEmailCode(deps *Deps, challengeID, code string) bool {
+	hash, err := deps.DB.GetCacheValue(mfaPurpose + "_email:" + challengeID)
+	if err != nil || len(hash) == 0 || len(code) == 0 {
+		return false
+	}
+
+	return auth.ValidateNonce(code, hash)
+}
+
+// mfaMethods returns second factors enrolled by the user
+func mfaMethods(deps *Deps, user *models.User) ([]string, error) {
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, "totp")
+	}
+
+	credentials, err := deps.DB.WebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	return methods, nil
+}
+
+func validateMFACode(deps *Deps, user *models.User, code, recoveryCode string) (bool, error) {
+	if len(recoveryCode) > 0 {
+		return deps.DB.UseRecoveryCode(user.ID, auth.HashRecoveryCode(recoveryCode))
+	}
+
+	return validateTOTPCode(deps, user, code)
+}
+
+// validateTOTPCode checks the code of the confirmed TOTP enrollment,
+// users with pending enrollments or other second factors only can't pass it
+func validateTOTPCode(deps *Deps, user *models.User, code string) (bool, error) {
+	if !user.TOTPEnabled || len(user.TOTPSecret) == 0 {
+		return false, nil
+	}
+
+	return useTOTPCode(deps, user, code)
+}
+
+// useTOTPCode checks the code against the secret of the user whether the enrollment is confirmed or not
+func useTOTPCode(deps *Deps, user *models.User, code string) (bool, error) {
+	counter, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Every code can be used only once within its validity window
+	return deps.DB.StoreCacheNX(
+		"totp_used:"+fmt.Sprint(user.ID)+":"+fmt.Sprint(counter), true, totpUsedTTL,
+	)
+}
+
+func totpIssuer() string {
+	issuer, found := os.LookupEnv("TOTP_ISSUER")
+	if !found || len(issuer) == 0 {
+		return defaultTOTPIssuer
+	}
+
+	return issuer
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestEmailLoginWithMFA(t *testing.T) {
+	t.Run("returns MFA challenge for enrolled user", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "mfa@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), `"mfa_required":true`) {
+			t.Errorf("expected MFA challenge, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestEmailLoginMFA(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, _, err := auth.LinkToken(mfaTestUser.ID, mfaPurpose, "", time.Minute, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := auth.TOTPCode(testTOTPSecret, time.Now().Unix()/30)
+
+	t.Run("returns MethodNotAllowed for non-POST requests", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/email/login/mfa", EmailLoginMFA, nil, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns Unauthorized with invalid challenge token", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "invalid", "code": "` + code + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with invalid code", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "code": "000000"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with valid code", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "code": "` + code + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns OK with code sent by email", func(t *testing.T) {
+		challenge, _, err := auth.LinkToken(1, mfaPurpose, "", time.Minute, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "code": "` + testOTPCode + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns Unauthorized for user without confirmed TOTP", func(t *testing.T) {
+		challenge, _, err := auth.LinkToken(passkeyTestUser.ID, mfaPurpose, "", time.Minute, privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "code": "` + code + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with valid recovery code", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `", "recovery_code": "` + testRecoveryCode + `"}`))
+		recorder := performRequest(t, "POST", "/email/login/mfa", EmailLoginMFA, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns Unauthorized without token", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/mfa/totp/enroll", EnrollTOTP, nil, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns UnprocessableEntity when TOTP is already enabled", func(t *testing.T) {
+		h := authHeaders(t, privateKey, mfaTestUser.ID)
+		recorder := performRequest(t, "POST", "/mfa/totp/enroll", EnrollTOTP, nil, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with secret and QR code", func(t *testing.T) {
+		h := authHeaders(t, privateKey, 1)
+		recorder := performRequest(t, "POST", "/mfa/totp/enroll", EnrollTOTP, nil, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), "otpauth://totp/") {
+			t.Errorf("expected otpauth URI, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns UnprocessableEntity when TOTP isn't enrolled", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"code": "123456"}`))
+		recorder := performRequest(t, "POST", "/mfa/totp/confirm", ConfirmTOTP, body, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestDisableTOTP(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns UnprocessableEntity with invalid code", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/mfa/totp?code=000000", DisableTOTP, nil, authHeaders(t, privateKey, mfaTestUser.ID), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with valid recovery code", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/mfa/totp?recovery_code="+testRecoveryCode, DisableTOTP, nil, authHeaders(t, privateKey, mfaTestUser.ID), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
+
+func authHeaders(t *testing.T, key interface{}, userID int64) map[string]string {
+	t.Helper()
+
+	claims := jwt.MapClaims{"exp": time.Now().Add(time.Minute * 15).Unix(), "user_id": userID}
+
+	return map[string]string{
+		contentTypeHeader:   jsonContentType,
+		auhtorizationHeader: authtest.GenerateFakeJWT(t, key, jwt.SigningMethodRS256, claims),
+	}
+}
@@ -12,12 +12,18 @@ func authenticatedHandler(deps *Deps, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get(auhtorizationHeader)
 
-		claims, err := auth.ValidateToken(token, deps.Keys.AccessVerify)
+		c, err := auth.ValidateToken(token, deps.Keys.AccessVerify)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		claims, ok := c.(*auth.Claims)
+		if !ok || claims == nil || tokensRevoked(deps, claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), tokenClaimsKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -45,6 +51,17 @@ func deleteHandler(next http.Handler) http.Handler {
 	})
 }
 
+func getHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func postHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -59,7 +76,7 @@ func postHandler(next http.Handler) http.Handler {
 func logHandler(deps *Deps, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rec := httptest.NewRecorder()
-		next.ServeHTTP(rec, r)
+		rateLimitHandler(deps, next).ServeHTTP(rec, r)
 
 		for k, v := range rec.Header() {
 			w.Header()[k] = v
@@ -71,3 +88,39 @@ func logHandler(deps *Deps, next http.Handler) http.Handler {
 		deps.Logger.RequestDetails(r, rec.Code)
 	})
 }
+
+// rateLimitHandler rejects requests exceeding any rate limit policy of the route
+func rateLimitHandler(deps *Deps, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		var current *rateLimitResult
+
+		for _, policy := range deps.RateLimits[route] {
+			value := rateLimitValue(deps, r, policy.Key)
+			if len(value) == 0 {
+				continue
+			}
+
+			result, err := policy.take(deps, route, value)
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				continue
+			}
+
+			if current == nil || (!result.allowed && (current.allowed || result.reset > current.reset)) ||
+				(result.allowed && current.allowed && result.remaining < current.remaining) {
+				current = result
+			}
+		}
+
+		if current != nil {
+			setRateLimitHeaders(w, current)
+			if !current.allowed {
+				respondError(w, http.StatusTooManyRequests, tooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/oauth"
+)
+
+const oauthStateTTL = 10 * time.Minute
+const oauthStateCookie = "oauth_state"
+const defaultOAuthCallbackEndpoint = "/oauth/callback"
+
+const invalidOAuthState = handlerErr("Invalid OAuth state")
+const unverifiedEmail = handlerErr("Identity provider didn't return a verified email")
+const identityTaken = handlerErr("Identity is already linked to another user")
+const oauthEmailTaken = handlerErr("User with this email already exists, log in and link the identity to the account")
+
+type oauthState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	UserID   int64  `json:"user_id,omitempty"`
+}
+
+// OAuthAuthorize redirects the user to the upstream identity provider
+func OAuthAuthorize(deps *Deps) http.Handler {
+	return logHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider, err := deps.Providers.Get(r.FormValue("provider"))
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		location, err := startOAuth(deps, w, r, provider, 0)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		http.Redirect(w, r, location, http.StatusFound)
+	})))
+}
+
+// OAuthCallback handles the identity provider response and returns access and refresh tokens.
+// Users are created on the first login, existing users link identities through LinkIdentity.
+func OAuthCallback(deps *Deps) http.Handler {
+	return logHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The state must come back to the browser which started the authorization
+		cookie, err := r.Cookie(oauthStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.FormValue("state"))) != 1 {
+			respondError(w, http.StatusUnauthorized, invalidOAuthState)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: defaultOAuthCallbackEndpoint, MaxAge: -1})
+
+		state, err := loadOAuthState(deps, r.FormValue("state"))
+		if err != nil || len(r.FormValue("error")) > 0 {
+			respondError(w, http.StatusUnauthorized, invalidOAuthState)
+			return
+		}
+
+		provider, err := deps.Providers.Get(state.Provider)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		tokens, err := provider.Exchange(r.FormValue("code"), oauthRedirectURL(provider))
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		identity, err := provider.Identity(tokens, state.Nonce)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		identity.Email = auth.NormalizeEmail(identity.Email)
+
+		if state.UserID != 0 {
+			linked, err := linkIdentity(deps, state.UserID, identity)
+			if err != nil {
+				respondError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+
+			respond(w, http.StatusOK, linked)
+			return
+		}
+
+		var user *models.User
+
+		linked, err := deps.DB.IdentityBySubject(identity.Provider, identity.Subject)
+		if err == nil {
+			user, err = deps.DB.UserByID(linked.UserID)
+			if err != nil {
+				respondInvalidToken(w)
+				return
+			}
+		} else {
+			if len(identity.Email) == 0 || !identity.EmailVerified {
+				respondError(w, http.StatusUnauthorized, unverifiedEmail)
+				return
+			}
+
+			// Emails of local accounts aren't verified, so their owners link identities themselves when logged in
+			if _, err = deps.DB.UserByEmail(identity.Email); err == nil {
+				auditLoginFailure(deps, r, 0, "oauth", map[string]interface{}{"email": identity.Email, "reason": "email_taken"})
+				respondError(w, http.StatusConflict, oauthEmailTaken)
+				return
+			}
+
+			user = &models.User{Email: identity.Email, Roles: deps.RolePolicy.Default}
+
+			responseBody, err := registerUser(deps, r, user)
+			if err != nil {
+				if responseBody != nil {
+					respondExternal(w, http.StatusUnprocessableEntity, responseBody)
+					return
+				}
+
+				respondInternalError(w)
+				return
+			}
+
+			if _, err = linkIdentity(deps, user.ID, identity); err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+		}
+
+		methods, err := mfaMethods(deps, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if len(methods) > 0 {
+			respondMFAChallenge(deps, w, r, user, methods)
+			return
+		}
+
+		userClaims, err := tokenClaims(deps, user, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		err = saveTokenDetails(deps, user.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.ID, "oauth")
+		respond(w, http.StatusOK, token)
+	})))
+}
+
+// startOAuth stores authorization request state and returns the provider authorization URL.
+// The state is also set in a cookie binding the authorization to the browser.
+// Non-zero user ID means the identity will be linked to the user instead of logging in.
+func startOAuth(deps *Deps, w http.ResponseWriter, r *http.Request, provider *oauth.Provider, userID int64) (string, error) {
+	state := uuid.New().String()
+	nonce := uuid.New().String()
+
+	payload, err := json.Marshal(&oauthState{Provider: provider.Name, Nonce: nonce, UserID: userID})
+	if err != nil {
+		return "", err
+	}
+
+	if err = deps.DB.StoreCache("oauth_state:"+state, payload, oauthStateTTL); err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     defaultOAuthCallbackEndpoint,
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return provider.AuthCodeURL(state, nonce, oauthRedirectURL(provider)), nil
+}
+
+// linkIdentity links the external identity to the user unless it's linked to another user
+func linkIdentity(deps *Deps, userID int64, identity *oauth.Identity) (*models.Identity, error) {
+	existing, err := deps.DB.IdentityBySubject(identity.Provider, identity.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, identityTaken
+		}
+
+		return existing, nil
+	}
+
+	linked := &models.Identity{
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Metadata: map[string]interface{}{"email": identity.Email, "name": identity.Name},
+	}
+	if err = deps.DB.CreateIdentity(linked); err != nil {
+		return nil, err
+	}
+
+	return linked, nil
+}
+
+// loadOAuthState returns the authorization request state and removes it so it can't be used twice
+func loadOAuthState(deps *Deps, id string) (*oauthState, error) {
+	if len(id) == 0 {
+		return nil, invalidOAuthState
+	}
+
+	payload, err := deps.DB.GetCacheValue("oauth_state:" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	if del, err := deps.DB.DeleteCache("oauth_state:" + id); del == 0 || err != nil {
+		return nil, invalidOAuthState
+	}
+
+	var state oauthState
+	if err = json.Unmarshal([]byte(payload), &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func oauthRedirectURL(provider *oauth.Provider) string {
+	if len(provider.RedirectURL) > 0 {
+		return provider.RedirectURL
+	}
+
+	return os.Getenv("APP_HOST") + defaultOAuthCallbackEndpoint
+}
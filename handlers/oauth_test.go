@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/oauth"
+)
+
+const newOAuthEmail = "new.oauth@mail.com"
+
+func TestOAuthAuthorize(t *testing.T) {
+	idp := authtest.NewFakeIdP(t, "client")
+	defer idp.Close()
+	setTestProvider(t, idp)
+
+	t.Run("returns MethodNotAllowed for non-GET requests", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/oauth/authorize?provider=test", OAuthAuthorize, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns UnprocessableEntity for unknown provider", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/oauth/authorize?provider=unknown", OAuthAuthorize, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("redirects to the provider", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/oauth/authorize?provider=test", OAuthAuthorize, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusFound)
+
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		if err != nil || !strings.HasPrefix(location.String(), idp.URL+"/authorize?") {
+			t.Fatalf("got unexpected location %q", recorder.Header().Get("Location"))
+		}
+
+		cookies := recorder.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != oauthStateCookie || cookies[0].Value != location.Query().Get("state") || cookies[0].SameSite != http.SameSiteLaxMode {
+			t.Errorf("expected state cookie, got %v", cookies)
+		}
+	})
+}
+
+func TestOAuthCallback(t *testing.T) {
+	idp := authtest.NewFakeIdP(t, "client")
+	defer idp.Close()
+	setTestProvider(t, idp)
+
+	idp.Claims["sub"] = "123"
+	idp.Claims["email"] = "test@mail.com"
+	idp.Claims["nonce"] = "nonce"
+
+	t.Run("returns Unauthorized with invalid state", func(t *testing.T) {
+		h := oauthStateHeaders("invalid")
+		recorder := performRequest(t, "GET", "/oauth/callback?state=invalid&code=valid", OAuthCallback, nil, h, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized without state cookie", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with state cookie of another authorization", func(t *testing.T) {
+		h := oauthStateHeaders(testOAuthLinkState)
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, h, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with invalid code", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=invalid", OAuthCallback, nil, oauthStateHeaders(testOAuthState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with unverified email", func(t *testing.T) {
+		idp.Claims["email_verified"] = false
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Conflict for existing user without linked identity", func(t *testing.T) {
+		idp.Claims["email_verified"] = true
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusConflict)
+
+		if !strings.Contains(recorder.Body.String(), oauthEmailTaken.Error()) || strings.Contains(recorder.Body.String(), "access_token") {
+			t.Errorf("expected %q error, got %q", oauthEmailTaken, recorder.Body.String())
+		}
+	})
+
+	t.Run("returns OK for new user with verified email", func(t *testing.T) {
+		externalApp := testServer()
+		defer externalApp.Close()
+		os.Setenv("API_HOST", externalApp.URL)
+
+		idp.Claims["email"] = newOAuthEmail
+		defer func() { idp.Claims["email"] = "test@mail.com" }()
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns OK for linked identity", func(t *testing.T) {
+		idp.Claims["sub"] = "linked"
+		defer func() { idp.Claims["sub"] = "123" }()
+		recorder := performRequest(t, "GET", "/oauth/callback?state="+testOAuthState+"&code=valid", OAuthCallback, nil, oauthStateHeaders(testOAuthState), nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
+
+func oauthStateHeaders(state string) map[string]string {
+	return map[string]string{"Cookie": oauthStateCookie + "=" + state}
+}
+
+func setTestProvider(t *testing.T, idp *authtest.FakeIdP) {
+	t.Helper()
+
+	provider := &oauth.Provider{Name: "test", ClientID: idp.ClientID, Issuer: idp.URL, Scopes: []string{"openid", "email"}}
+	if err := provider.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testProviders["test"] = provider
+}
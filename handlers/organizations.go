@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// tenantHeader selects the organization whose roles tokens are issued with on login and refresh
+const tenantHeader = "X-Tenant"
+
+const defaultMembersPageLimit = 50
+const maxMembersPageLimit = 200
+
+const (
+	invalidOrganizationSlug = handlerErr("Invalid Organization")
+	blankOrganizationName   = handlerErr("Blank Organization Name")
+	blankOrganization       = handlerErr("Blank Organization")
+	notTenantMember         = handlerErr("User isn't a member of the tenant")
+	foreignOrganization     = handlerErr("Access token can't manage another organization")
+	tenantOutOfScope        = handlerErr("Access token of a tenant can manage only members of its organization")
+	foreignMember           = handlerErr("Access token of a tenant can change only existing members of its organization")
+)
+
+var organizationSlugFormat = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,99}$`)
+
+// tenantInternalPaths are internal API paths admin tokens of a tenant may call for their own organization
+var tenantInternalPaths = map[string]bool{
+	"/internal/organizations/members":        true,
+	"/internal/organizations/members/list":   true,
+	"/internal/organizations/members/delete": true,
+}
+
+type membershipParams struct {
+	Organization string   `json:"organization"`
+	UserID       int64    `json:"user_id"`
+	Roles        []string `json:"roles"`
+}
+
+type membersPage struct {
+	Members    []models.Membership `json:"members"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// CreateOrganization creates an organization
+func CreateOrganization(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var organization models.Organization
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&organization)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if !organizationSlugFormat.MatchString(organization.Slug) {
+			respondError(w, http.StatusUnprocessableEntity, invalidOrganizationSlug)
+			return
+		}
+		if organization.Name = strings.TrimSpace(organization.Name); len(organization.Name) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankOrganizationName)
+			return
+		}
+
+		if err = deps.DB.CreateOrganization(&organization); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditOrganizationCreate, Outcome: auditSuccess, Metadata: map[string]interface{}{"organization": organization.Slug}})
+		respond(w, http.StatusOK, organization)
+	})))))
+}
+
+// ListOrganizations returns all organizations
+func ListOrganizations(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		organizations, err := deps.DB.Organizations()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if organizations == nil {
+			organizations = []models.Organization{}
+		}
+
+		respond(w, http.StatusOK, map[string][]models.Organization{"organizations": organizations})
+	}))))
+}
+
+// DeleteOrganization removes the organization with all memberships in it
+func DeleteOrganization(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.FormValue("organization")
+		if len(slug) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankOrganization)
+			return
+		}
+
+		if err := deps.DB.DeleteOrganization(slug); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditOrganizationDelete, Outcome: auditSuccess, Metadata: map[string]interface{}{"organization": slug}})
+	}))))
+}
+
+// SetMember adds the user to the organization or replaces roles of the member.
+// Admin tokens of a tenant can only replace roles of existing members.
+// New roles take effect once tokens of the tenant are refreshed.
+func SetMember(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params membershipParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		slug, err := requestOrganization(r, params.Organization)
+		if err != nil {
+			respondError(w, organizationErrorStatus(err), err.Error())
+			return
+		}
+		if params.UserID <= 0 {
+			respondError(w, http.StatusUnprocessableEntity, invalidUserID)
+			return
+		}
+
+		// Tenant admins can't enroll users of other organizations, unknown users are reported the same way
+		previous, err := deps.DB.Membership(slug, params.UserID)
+		if claims, ok := claimsFromContext(r); ok && len(claims.Tenant) > 0 && err != nil {
+			respondError(w, http.StatusForbidden, foreignMember)
+			return
+		}
+
+		roles := make([]string, 0, len(params.Roles))
+		for _, role := range params.Roles {
+			if len(role) == 0 {
+				respondError(w, http.StatusUnprocessableEntity, blankRole)
+				return
+			}
+			if !contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+
+		if err = deps.DB.SetMembership(slug, params.UserID, roles); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		// Tokens carrying the roles the member loses stop working
+		if previous != nil && membershipDowngraded(previous, roles) {
+			if err = revokeTokens(deps, params.UserID); err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+		}
+
+		audit(deps, r, &models.AuditEvent{
+			SubjectID: params.UserID,
+			Action:    auditMemberSet,
+			Outcome:   auditSuccess,
+			Metadata:  map[string]interface{}{"organization": slug, "roles": roles},
+		})
+	})))))
+}
+
+// ListMembers returns members of the organization with their roles, ordered by user ID
+func ListMembers(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug, err := requestOrganization(r, r.FormValue("organization"))
+		if err != nil {
+			respondError(w, organizationErrorStatus(err), err.Error())
+			return
+		}
+
+		after, limit, err := pageParams(r, defaultMembersPageLimit, maxMembersPageLimit)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		// One more member is requested to know whether there is a next page
+		members, err := deps.DB.Members(slug, after, limit+1)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		page := &membersPage{Members: members}
+		if page.Members == nil {
+			page.Members = []models.Membership{}
+		}
+		if len(members) > limit {
+			page.Members = members[:limit]
+			page.NextCursor = strconv.FormatInt(page.Members[limit-1].UserID, 10)
+		}
+
+		respond(w, http.StatusOK, page)
+	}))))
+}
+
+// RemoveMember removes the user with their roles from the organization
+func RemoveMember(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug, err := requestOrganization(r, r.FormValue("organization"))
+		if err != nil {
+			respondError(w, organizationErrorStatus(err), err.Error())
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidUserID)
+			return
+		}
+
+		if err = deps.DB.RemoveMember(slug, userID); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if err = revokeTokens(deps, userID); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{
+			SubjectID: userID,
+			Action:    auditMemberRemove,
+			Outcome:   auditSuccess,
+			Metadata:  map[string]interface{}{"organization": slug},
+		})
+	}))))
+}
+
+// membershipDowngraded reports whether the member loses any of the previous roles
+func membershipDowngraded(previous *models.Membership, roles []string) bool {
+	for _, role := range previous.Roles {
+		if !contains(roles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestOrganization returns the organization the request manages.
+// Admin tokens of a tenant manage only their organization which is used when none is given.
+func requestOrganization(r *http.Request, slug string) (string, error) {
+	if claims, ok := claimsFromContext(r); ok && len(claims.Tenant) > 0 {
+		if len(slug) > 0 && slug != claims.Tenant {
+			return "", foreignOrganization
+		}
+
+		return claims.Tenant, nil
+	}
+
+	if len(slug) == 0 {
+		return "", blankOrganization
+	}
+
+	return slug, nil
+}
+
+func organizationErrorStatus(err error) int {
+	if err == foreignOrganization {
+		return http.StatusForbidden
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// refreshTenant returns the tenant selected by the refresh request or the tenant of the refresh token
+func refreshTenant(r *http.Request, claims *auth.Claims) string {
+	if tenant := r.Header.Get(tenantHeader); len(tenant) > 0 {
+		return tenant
+	}
+
+	return claims.Tenant
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// testOrganizations are ordered by slug, only acme has members
+var testOrganizations = []models.Organization{
+	{ID: 1, Slug: "acme", Name: "Acme"},
+	{ID: 2, Slug: "globex", Name: "Globex"},
+}
+
+// testMemberships are ordered by user ID, the test user is an admin of acme
+var testMemberships = []models.Membership{
+	{Organization: "acme", UserID: 1, Roles: []string{"admin"}, EffectiveRoles: []string{"admin"}, Permissions: []string{"docs:edit", "policy:explain"}},
+	{Organization: "acme", UserID: 2, Roles: []string{"staff"}, EffectiveRoles: []string{"staff"}},
+	{Organization: "acme", UserID: 4, Roles: []string{"viewer"}, EffectiveRoles: []string{"viewer"}},
+}
+
+func testOrganizationExists(slug string) bool {
+	for _, o := range testOrganizations {
+		if o.Slug == slug {
+			return true
+		}
+	}
+
+	return false
+}
+
+func accessClaims(t *testing.T, body []byte, key *rsa.PrivateKey) *auth.Claims {
+	t.Helper()
+
+	var token auth.TokenDetails
+	if err := json.Unmarshal(body, &token); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := auth.ValidateToken(token.Access, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(*auth.Claims)
+}
+
+func TestTenantLogin(t *testing.T) {
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns tokens with roles of the tenant", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		headers := map[string]string{contentTypeHeader: jsonContentType, tenantHeader: "acme"}
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		claims := accessClaims(t, recorder.Body.Bytes(), key)
+		if claims.Tenant != "acme" || !reflect.DeepEqual(claims.Roles, []string{"admin"}) {
+			t.Errorf("got unexpected claims %+v", claims)
+		}
+	})
+
+	t.Run("returns tokens with global roles without the tenant", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		claims := accessClaims(t, recorder.Body.Bytes(), key)
+		if len(claims.Tenant) > 0 || !reflect.DeepEqual(claims.Roles, []string{"editor", "viewer"}) {
+			t.Errorf("got unexpected claims %+v", claims)
+		}
+	})
+
+	t.Run("returns Forbidden for tenant of another organization", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		headers := map[string]string{contentTypeHeader: jsonContentType, tenantHeader: "globex"}
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+	})
+}
+
+func TestTenantRefresh(t *testing.T) {
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := authtest.GenerateFakeJWT(t, key, jwt.SigningMethodRS256, jwt.MapClaims{
+		"user_id": 1, "tenant": "acme", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+
+	t.Run("keeps the tenant of the refresh token", func(t *testing.T) {
+		headers := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: refresh}
+		recorder := performRequest(t, "POST", "/refresh", Refresh, nil, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		// Refresh responds with the marshaled token details encoded once more
+		var body []byte
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if claims := accessClaims(t, body, key); claims.Tenant != "acme" {
+			t.Errorf("got unexpected claims %+v", claims)
+		}
+	})
+
+	t.Run("returns Forbidden when switching to tenant of another organization", func(t *testing.T) {
+		headers := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: refresh, tenantHeader: "globex"}
+		recorder := performRequest(t, "POST", "/refresh", Refresh, nil, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+	})
+}
+
+func TestCreateOrganization(t *testing.T) {
+	path := "/internal/organizations"
+
+	t.Run("returns the organization", func(t *testing.T) {
+		body := []byte(`{"slug": "initech", "name": " Initech "}`)
+		recorder := performRequest(t, "POST", path, CreateOrganization, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var organization models.Organization
+		if err := json.Unmarshal(recorder.Body.Bytes(), &organization); err != nil {
+			t.Fatal(err)
+		}
+		if organization.ID == 0 || organization.Name != "Initech" {
+			t.Errorf("got unexpected organization %+v", organization)
+		}
+		if e := lastAuditEvent(t); e.Action != auditOrganizationCreate || e.Metadata["organization"] != "initech" {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  string
+	}{
+		"invalid slug": {`{"slug": "Acme Inc", "name": "Acme"}`, invalidOrganizationSlug.Error()},
+		"blank name":   {`{"slug": "initech", "name": " "}`, blankOrganizationName.Error()},
+		"taken slug":   {`{"slug": "acme", "name": "Acme"}`, "duplicate"},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, CreateOrganization, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestListOrganizations(t *testing.T) {
+	recorder := performRequest(t, "GET", "/internal/organizations/list", ListOrganizations, nil, internalHeaders, nil)
+
+	authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+	if !strings.Contains(recorder.Body.String(), `"slug":"globex"`) {
+		t.Errorf("got unexpected body %s", recorder.Body.String())
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	t.Run("returns OK", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/organizations/delete?organization=globex", DeleteOrganization, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns UnprocessableEntity for unknown organization", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/organizations/delete?organization=initech", DeleteOrganization, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestSetMember(t *testing.T) {
+	path := "/internal/organizations/members"
+
+	t.Run("returns OK and audits unique roles", func(t *testing.T) {
+		body := []byte(`{"organization": "globex", "user_id": 4, "roles": ["staff", "staff"]}`)
+		recorder := performRequest(t, "POST", path, SetMember, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		e := lastAuditEvent(t)
+		if roles, ok := e.Metadata["roles"].([]string); e.Action != auditMemberSet || e.SubjectID != 4 || !ok || len(roles) != 1 {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("revokes tokens of a downgraded member", func(t *testing.T) {
+		storedCacheKeys = nil
+		body := []byte(`{"organization": "acme", "user_id": 1, "roles": ["staff"]}`)
+		recorder := performRequest(t, "POST", path, SetMember, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !containsString(storedCacheKeys, "tokens_revoked:1") {
+			t.Errorf("expected tokens to be revoked, got stored %v", storedCacheKeys)
+		}
+	})
+
+	t.Run("keeps tokens of a member keeping the roles", func(t *testing.T) {
+		storedCacheKeys = nil
+		body := []byte(`{"organization": "acme", "user_id": 2, "roles": ["staff", "viewer"]}`)
+		recorder := performRequest(t, "POST", path, SetMember, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if containsString(storedCacheKeys, "tokens_revoked:2") {
+			t.Errorf("expected tokens to be kept, got stored %v", storedCacheKeys)
+		}
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  string
+	}{
+		"blank organization":   {`{"user_id": 1, "roles": ["staff"]}`, blankOrganization.Error()},
+		"unknown organization": {`{"organization": "initech", "user_id": 1}`, "Unknown organization"},
+		"invalid user ID":      {`{"organization": "acme", "roles": ["staff"]}`, invalidUserID.Error()},
+		"unknown user":         {`{"organization": "acme", "user_id": 100}`, "unknown users"},
+		"unknown role":         {`{"organization": "acme", "user_id": 1, "roles": ["guest"]}`, "unknown role"},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, SetMember, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestListMembers(t *testing.T) {
+	t.Run("returns the first page with the next cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/organizations/members/list?organization=acme&limit=2", ListMembers, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var page membersPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Members) != 2 || page.Members[1].UserID != 2 || page.NextCursor != "2" {
+			t.Errorf("got unexpected page %+v", page)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity for unknown organization", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/organizations/members/list?organization=initech", ListMembers, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestRemoveMember(t *testing.T) {
+	t.Run("returns OK and revokes tokens", func(t *testing.T) {
+		storedCacheKeys = nil
+		recorder := performRequest(t, "DELETE", "/internal/organizations/members/delete?organization=acme&user_id=2", RemoveMember, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditMemberRemove || e.SubjectID != 2 {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+		if !containsString(storedCacheKeys, "tokens_revoked:2") {
+			t.Errorf("expected tokens to be revoked, got stored %v", storedCacheKeys)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity for non-member", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/organizations/members/delete?organization=globex&user_id=2", RemoveMember, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestTenantAdminToken(t *testing.T) {
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &auth.RSAKeys{AccessSign: key, AccessVerify: &key.PublicKey, RefreshSign: key, RefreshVerify: &key.PublicKey}
+
+	token, err := auth.Token(auth.Claims{UserID: 1, Roles: []string{defaultAdminRole}, Tenant: "acme"}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{auhtorizationHeader: token.Access}
+
+	t.Run("returns members of the organization of the tenant", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/organizations/members/list", ListMembers, nil, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns Forbidden for another organization", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/organizations/members/list?organization=globex", ListMembers, nil, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+	})
+
+	t.Run("returns OK when changing roles of a member", func(t *testing.T) {
+		body := strings.NewReader(`{"user_id": 2, "roles": ["viewer"]}`)
+		h := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: token.Access}
+		recorder := performRequest(t, "POST", "/internal/organizations/members", SetMember, body, h, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	for name, userID := range map[string]string{"non-member": "6", "unknown user": "100"} {
+		t.Run("returns Forbidden when adding "+name, func(t *testing.T) {
+			body := strings.NewReader(`{"user_id": ` + userID + `, "roles": ["viewer"]}`)
+			h := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: token.Access}
+			recorder := performRequest(t, "POST", "/internal/organizations/members", SetMember, body, h, key)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+
+			if !strings.Contains(recorder.Body.String(), foreignMember.Error()) {
+				t.Errorf("expected %q error, got %q", foreignMember, recorder.Body.String())
+			}
+		})
+	}
+
+	t.Run("returns Unauthorized for endpoints outside of the organization", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/organizations/list", ListOrganizations, nil, headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+}
+
+func TestOrganizationRoles(t *testing.T) {
+	t.Run("returns roles of the user in the organization", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/users/roles?id=1&organization=acme", UserRoles, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		want := `{"user_id":1,"roles":["admin"],"effective_roles":["admin"],"permissions":["docs:edit","policy:explain"]}`
+		if body := recorder.Body.String(); body != want {
+			t.Errorf("expected %s, got %s", want, body)
+		}
+	})
+
+	t.Run("authorizes by permissions in the organization", func(t *testing.T) {
+		cases := map[string]string{"acme": `{"allowed":true}`, "globex": `{"allowed":false}`}
+		for organization, want := range cases {
+			path := "/internal/users/authorize?user_id=1&permission=docs:edit&organization=" + organization
+			recorder := performRequest(t, "GET", path, Authorize, nil, internalHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if body := recorder.Body.String(); body != want {
+				t.Errorf("expected %s for %s, got %s", want, organization, body)
+			}
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// Access tokens have roles, flattened permissions of the roles or both of them
+const (
+	tokenClaimsRoles       = "roles"
+	tokenClaimsPermissions = "permissions"
+	tokenClaimsBoth        = "both"
+)
+
+const (
+	invalidPermissionName = handlerErr("Invalid Permission Name")
+	blankPermission       = handlerErr("Blank Permission")
+)
+
+var permissionNameFormat = regexp.MustCompile(`^[A-Za-z0-9_.:/-]{1,100}$`)
+
+type rolePermissionsParams struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// SavePermission creates a permission or updates its description
+func SavePermission(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var permission models.Permission
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&permission)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if !permissionNameFormat.MatchString(permission.Name) {
+			respondError(w, http.StatusUnprocessableEntity, invalidPermissionName)
+			return
+		}
+
+		if err = deps.DB.SavePermission(&permission); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditPermissionSaved, Outcome: auditSuccess, Metadata: map[string]interface{}{"permission": permission.Name}})
+		respond(w, http.StatusOK, permission)
+	})))))
+}
+
+// ListPermissions returns all permissions
+func ListPermissions(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := deps.DB.Permissions()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if permissions == nil {
+			permissions = []models.Permission{}
+		}
+
+		respond(w, http.StatusOK, map[string][]models.Permission{"permissions": permissions})
+	}))))
+}
+
+// DeletePermission removes the permission and revokes it from all roles
+func DeletePermission(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+		if len(name) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankPermission)
+			return
+		}
+
+		if err := deps.DB.DeletePermission(name); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{Action: auditPermissionDeleted, Outcome: auditSuccess, Metadata: map[string]interface{}{"permission": name}})
+	}))))
+}
+
+// SetRolePermissions replaces permissions granted to the role
+func SetRolePermissions(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params rolePermissionsParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if len(params.Role) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankRole)
+			return
+		}
+
+		permissions := make([]string, 0, len(params.Permissions))
+		seen := make(map[string]bool)
+		for _, name := range params.Permissions {
+			if !permissionNameFormat.MatchString(name) {
+				respondError(w, http.StatusUnprocessableEntity, invalidPermissionName)
+				return
+			}
+			if !seen[name] {
+				seen[name] = true
+				permissions = append(permissions, name)
+			}
+		}
+
+		if err = deps.DB.SetRolePermissions(params.Role, permissions); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{
+			Action:   auditRolePermissions,
+			Outcome:  auditSuccess,
+			Metadata: map[string]interface{}{"role": params.Role, "permissions": permissions},
+		})
+	})))))
+}
+
+// RolePermissions returns permissions granted to the role
+func RolePermissions(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := deps.DB.RolePermissions(r.FormValue("role"))
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if permissions == nil {
+			permissions = []string{}
+		}
+
+		respond(w, http.StatusOK, map[string][]string{"permissions": permissions})
+	}))))
+}
+
+// Authorize reports whether effective roles of the user grant the permission.
+// Only roles of the user in the organization are checked when it's specified.
+func Authorize(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidUserID)
+			return
+		}
+
+		permission := r.FormValue("permission")
+		if len(permission) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankPermission)
+			return
+		}
+
+		var permissions []string
+		if slug := r.FormValue("organization"); len(slug) > 0 {
+			membership, err := deps.DB.Membership(slug, userID)
+			if err != nil {
+				respond(w, http.StatusOK, map[string]bool{"allowed": false})
+				return
+			}
+
+			permissions = membership.Permissions
+		} else {
+			user, err := deps.DB.UserByID(userID)
+			if err != nil {
+				respondError(w, http.StatusNotFound, invalidUserID)
+				return
+			}
+
+			permissions = user.Permissions
+		}
+
+		respond(w, http.StatusOK, map[string]bool{"allowed": contains(permissions, permission)})
+	}))))
+}
+
+// tokenClaims returns claims of access tokens of the user according to TOKEN_CLAIMS.
+// Tokens have effective roles of the user including inherited ones or, when the tenant is selected,
+// only effective roles of the user in the organization.
+func tokenClaims(deps *Deps, user *models.User, tenant string) (auth.Claims, error) {
+	claims := auth.Claims{UserID: user.ID, Tenant: tenant}
+
+	roles, permissions := user.EffectiveRoles, user.Permissions
+	if len(tenant) > 0 {
+		membership, err := deps.DB.Membership(tenant, user.ID)
+		if err != nil {
+			return claims, notTenantMember
+		}
+
+		roles, permissions = membership.EffectiveRoles, membership.Permissions
+	}
+
+	switch os.Getenv("TOKEN_CLAIMS") {
+	case tokenClaimsPermissions:
+		claims.Permissions = permissions
+	case tokenClaimsBoth:
+		claims.Roles, claims.Permissions = roles, permissions
+	default:
+		claims.Roles = roles
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const testPermission = "users:read"
+
+func TestSavePermission(t *testing.T) {
+	path := "/internal/permissions"
+
+	t.Run("returns OK", func(t *testing.T) {
+		body := []byte(`{"name": "users:read", "description": "Read user profiles"}`)
+		recorder := performRequest(t, "POST", path, SavePermission, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditPermissionSaved || e.Metadata["permission"] != testPermission {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity with invalid name", func(t *testing.T) {
+		body := []byte(`{"name": "read users"}`)
+		recorder := performRequest(t, "POST", path, SavePermission, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestListPermissions(t *testing.T) {
+	recorder := performRequest(t, "GET", "/internal/permissions/list", ListPermissions, nil, internalHeaders, nil)
+
+	authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+	var response map[string][]models.Permission
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response["permissions"]) != 1 || response["permissions"][0].Name != testPermission {
+		t.Errorf("got unexpected permissions %v", response)
+	}
+}
+
+func TestDeletePermission(t *testing.T) {
+	t.Run("returns OK", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/permissions/delete?name="+testPermission, DeletePermission, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns UnprocessableEntity without name", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/permissions/delete", DeletePermission, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestSetRolePermissions(t *testing.T) {
+	path := "/internal/roles/permissions"
+
+	t.Run("returns OK and removes duplicates", func(t *testing.T) {
+		body := []byte(`{"role": "staff", "permissions": ["users:read", "users:read"]}`)
+		recorder := performRequest(t, "POST", path, SetRolePermissions, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditRolePermissions || !reflect.DeepEqual(e.Metadata["permissions"], []string{testPermission}) {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	invalid := map[string]string{
+		"blank role":         `{"permissions": ["users:read"]}`,
+		"invalid permission": `{"role": "staff", "permissions": ["users,read"]}`,
+		"unknown permission": `{"role": "staff", "permissions": ["users:write"]}`,
+		"unknown role":       `{"role": "guest", "permissions": ["users:read"]}`,
+	}
+	for name, body := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, SetRolePermissions, strings.NewReader(body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+		})
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	t.Run("returns permissions of role", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/roles/permissions/list?role=staff", RolePermissions, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if body := recorder.Body.String(); body != `{"permissions":["users:read"]}` {
+			t.Errorf("got unexpected body %q", body)
+		}
+	})
+
+	t.Run("returns UnprocessableEntity for unknown role", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/roles/permissions/list?role=guest", RolePermissions, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		code  int
+		body  string
+	}{
+		{"allows granted permission", "user_id=1&permission=users:read", http.StatusOK, `{"allowed":true}`},
+		{"denies other permissions", "user_id=1&permission=users:delete", http.StatusOK, `{"allowed":false}`},
+		{"returns NotFound for unknown user", "user_id=100&permission=users:read", http.StatusNotFound, ""},
+		{"returns UnprocessableEntity without permission", "user_id=1", http.StatusUnprocessableEntity, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := performRequest(t, "GET", "/internal/users/authorize?"+tc.query, Authorize, nil, internalHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, tc.code)
+
+			if len(tc.body) > 0 && recorder.Body.String() != tc.body {
+				t.Errorf("expected %q, got %q", tc.body, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenClaims(t *testing.T) {
+	defer os.Unsetenv("TOKEN_CLAIMS")
+	user := &models.User{ID: 1, Roles: []string{"editor"}, EffectiveRoles: []string{"editor", "viewer"}, Permissions: []string{testPermission}}
+
+	testCases := []struct {
+		mode        string
+		roles       []string
+		permissions []string
+	}{
+		{"", user.EffectiveRoles, nil},
+		{tokenClaimsPermissions, nil, user.Permissions},
+		{tokenClaimsBoth, user.EffectiveRoles, user.Permissions},
+	}
+
+	for _, tc := range testCases {
+		t.Run("with "+tc.mode+" claims", func(t *testing.T) {
+			os.Setenv("TOKEN_CLAIMS", tc.mode)
+
+			claims, err := tokenClaims(&Deps{}, user, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.UserID != user.ID || !reflect.DeepEqual(claims.Roles, tc.roles) || !reflect.DeepEqual(claims.Permissions, tc.permissions) {
+				t.Errorf("got unexpected claims %+v", claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const phoneOTPDigits = 6
+const phoneOTPTTL = 5 * time.Minute
+const phoneOTPMaxAttempts = 5
+const phoneOTPRateLimit = 3
+const phoneOTPRateWindow = 15 * time.Minute
+
+const invalidPhone = handlerErr("Invalid Phone")
+const invalidOTPCode = handlerErr("Invalid or expired code")
+
+type phoneParams struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+// PhoneOTP sends a one-time login code to the phone number
+func PhoneOTP(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params phoneParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		phone, err := auth.NormalizePhone(params.Phone)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidPhone)
+			return
+		}
+
+		count, err := deps.DB.IncrCache("phone_otp_rate:"+phone, phoneOTPRateWindow)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if count > phoneOTPRateLimit {
+			respondError(w, http.StatusTooManyRequests, tooManyRequests)
+			return
+		}
+
+		code, err := auth.OTPCode(phoneOTPDigits)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if err = deps.DB.StoreCache("phone_otp:"+phone, auth.HashNonce(code), phoneOTPTTL); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if _, err = deps.DB.DeleteCache("phone_otp_attempts:" + phone); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if err = deps.SMS.Send(phone, "Your login code is "+code); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, nil)
+	}))))
+}
+
+// PhoneLogin validates one-time code sent to the phone number.
+// A new user is created when there is no user with the phone number yet.
+func PhoneLogin(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params phoneParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		phone, err := auth.NormalizePhone(params.Phone)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidPhone)
+			return
+		}
+
+		attempts, err := deps.DB.IncrCache("phone_otp_attempts:"+phone, phoneOTPTTL)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if attempts > phoneOTPMaxAttempts {
+			deps.DB.DeleteCache("phone_otp:" + phone)
+			respondError(w, http.StatusUnauthorized, invalidOTPCode)
+			return
+		}
+
+		hash, err := deps.DB.GetCacheValue("phone_otp:" + phone)
+		if err != nil || !auth.ValidateNonce(params.Code, hash) {
+			auditLoginFailure(deps, r, 0, "phone", map[string]interface{}{"phone": phone})
+			respondError(w, http.StatusUnauthorized, invalidOTPCode)
+			return
+		}
+
+		del, err := deps.DB.DeleteCache("phone_otp:" + phone)
+		if del == 0 {
+			respondError(w, http.StatusUnauthorized, invalidOTPCode)
+			return
+		}
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		user, err := deps.DB.UserByPhone(phone)
+		if err != nil {
+			user = &models.User{Phone: phone, Roles: deps.RolePolicy.Default}
+
+			responseBody, err := registerUser(deps, r, user)
+			if err != nil {
+				if responseBody != nil {
+					respondExternal(w, http.StatusUnprocessableEntity, responseBody)
+					return
+				}
+
+				respondInternalError(w)
+				return
+			}
+		}
+
+		methods, err := mfaMethods(deps, user)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if len(methods) > 0 {
+			respondMFAChallenge(deps, w, r, user, methods)
+			return
+		}
+
+		userClaims, err := tokenClaims(deps, user, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		err = saveTokenDetails(deps, user.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.ID, "phone")
+		respond(w, http.StatusOK, token)
+	}))))
+}
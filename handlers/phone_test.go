@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestPhoneOTP(t *testing.T) {
+	t.Run("returns MethodNotAllowed for non-POST requests", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/phone/otp", PhoneOTP, nil, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns UnprocessableEntity with invalid phone", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"phone": "invalid"}`))
+		recorder := performRequest(t, "POST", "/phone/otp", PhoneOTP, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with valid phone", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"phone": "+1 555 000 0001"}`))
+		recorder := performRequest(t, "POST", "/phone/otp", PhoneOTP, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
+
+func TestPhoneLogin(t *testing.T) {
+	t.Run("returns UnprocessableEntity with invalid phone", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"phone": "invalid", "code": "123456"}`))
+		recorder := performRequest(t, "POST", "/phone/login", PhoneLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns Unauthorized with invalid code", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"phone": "` + testPhone + `", "code": "000000"}`))
+		recorder := performRequest(t, "POST", "/phone/login", PhoneLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with valid code", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"phone": "` + testPhone + `", "code": "` + testOTPCode + `"}`))
+		recorder := performRequest(t, "POST", "/phone/login", PhoneLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
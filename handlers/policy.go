@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/policy"
+)
+
+const policyExplainPermission = "policy:explain"
+
+const blankAction = handlerErr("Blank Action")
+const explainForbidden = handlerErr("Explaining the policy requires the " + policyExplainPermission + " permission")
+
+type authorizeParams struct {
+	Action   string                 `json:"action"`
+	Resource map[string]interface{} `json:"resource"`
+	Explain  bool                   `json:"explain"`
+}
+
+// AuthorizeAction evaluates the policy for the action of the token owner on the resource.
+// Explain responds with the rules evaluated up to the matching one without enforcing anything,
+// it is available only to users with the policy:explain permission.
+func AuthorizeAction(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		var params authorizeParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if len(params.Action) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankAction)
+			return
+		}
+
+		decision, err := PolicyDecision(deps, claims, params.Action, params.Resource, params.Explain)
+		if err == explainForbidden {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		respond(w, http.StatusOK, decision)
+	})))))
+}
+
+// PolicyDecision evaluates the policy against claims of a verified access token, the record of the token owner
+// and attributes of the resource. Roles and permissions of the user are the ones of the tenant membership
+// for tokens of a tenant. Middlewares call it after validating the token to authorize requests.
+// Explaining requires the policy:explain permission since the trace reveals the rules and attributes.
+func PolicyDecision(deps *Deps, claims *auth.Claims, action string, resource map[string]interface{}, explain bool) (*policy.Decision, error) {
+	user, err := deps.DB.UserByID(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, effectiveRoles, permissions := user.Roles, user.EffectiveRoles, user.Permissions
+	if len(claims.Tenant) > 0 {
+		membership, err := deps.DB.Membership(claims.Tenant, user.ID)
+		if err != nil {
+			return nil, notTenantMember
+		}
+
+		roles, effectiveRoles, permissions = membership.Roles, membership.EffectiveRoles, membership.Permissions
+	}
+
+	if explain && !contains(permissions, policyExplainPermission) {
+		return nil, explainForbidden
+	}
+
+	attributes := map[string]interface{}{
+		"token": map[string]interface{}{
+			"user_id":     claims.UserID,
+			"roles":       claims.Roles,
+			"permissions": claims.Permissions,
+			"tenant":      claims.Tenant,
+		},
+		"user": map[string]interface{}{
+			"id":              user.ID,
+			"email":           user.Email,
+			"phone":           user.Phone,
+			"roles":           roles,
+			"effective_roles": effectiveRoles,
+			"permissions":     permissions,
+			"payload":         user.Payload,
+			"created_at":      user.CreatedAt.Format(time.RFC3339),
+		},
+		"resource": resource,
+	}
+
+	return deps.Policy.Evaluate(action, attributes, time.Now(), explain), nil
+}
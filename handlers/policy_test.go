@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/policy"
+)
+
+// testPolicy is the policy engine used by handlers in tests
+var testPolicy *policy.Engine
+
+var testPolicyRules = []policy.Rule{
+	{
+		Name:    "admins edit documents of their organization",
+		Effect:  policy.Allow,
+		Actions: []string{"documents:edit"},
+		Conditions: []policy.Condition{
+			{Attribute: "user.effective_roles", Operator: policy.Contains, Value: "admin"},
+			{Attribute: "resource.organization", Operator: policy.Equals, ValueAttribute: "token.tenant"},
+		},
+	},
+	{
+		Name:    "editors publish documents",
+		Effect:  policy.Allow,
+		Actions: []string{"documents:publish"},
+		Conditions: []policy.Condition{
+			{Attribute: "user.effective_roles", Operator: policy.Contains, Value: "editor"},
+		},
+	},
+	{
+		Name:    "owners read documents",
+		Effect:  policy.Allow,
+		Actions: []string{"documents:read"},
+		Conditions: []policy.Condition{
+			{Attribute: "resource.owner", Operator: policy.Equals, ValueAttribute: "user.email"},
+		},
+	},
+}
+
+func setTestPolicy(t *testing.T) {
+	t.Helper()
+
+	engine, err := policy.New(testPolicyRules, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testPolicy = engine
+}
+
+func TestAuthorizeAction(t *testing.T) {
+	setTestPolicy(t)
+
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &auth.RSAKeys{AccessSign: key, AccessVerify: &key.PublicKey, RefreshSign: key, RefreshVerify: &key.PublicKey}
+
+	token, err := auth.Token(auth.Claims{UserID: 1, Tenant: "acme"}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantHeaders := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: token.Access}
+
+	t.Run("returns Unauthorized without token", func(t *testing.T) {
+		body := strings.NewReader(`{"action": "documents:read"}`)
+		recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, body, jsonHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns UnprocessableEntity with blank action", func(t *testing.T) {
+		body := strings.NewReader(`{"resource": {}}`)
+		recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, body, authHeaders(t, key, 1), key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	testCases := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			"allows by attributes of the user",
+			`{"action": "documents:read", "resource": {"owner": "test@mail.com"}}`,
+			authHeaders(t, key, 1), `{"allowed":true,"rule":"owners read documents"}`,
+		},
+		{
+			"allows by claims of the token and roles of the tenant",
+			`{"action": "documents:edit", "resource": {"organization": "acme"}}`,
+			tenantHeaders, `{"allowed":true,"rule":"admins edit documents of their organization"}`,
+		},
+		{
+			"allows by global roles without tenant",
+			`{"action": "documents:publish"}`,
+			authHeaders(t, key, 1), `{"allowed":true,"rule":"editors publish documents"}`,
+		},
+		{
+			"denies by global roles of the tenant member",
+			`{"action": "documents:publish"}`,
+			tenantHeaders, `{"allowed":false}`,
+		},
+		{
+			"denies without matching rules",
+			`{"action": "documents:edit", "resource": {"organization": "globex"}}`,
+			tenantHeaders, `{"allowed":false}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, strings.NewReader(tc.body), tc.headers, key)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+			if body := recorder.Body.String(); body != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, body)
+			}
+		})
+	}
+
+	t.Run("returns Unauthorized when the user isn't a member of the tenant", func(t *testing.T) {
+		token, err := auth.Token(auth.Claims{UserID: 1, Tenant: "globex"}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		headers := map[string]string{contentTypeHeader: jsonContentType, auhtorizationHeader: token.Access}
+		recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, strings.NewReader(`{"action": "documents:read"}`), headers, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("explains evaluated rules", func(t *testing.T) {
+		body := strings.NewReader(`{"action": "documents:edit", "resource": {"organization": "globex"}, "explain": true}`)
+		recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, body, tenantHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var decision policy.Decision
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decision); err != nil {
+			t.Fatal(err)
+		}
+		if len(decision.Trace) != 1 || decision.Trace[0].Failed.Attribute != "resource.organization" || decision.Trace[0].Actual != "globex" {
+			t.Errorf("got unexpected decision %+v", decision)
+		}
+	})
+
+	t.Run("returns Forbidden when explaining without permission", func(t *testing.T) {
+		body := strings.NewReader(`{"action": "documents:edit", "resource": {"organization": "globex"}, "explain": true}`)
+		recorder := performRequest(t, "POST", "/authorize", AuthorizeAction, body, authHeaders(t, key, 1), key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+
+		if strings.Contains(recorder.Body.String(), "trace") {
+			t.Errorf("expected no trace, got %s", recorder.Body.String())
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+)
+
+// RateLimit allows Limit requests per Period for every value of the key.
+// Key is one of "ip", "email" (read from the JSON body) or "user" (from the access token).
+type RateLimit struct {
+	Limit  int64  `json:"limit"`
+	Period string `json:"period"`
+	Key    string `json:"key"`
+
+	window time.Duration
+}
+
+// RateLimits contains rate limit policies indexed by route path
+type RateLimits map[string][]*RateLimit
+
+const (
+	rateLimitIP    = "ip"
+	rateLimitEmail = "email"
+	rateLimitUser  = "user"
+)
+
+var defaultRateLimits = map[string][]*RateLimit{
+	"/email/register":        {{Limit: 10, Period: "1h", Key: rateLimitIP}},
+	"/email/login":           {{Limit: 30, Period: "1m", Key: rateLimitIP}, {Limit: 10, Period: "1m", Key: rateLimitEmail}},
+	"/email/login/mfa":       {{Limit: 20, Period: "1m", Key: rateLimitIP}},
+	"/email/magic-link":      {{Limit: 10, Period: "1m", Key: rateLimitIP}},
+	"/phone/otp":             {{Limit: 10, Period: "1m", Key: rateLimitIP}},
+	"/phone/login":           {{Limit: 20, Period: "1m", Key: rateLimitIP}},
+	"/refresh":               {{Limit: 60, Period: "1m", Key: rateLimitIP}},
+	"/invitations/accept":    {{Limit: 10, Period: "1m", Key: rateLimitIP}},
+	"/webauthn/login/finish": {{Limit: 30, Period: "1m", Key: rateLimitIP}},
+}
+
+// LoadRateLimits returns default policies overridden by routes of the JSON file specified in the environment
+func LoadRateLimits() (RateLimits, error) {
+	limits := make(RateLimits)
+	for route, policies := range defaultRateLimits {
+		limits[route] = policies
+	}
+
+	if path := os.Getenv("RATE_LIMITS_PATH"); len(path) > 0 {
+		c, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var routes RateLimits
+		if err = json.Unmarshal(c, &routes); err != nil {
+			return nil, err
+		}
+
+		for route, policies := range routes {
+			limits[route] = policies
+		}
+	}
+
+	for route, policies := range limits {
+		for _, p := range policies {
+			if err := p.init(); err != nil {
+				return nil, fmt.Errorf("Invalid rate limit of %s: %v", route, err)
+			}
+		}
+	}
+
+	return limits, nil
+}
+
+func (p *RateLimit) init() error {
+	window, err := time.ParseDuration(p.Period)
+	if err != nil {
+		return err
+	}
+	if window <= 0 || p.Limit <= 0 {
+		return fmt.Errorf("limit and period must be positive")
+	}
+
+	switch p.Key {
+	case rateLimitIP, rateLimitEmail, rateLimitUser:
+	default:
+		return fmt.Errorf("unknown key %q", p.Key)
+	}
+
+	p.window = window
+
+	return nil
+}
+
+// rateLimitResult describes the state of the most restrictive policy after the request
+type rateLimitResult struct {
+	limit     int64
+	remaining int64
+	reset     time.Duration
+	allowed   bool
+}
+
+// take counts the request in a sliding window approximated by the weighted
+// counter of the previous fixed window
+func (p *RateLimit) take(deps *Deps, route, value string) (*rateLimitResult, error) {
+	now := time.Now()
+	index := now.UnixNano() / int64(p.window)
+	elapsed := now.Sub(time.Unix(0, index*int64(p.window)))
+
+	current, err := deps.DB.IncrCache(rateLimitKey(route, p, index, value), 2*p.window)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous int64
+	if v, err := deps.DB.GetCacheValue(rateLimitKey(route, p, index-1, value)); err == nil && len(v) > 0 {
+		previous, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	weight := 1 - float64(elapsed)/float64(p.window)
+	count := int64(float64(previous)*weight) + current
+
+	result := &rateLimitResult{limit: p.Limit, remaining: p.Limit - count, reset: p.window - elapsed, allowed: count <= p.Limit}
+	if result.remaining < 0 {
+		result.remaining = 0
+	}
+
+	return result, nil
+}
+
+func rateLimitKey(route string, p *RateLimit, index int64, value string) string {
+	return "rate_limit:" + route + ":" + p.Key + ":" + strconv.FormatInt(index, 10) + ":" + value
+}
+
+// rateLimitValue returns the value the request is counted by or an empty string when the key is missing
+func rateLimitValue(deps *Deps, r *http.Request, key string) string {
+	switch key {
+	case rateLimitEmail:
+		return requestEmail(r)
+	case rateLimitUser:
+		token, err := auth.ValidateToken(r.Header.Get(auhtorizationHeader), deps.Keys.AccessVerify)
+		claims, ok := token.(*auth.Claims)
+		if err != nil || !ok || claims == nil {
+			return ""
+		}
+
+		return strconv.FormatInt(claims.UserID, 10)
+	default:
+		return clientIP(r)
+	}
+}
+
+// requestEmail reads the email of the JSON body and restores the body for the next handler
+func requestEmail(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var params struct {
+		Email string `json:"email"`
+	}
+	if err = json.Unmarshal(body, &params); err != nil {
+		return ""
+	}
+
+	return auth.NormalizeEmail(params.Email)
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, result *rateLimitResult) {
+	reset := strconv.Itoa(int(result.reset.Seconds()) + 1)
+
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(result.limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(result.remaining, 10))
+	w.Header().Set("RateLimit-Reset", reset)
+	if !result.allowed {
+		w.Header().Set("Retry-After", reset)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestRateLimitHandler(t *testing.T) {
+	t.Run("returns TooManyRequests when email limit is exceeded", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "limited@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusTooManyRequests)
+
+		if len(recorder.Header().Get("Retry-After")) == 0 {
+			t.Error("expected Retry-After header")
+		}
+		if recorder.Header().Get("RateLimit-Remaining") != "0" {
+			t.Errorf("expected no remaining requests, got %q", recorder.Header().Get("RateLimit-Remaining"))
+		}
+	})
+
+	t.Run("sets RateLimit headers and passes the body to the handler", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com", "password": "password"}`))
+		recorder := performRequest(t, "POST", "/email/login", EmailLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if recorder.Header().Get("RateLimit-Limit") != "10" || recorder.Header().Get("RateLimit-Remaining") != "9" {
+			t.Errorf("got unexpected headers %v", recorder.Header())
+		}
+	})
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rate_limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rate_limits.json")
+	os.Setenv("RATE_LIMITS_PATH", path)
+	defer os.Unsetenv("RATE_LIMITS_PATH")
+
+	t.Run("overrides policies of the route", func(t *testing.T) {
+		if err := ioutil.WriteFile(path, []byte(`{"/email/login": [{"limit": 5, "period": "30s", "key": "email"}]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		limits, err := LoadRateLimits()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		policies := limits["/email/login"]
+		if len(policies) != 1 || policies[0].Limit != 5 || policies[0].window != 30*time.Second {
+			t.Errorf("got unexpected policies %+v", policies)
+		}
+		if len(limits["/email/register"]) == 0 {
+			t.Error("expected default policies of other routes")
+		}
+	})
+
+	t.Run("returns error for unknown key", func(t *testing.T) {
+		if err := ioutil.WriteFile(path, []byte(`{"/email/login": [{"limit": 5, "period": "30s", "key": "phone"}]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadRateLimits(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const forbiddenRole = handlerErr("Role can't be requested")
+
+// RolePolicy restricts roles users get without the internal API.
+// Default roles are assigned on every self-signup, SelfService roles may be picked on registration
+// and Invitation roles may be granted by invitations. Internal roles and roles missing in the policy
+// can be granted only through the internal API.
+type RolePolicy struct {
+	Default     []string `json:"default"`
+	SelfService []string `json:"self_service"`
+	Invitation  []string `json:"invitation"`
+	Internal    []string `json:"internal"`
+}
+
+// LoadRolePolicy returns the policy from the JSON file specified in the environment.
+// Without the file self-signups get no roles and can't request any.
+func LoadRolePolicy() (*RolePolicy, error) {
+	policy := &RolePolicy{}
+
+	path := os.Getenv("ROLE_POLICY_PATH")
+	if len(path) == 0 {
+		return policy, nil
+	}
+
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(c, policy); err != nil {
+		return nil, err
+	}
+
+	for _, role := range policy.Internal {
+		if contains(policy.Default, role) || contains(policy.SelfService, role) || contains(policy.Invitation, role) {
+			return nil, fmt.Errorf("Internal role %q of the role policy can't be granted otherwise", role)
+		}
+	}
+
+	return policy, nil
+}
+
+// signupRoles returns default roles with the requested ones when all of them are default or self-service
+func (p *RolePolicy) signupRoles(requested []string) ([]string, error) {
+	for _, role := range requested {
+		if !contains(p.Default, role) && !contains(p.SelfService, role) {
+			return nil, forbiddenRole
+		}
+	}
+
+	return union(p.Default, requested), nil
+}
+
+// invitationRoles returns default roles with the invited ones when all of them can be granted without the internal API
+func (p *RolePolicy) invitationRoles(invited []string) ([]string, error) {
+	for _, role := range invited {
+		if !contains(p.Default, role) && !contains(p.SelfService, role) && !contains(p.Invitation, role) {
+			return nil, forbiddenRole
+		}
+	}
+
+	return union(p.Default, invited), nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// union returns unique items of both slices in order of appearance
+func union(a, b []string) []string {
+	result := make([]string, 0, len(a)+len(b))
+	for _, item := range append(append([]string{}, a...), b...) {
+		if !contains(result, item) {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+// testRolePolicy assigns viewer on self-signup and lets users request staff
+var testRolePolicy = &RolePolicy{
+	Default:     []string{"viewer"},
+	SelfService: []string{"staff"},
+	Invitation:  []string{"editor"},
+	Internal:    []string{"admin"},
+}
+
+func TestEmailRegisterRolePolicy(t *testing.T) {
+	externalApp := testServer()
+	defer externalApp.Close()
+
+	os.Setenv("API_HOST", externalApp.URL)
+
+	t.Run("returns OK with self-service role", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "valid@mail.com", "password": "12345678", "roles": ["staff"]}`))
+		recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	for _, role := range []string{"admin", "editor", "unknown"} {
+		t.Run("returns UnprocessableEntity with "+role+" role", func(t *testing.T) {
+			body := bytes.NewBuffer([]byte(`{"email": "valid@mail.com", "password": "12345678", "roles": ["` + role + `"]}`))
+			recorder := performRequest(t, "POST", "/email/register", EmailRegister, body, jsonHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+		})
+	}
+}
+
+func TestRolePolicy(t *testing.T) {
+	t.Run("adds default roles on signup", func(t *testing.T) {
+		roles, err := testRolePolicy.signupRoles([]string{"staff", "viewer"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"viewer", "staff"}; !reflect.DeepEqual(roles, want) {
+			t.Errorf("expected %v, got %v", want, roles)
+		}
+	})
+
+	t.Run("allows invitation and self-service roles in invitations", func(t *testing.T) {
+		roles, err := testRolePolicy.invitationRoles([]string{"editor", "staff"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"viewer", "editor", "staff"}; !reflect.DeepEqual(roles, want) {
+			t.Errorf("expected %v, got %v", want, roles)
+		}
+	})
+
+	t.Run("forbids internal roles in invitations", func(t *testing.T) {
+		if _, err := testRolePolicy.invitationRoles([]string{"admin"}); err != forbiddenRole {
+			t.Errorf("expected %q error, got %v", forbiddenRole, err)
+		}
+	})
+}
+
+func TestLoadRolePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "role_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "role_policy.json")
+	os.Setenv("ROLE_POLICY_PATH", path)
+	defer os.Unsetenv("ROLE_POLICY_PATH")
+
+	t.Run("reads roles of the policy", func(t *testing.T) {
+		if err := ioutil.WriteFile(path, []byte(`{"default": ["viewer"], "internal": ["admin"]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		policy, err := LoadRolePolicy()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(policy.Default) != 1 || len(policy.Internal) != 1 || len(policy.SelfService) != 0 {
+			t.Errorf("got unexpected policy %+v", policy)
+		}
+	})
+
+	t.Run("returns error when internal role can be granted otherwise", func(t *testing.T) {
+		if err := ioutil.WriteFile(path, []byte(`{"invitation": ["admin"], "internal": ["admin"]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadRolePolicy(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const unknownRole = handlerErr("Unknown Role")
+const blankUserIDs = handlerErr("Blank User IDs")
+const tooManyUserIDs = handlerErr("Too many User IDs")
+const invalidCursor = handlerErr("Invalid Cursor")
+const invalidLimit = handlerErr("Invalid Limit")
+
+const maxRoleAssignmentUsers = 100
+const defaultMembersLimit = 50
+const maxMembersLimit = 200
+
+type roleParentParams struct {
+	Role   string `json:"role"`
+	Parent string `json:"parent"`
+}
+
+// roleNode is a role with the roles it inherits
+type roleNode struct {
+	Name     string      `json:"name"`
+	Children []*roleNode `json:"children"`
+}
+
+// roleAssignmentParams assigns or unassigns all of the roles for all of the users.
+// Tokens issued before the change stop working. RevokeTokens set to false keeps the tokens
+// of users getting new roles, which take effect once the tokens are refreshed.
+// Tokens of users losing roles are always revoked.
+type roleAssignmentParams struct {
+	UserIDs      []int64  `json:"user_ids"`
+	Roles        []string `json:"roles"`
+	RevokeTokens *bool    `json:"revoke_tokens"`
+}
+
+type roleMember struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type roleMembersPage struct {
+	Members    []roleMember `json:"members"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type userRoles struct {
+	UserID         int64    `json:"user_id"`
+	Roles          []string `json:"roles"`
+	EffectiveRoles []string `json:"effective_roles"`
+	Permissions    []string `json:"permissions"`
+}
+
+// SetRoleParent makes the role inherited by the parent role or detaches it when the parent is blank
+func SetRoleParent(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params roleParentParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if len(params.Role) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankRole)
+			return
+		}
+
+		// The transaction rejects unknown roles and changes creating a cycle
+		if err = deps.DB.SetRoleParent(params.Role, params.Parent); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		audit(deps, r, &models.AuditEvent{
+			Action:   auditRoleParent,
+			Outcome:  auditSuccess,
+			Metadata: map[string]interface{}{"role": params.Role, "parent": params.Parent},
+		})
+	})))))
+}
+
+// RoleTree returns top level roles with the roles they inherit
+func RoleTree(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles, err := deps.DB.RoleHierarchy()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, map[string][]*roleNode{"roles": roleTree(roles)})
+	}))))
+}
+
+// UserRoles returns assigned and effective roles of the user with permissions granted by them.
+// Roles of the user in the organization are returned when it's specified.
+func UserRoles(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidUserID)
+			return
+		}
+
+		if slug := r.FormValue("organization"); len(slug) > 0 {
+			membership, err := deps.DB.Membership(slug, userID)
+			if err != nil {
+				respondError(w, http.StatusNotFound, notTenantMember)
+				return
+			}
+
+			respond(w, http.StatusOK, &userRoles{
+				UserID:         membership.UserID,
+				Roles:          nonNil(membership.Roles),
+				EffectiveRoles: nonNil(membership.EffectiveRoles),
+				Permissions:    nonNil(membership.Permissions),
+			})
+			return
+		}
+
+		user, err := deps.DB.UserByID(userID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, invalidUserID)
+			return
+		}
+
+		respond(w, http.StatusOK, &userRoles{
+			UserID:         user.ID,
+			Roles:          nonNil(user.Roles),
+			EffectiveRoles: nonNil(user.EffectiveRoles),
+			Permissions:    nonNil(user.Permissions),
+		})
+	}))))
+}
+
+// AddUserRoles assigns the roles to the users in a single transaction
+func AddUserRoles(deps *Deps) http.Handler {
+	return roleAssignmentHandler(deps, "added", deps.DB.AddUserRoles)
+}
+
+// RemoveUserRoles unassigns the roles from the users in a single transaction
+func RemoveUserRoles(deps *Deps) http.Handler {
+	return roleAssignmentHandler(deps, "removed", deps.DB.RemoveUserRoles)
+}
+
+func roleAssignmentHandler(deps *Deps, change string, apply func(userIDs []int64, roles []string) error) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params roleAssignmentParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		userIDs, roles, err := roleAssignment(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if err = apply(userIDs, roles); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		revoke := change == "removed" || params.RevokeTokens == nil || *params.RevokeTokens
+		for _, userID := range userIDs {
+			if revoke {
+				if err = revokeTokens(deps, userID); err != nil {
+					deps.Logger.RequestError(r, err)
+					respondInternalError(w)
+					return
+				}
+			}
+
+			audit(deps, r, &models.AuditEvent{
+				SubjectID: userID,
+				Action:    auditUserRoles,
+				Outcome:   auditSuccess,
+				Metadata:  map[string]interface{}{change: roles, "revoke_tokens": revoke},
+			})
+		}
+	})))))
+}
+
+// RoleMembers returns users the role is assigned to directly, ordered by ID
+func RoleMembers(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := r.FormValue("role")
+		if len(role) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankRole)
+			return
+		}
+
+		after, limit, err := pageParams(r, defaultMembersLimit, maxMembersLimit)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		// One more user is requested to know whether there is a next page
+		users, err := deps.DB.RoleMembers(role, after, limit+1)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		page := &roleMembersPage{Members: []roleMember{}}
+		for i, user := range users {
+			if i == limit {
+				page.NextCursor = strconv.FormatInt(users[i-1].ID, 10)
+				break
+			}
+
+			page.Members = append(page.Members, roleMember{ID: user.ID, Email: user.Email, Phone: user.Phone, CreatedAt: user.CreatedAt})
+		}
+
+		respond(w, http.StatusOK, page)
+	}))))
+}
+
+// roleAssignment returns unique user IDs and role names of the params
+func roleAssignment(params *roleAssignmentParams) ([]int64, []string, error) {
+	if len(params.UserIDs) == 0 {
+		return nil, nil, blankUserIDs
+	}
+	if len(params.Roles) == 0 {
+		return nil, nil, blankRoles
+	}
+
+	userIDs := make([]int64, 0, len(params.UserIDs))
+	seenUsers := make(map[int64]bool)
+	for _, id := range params.UserIDs {
+		if id <= 0 {
+			return nil, nil, invalidUserID
+		}
+		if !seenUsers[id] {
+			seenUsers[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) > maxRoleAssignmentUsers {
+		return nil, nil, tooManyUserIDs
+	}
+
+	roles := make([]string, 0, len(params.Roles))
+	seenRoles := make(map[string]bool)
+	for _, role := range params.Roles {
+		if len(role) == 0 {
+			return nil, nil, blankRole
+		}
+		if !seenRoles[role] {
+			seenRoles[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	return userIDs, roles, nil
+}
+
+// pageParams returns the cursor and the limit of the query within the maximum
+func pageParams(r *http.Request, defaultLimit, maxLimit int) (after int64, limit int, err error) {
+	limit = defaultLimit
+
+	if cursor := r.FormValue("cursor"); len(cursor) > 0 {
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return 0, 0, invalidCursor
+		}
+	}
+	if l := r.FormValue("limit"); len(l) > 0 {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			return 0, 0, invalidLimit
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+
+	return after, limit, nil
+}
+
+// roleTree nests roles under their parents ordered as given
+func roleTree(roles []models.Role) []*roleNode {
+	nodes := make(map[string]*roleNode, len(roles))
+	for _, r := range roles {
+		nodes[r.Name] = &roleNode{Name: r.Name, Children: []*roleNode{}}
+	}
+
+	tree := []*roleNode{}
+	for _, r := range roles {
+		parent, ok := nodes[r.Parent]
+		if !ok {
+			tree = append(tree, nodes[r.Name])
+			continue
+		}
+
+		parent.Children = append(parent.Children, nodes[r.Name])
+	}
+
+	return tree
+}
+
+func nonNil(items []string) []string {
+	if items == nil {
+		return []string{}
+	}
+
+	return items
+}
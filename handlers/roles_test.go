@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// testRoles are ordered by name, admin inherits editor which inherits viewer
+var testRoles = []models.Role{
+	{ID: 1, Name: "admin"},
+	{ID: 2, Name: "editor", Parent: "admin"},
+	{ID: 3, Name: "staff"},
+	{ID: 4, Name: "viewer", Parent: "editor"},
+}
+
+func TestSetRoleParent(t *testing.T) {
+	path := "/internal/roles/parent"
+
+	t.Run("returns OK", func(t *testing.T) {
+		body := []byte(`{"role": "staff", "parent": "editor"}`)
+		recorder := performRequest(t, "POST", path, SetRoleParent, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditRoleParent || e.Metadata["parent"] != "editor" {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	t.Run("returns OK with blank parent", func(t *testing.T) {
+		body := []byte(`{"role": "viewer"}`)
+		recorder := performRequest(t, "POST", path, SetRoleParent, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  string
+	}{
+		"blank role":      {`{"parent": "admin"}`, blankRole.Error()},
+		"rejected change": {`{"role": "rejected", "parent": "admin"}`, "Role can't inherit itself"},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, SetRoleParent, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoleTree(t *testing.T) {
+	recorder := performRequest(t, "GET", "/internal/roles/tree", RoleTree, nil, internalHeaders, nil)
+
+	authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+	want := `{"roles":[{"name":"admin","children":[{"name":"editor","children":[{"name":"viewer","children":[]}]}]},` +
+		`{"name":"staff","children":[]}]}`
+	if body := recorder.Body.String(); body != want {
+		t.Errorf("expected %s, got %s", want, body)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	t.Run("returns effective roles", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/users/roles?id=1", UserRoles, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		want := `{"user_id":1,"roles":["editor"],"effective_roles":["editor","viewer"],"permissions":["users:read"]}`
+		if body := recorder.Body.String(); body != want {
+			t.Errorf("expected %s, got %s", want, body)
+		}
+	})
+
+	t.Run("returns NotFound for unknown user", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/users/roles?id=100", UserRoles, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusNotFound)
+	})
+}
+
+func TestAddUserRoles(t *testing.T) {
+	path := "/internal/users/roles/add"
+
+	t.Run("returns OK and audits unique roles of every user", func(t *testing.T) {
+		body := []byte(`{"user_ids": [1, 4, 1], "roles": ["staff", "staff", "viewer"]}`)
+		recorder := performRequest(t, "POST", path, AddUserRoles, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		events := auditedEvents[len(auditedEvents)-2:]
+		for i, id := range []int64{1, 4} {
+			e := events[i]
+			if e.Action != auditUserRoles || e.SubjectID != id || e.Metadata["revoke_tokens"] != true {
+				t.Errorf("got unexpected audit event %+v", e)
+			}
+			if roles, ok := e.Metadata["added"].([]string); !ok || len(roles) != 2 {
+				t.Errorf("expected two added roles, got %v", e.Metadata["added"])
+			}
+		}
+	})
+
+	t.Run("keeps tokens when revocation is opted out", func(t *testing.T) {
+		body := []byte(`{"user_ids": [1], "roles": ["staff"], "revoke_tokens": false}`)
+		recorder := performRequest(t, "POST", path, AddUserRoles, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Metadata["revoke_tokens"] != false {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+
+	invalid := map[string]struct {
+		body string
+		err  string
+	}{
+		"blank user IDs":  {`{"roles": ["staff"]}`, blankUserIDs.Error()},
+		"invalid user ID": {`{"user_ids": [0], "roles": ["staff"]}`, invalidUserID.Error()},
+		"blank roles":     {`{"user_ids": [1]}`, blankRoles.Error()},
+		"blank role":      {`{"user_ids": [1], "roles": [""]}`, blankRole.Error()},
+		"unknown role":    {`{"user_ids": [1], "roles": ["guest"]}`, "unknown role"},
+		"unknown user":    {`{"user_ids": [1, 100], "roles": ["staff"]}`, "unknown users"},
+	}
+	for name, tc := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "POST", path, AddUserRoles, strings.NewReader(tc.body), internalJSONHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+			if !strings.Contains(recorder.Body.String(), tc.err) {
+				t.Errorf("expected %q error, got %q", tc.err, recorder.Body.String())
+			}
+		})
+	}
+
+	t.Run("returns UnprocessableEntity with too many users", func(t *testing.T) {
+		ids := make([]string, maxRoleAssignmentUsers+1)
+		for i := range ids {
+			ids[i] = strconv.Itoa(i + 1)
+		}
+		body := `{"user_ids": [` + strings.Join(ids, ",") + `], "roles": ["staff"]}`
+		recorder := performRequest(t, "POST", path, AddUserRoles, strings.NewReader(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestRemoveUserRoles(t *testing.T) {
+	t.Run("returns OK and revokes tokens even when opted out", func(t *testing.T) {
+		body := []byte(`{"user_ids": [1], "roles": ["editor"], "revoke_tokens": false}`)
+		recorder := performRequest(t, "POST", "/internal/users/roles/remove", RemoveUserRoles, bytes.NewBuffer(body), internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if e := lastAuditEvent(t); e.Action != auditUserRoles || e.Metadata["removed"] == nil || e.Metadata["revoke_tokens"] != true {
+			t.Errorf("got unexpected audit event %+v", e)
+		}
+	})
+}
+
+func TestRoleMembers(t *testing.T) {
+	t.Run("returns the first page with the next cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/roles/members?role=staff&limit=2", RoleMembers, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var page roleMembersPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Members) != 2 || page.Members[1].ID != 2 || page.NextCursor != "2" {
+			t.Errorf("got unexpected page %+v", page)
+		}
+	})
+
+	t.Run("returns the last page without the next cursor", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/internal/roles/members?role=staff&cursor=2&limit=2", RoleMembers, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		var page roleMembersPage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Members) != 1 || page.Members[0].ID != 4 || len(page.NextCursor) != 0 {
+			t.Errorf("got unexpected page %+v", page)
+		}
+	})
+
+	invalid := map[string]string{
+		"blank role":     "/internal/roles/members",
+		"unknown role":   "/internal/roles/members?role=guest",
+		"invalid cursor": "/internal/roles/members?role=staff&cursor=abc",
+		"invalid limit":  "/internal/roles/members?role=staff&limit=0",
+	}
+	for name, path := range invalid {
+		t.Run("returns UnprocessableEntity with "+name, func(t *testing.T) {
+			recorder := performRequest(t, "GET", path, RoleMembers, nil, internalHeaders, nil)
+
+			authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+		})
+	}
+}
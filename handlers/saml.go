@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/oauth"
+	"github.com/maxshend/tiny_goauth/samlauth"
+)
+
+const samlStateTTL = 10 * time.Minute
+const samlMetadataContentType = "application/samlmetadata+xml"
+
+const samlDisabled = handlerErr("SAML is not configured")
+const unknownTenant = handlerErr("Unknown SAML tenant")
+const invalidSAMLState = handlerErr("Invalid SAML relay state")
+const invalidSAMLResponse = handlerErr("Invalid SAML response")
+const replayedSAMLResponse = handlerErr("SAML assertion has already been used")
+const blankTenant = handlerErr("Blank Tenant")
+const blankMetadata = handlerErr("Blank IdP Metadata")
+const blankSAMLDomains = handlerErr("Blank Email Domains")
+const unknownSAMLOrganization = handlerErr("SAML tenant must be an organization")
+const foreignSAMLEmail = handlerErr("Email domain isn't allowed for the SAML tenant")
+const samlEmailTaken = handlerErr("User with this email already exists and can't be linked to the SAML identity")
+
+type samlState struct {
+	Tenant    string `json:"tenant"`
+	RequestID string `json:"request_id"`
+}
+
+type samlConnectionParams struct {
+	models.SAMLConnection
+	MetadataURL string `json:"idp_metadata_url"`
+}
+
+// SAMLMetadata returns service provider metadata of the tenant
+func SAMLMetadata(deps *Deps) http.Handler {
+	return logHandler(deps, getHandler(samlHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := deps.DB.SAMLConnection(r.FormValue("tenant"))
+		if err != nil {
+			respondError(w, http.StatusNotFound, unknownTenant)
+			return
+		}
+
+		metadata, err := deps.SAML.Metadata(connection)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		w.Header().Set(contentTypeHeader, samlMetadataContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(metadata)
+	}))))
+}
+
+// SAMLLogin redirects the user to the identity provider of the tenant with a new authentication request
+func SAMLLogin(deps *Deps) http.Handler {
+	return logHandler(deps, getHandler(samlHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := deps.DB.SAMLConnection(r.FormValue("tenant"))
+		if err != nil {
+			respondError(w, http.StatusNotFound, unknownTenant)
+			return
+		}
+
+		relayState := uuid.New().String()
+		location, requestID, err := deps.SAML.AuthnRequestURL(connection, relayState)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		payload, err := json.Marshal(&samlState{Tenant: connection.Tenant, RequestID: requestID})
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if err = deps.DB.StoreCache("saml_state:"+relayState, payload, samlStateTTL); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		http.Redirect(w, r, location, http.StatusFound)
+	}))))
+}
+
+// SAMLACS validates the identity provider response and returns access and refresh tokens of the tenant.
+// Users are created on the first login, existing accounts are never linked to identities asserted by the provider.
+// Roles of users in the organization of the tenant are synchronized with assertion attributes.
+func SAMLACS(deps *Deps) http.Handler {
+	return logHandler(deps, postHandler(samlHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		state, err := loadSAMLState(deps, r.PostForm.Get("RelayState"))
+		if err != nil || state.Tenant != r.FormValue("tenant") {
+			respondError(w, http.StatusUnauthorized, invalidSAMLState)
+			return
+		}
+
+		connection, err := deps.DB.SAMLConnection(state.Tenant)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, unknownTenant)
+			return
+		}
+
+		assertion, err := deps.SAML.ParseResponse(connection, r.PostForm.Get("SAMLResponse"), state.RequestID)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondError(w, http.StatusUnauthorized, invalidSAMLResponse)
+			return
+		}
+
+		fresh, err := deps.DB.StoreCacheNX("saml_assertion:"+connection.Tenant+":"+assertion.ID, 1, assertion.ReplayTTL())
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if !fresh {
+			respondError(w, http.StatusUnauthorized, replayedSAMLResponse)
+			return
+		}
+
+		identity := &oauth.Identity{Provider: "saml:" + connection.Tenant, Subject: assertion.NameID, Email: auth.NormalizeEmail(assertion.Email)}
+		if !samlDomainAllowed(connection, identity.Email) {
+			respondError(w, http.StatusForbidden, foreignSAMLEmail)
+			return
+		}
+
+		var user *models.User
+
+		linked, err := deps.DB.IdentityBySubject(identity.Provider, identity.Subject)
+		if err == nil {
+			user, err = deps.DB.UserByID(linked.UserID)
+			if err != nil {
+				respondInvalidToken(w)
+				return
+			}
+		} else {
+			// Identity providers of tenants must not take over accounts they didn't create
+			if _, err = deps.DB.UserByEmail(identity.Email); err == nil {
+				respondError(w, http.StatusConflict, samlEmailTaken)
+				return
+			}
+
+			user = &models.User{Email: identity.Email, Roles: deps.RolePolicy.Default}
+
+			responseBody, err := registerUser(deps, r, user)
+			if err != nil {
+				if responseBody != nil {
+					respondExternal(w, http.StatusUnprocessableEntity, responseBody)
+					return
+				}
+
+				respondInternalError(w)
+				return
+			}
+
+			if _, err = linkIdentity(deps, user.ID, identity); err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+		}
+
+		if err = syncSAMLMembership(deps, r, connection, user.ID, samlauth.Roles(connection, assertion)); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		userClaims, err := tokenClaims(deps, user, connection.Tenant)
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		err = saveTokenDetails(deps, user.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.ID, "saml:"+connection.Tenant)
+		respond(w, http.StatusOK, token)
+	}))))
+}
+
+// SaveSAMLConnection imports identity provider metadata of the tenant from XML or URL
+func SaveSAMLConnection(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params samlConnectionParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		connection := &params.SAMLConnection
+		if len(connection.Tenant) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankTenant)
+			return
+		}
+
+		if _, err = deps.DB.Organization(connection.Tenant); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, unknownSAMLOrganization)
+			return
+		}
+
+		domains := make([]string, 0, len(connection.Domains))
+		for _, domain := range connection.Domains {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); len(domain) > 0 {
+				domains = append(domains, domain)
+			}
+		}
+		if len(domains) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankSAMLDomains)
+			return
+		}
+		connection.Domains = domains
+
+		if len(connection.IdPMetadata) == 0 && len(params.MetadataURL) > 0 {
+			metadata, err := samlauth.FetchMetadata(params.MetadataURL)
+			if err != nil {
+				respondError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+
+			connection.IdPMetadata = string(metadata)
+		}
+		if len(connection.IdPMetadata) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankMetadata)
+			return
+		}
+
+		connection.IdPEntityID, err = samlauth.ParseMetadata([]byte(connection.IdPMetadata))
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if err = deps.DB.SaveSAMLConnection(connection); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, connection)
+	})))))
+}
+
+// ListSAMLConnections returns SAML connections of all tenants
+func ListSAMLConnections(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, getHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections, err := deps.DB.SAMLConnections()
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+		if connections == nil {
+			connections = []models.SAMLConnection{}
+		}
+
+		respond(w, http.StatusOK, map[string][]models.SAMLConnection{"connections": connections})
+	}))))
+}
+
+// DeleteSAMLConnection removes the SAML connection of the tenant
+func DeleteSAMLConnection(deps *Deps) http.Handler {
+	return logHandler(deps, internalHandler(deps, deleteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.FormValue("tenant")
+		if len(tenant) == 0 {
+			respondError(w, http.StatusUnprocessableEntity, blankTenant)
+			return
+		}
+
+		if err := deps.DB.DeleteSAMLConnection(tenant); err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}))))
+}
+
+// samlHandler responds with NotFound when the service provider key pair isn't configured
+func samlHandler(deps *Deps, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deps.SAML == nil {
+			respondError(w, http.StatusNotFound, samlDisabled)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// samlDomainAllowed reports whether the email belongs to one of domains of the connection
+func samlDomainAllowed(connection *models.SAMLConnection, email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	return contains(connection.Domains, strings.ToLower(email[at+1:]))
+}
+
+// syncSAMLMembership replaces roles of the user in the organization of the tenant with the asserted ones
+func syncSAMLMembership(deps *Deps, r *http.Request, connection *models.SAMLConnection, userID int64, roles []string) error {
+	var previous []string
+	if membership, err := deps.DB.Membership(connection.Tenant, userID); err == nil {
+		previous = membership.Roles
+	}
+
+	if err := deps.DB.SetMembership(connection.Tenant, userID, roles); err != nil {
+		return err
+	}
+
+	if !sameRoles(previous, roles) {
+		audit(deps, r, &models.AuditEvent{
+			SubjectID: userID,
+			Action:    auditMemberSet,
+			Outcome:   auditSuccess,
+			Metadata:  map[string]interface{}{"organization": connection.Tenant, "previous": previous, "roles": roles, "source": "saml:" + connection.Tenant},
+		})
+	}
+
+	return nil
+}
+
+// loadSAMLState returns the authentication request state and removes it so it can't be used twice
+func loadSAMLState(deps *Deps, id string) (*samlState, error) {
+	if len(id) == 0 {
+		return nil, invalidSAMLState
+	}
+
+	payload, err := deps.DB.GetCacheValue("saml_state:" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	if del, err := deps.DB.DeleteCache("saml_state:" + id); del == 0 || err != nil {
+		return nil, invalidSAMLState
+	}
+
+	var state samlState
+	if err = json.Unmarshal([]byte(payload), &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
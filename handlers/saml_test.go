@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/crewjam/saml"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+	"github.com/maxshend/tiny_goauth/samlauth"
+)
+
+var formHeaders = map[string]string{contentTypeHeader: "application/x-www-form-urlencoded"}
+
+// newSAMLEmail isn't registered yet
+const newSAMLEmail = "jane@acme.com"
+
+func setTestSAML(t *testing.T) *authtest.FakeSAMLIdP {
+	t.Helper()
+
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := authtest.NewFakeSAMLIdP(t)
+	testSAML = &samlauth.SP{Key: key, Certificate: authtest.GenerateCertificate(t, key), BaseURL: "https://sp.local"}
+	testSAMLConnection = &models.SAMLConnection{
+		Tenant:         "acme",
+		IdPMetadata:    idp.Metadata(t),
+		Domains:        []string{"acme.com"},
+		EmailAttribute: "email",
+		RoleAttribute:  "groups",
+		AttributeRoles: map[string][]string{"admins": {"admin"}},
+	}
+
+	t.Cleanup(func() {
+		testSAML, testSAMLConnection, testSAMLRequestID = nil, nil, ""
+	})
+
+	return idp
+}
+
+// samlResponseForm starts an authentication request and returns the signed identity provider response form
+func samlResponseForm(t *testing.T, idp *authtest.FakeSAMLIdP, email string) string {
+	t.Helper()
+
+	location, requestID, err := testSAML.AuthnRequestURL(testSAMLConnection, testSAMLRelayState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSAMLRequestID = requestID
+
+	metadata, err := testSAML.Metadata(testSAMLConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := &saml.Session{
+		NameID: "jane",
+		CustomAttributes: []saml.Attribute{
+			{Name: "email", Values: []saml.AttributeValue{{Value: email}}},
+			{Name: "groups", Values: []saml.AttributeValue{{Value: "admins"}}},
+		},
+	}
+
+	form := url.Values{}
+	form.Set("SAMLResponse", idp.Response(t, metadata, location, session))
+	form.Set("RelayState", testSAMLRelayState)
+
+	return form.Encode()
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	t.Run("returns NotFound when SAML isn't configured", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/saml/metadata?tenant=acme", SAMLMetadata, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusNotFound)
+	})
+
+	setTestSAML(t)
+
+	t.Run("returns NotFound for unknown tenant", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/saml/metadata?tenant=unknown", SAMLMetadata, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusNotFound)
+	})
+
+	t.Run("returns OK with service provider metadata", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/saml/metadata?tenant=acme", SAMLMetadata, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), "https://sp.local/saml/acs?tenant=acme") {
+			t.Errorf("expected ACS location, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestSAMLLogin(t *testing.T) {
+	setTestSAML(t)
+
+	t.Run("returns Found with identity provider location", func(t *testing.T) {
+		recorder := performRequest(t, "GET", "/saml/login?tenant=acme", SAMLLogin, nil, nil, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusFound)
+
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		if err != nil || location.Host != "idp.local" || len(location.Query().Get("SAMLRequest")) == 0 {
+			t.Errorf("got unexpected location %q", recorder.Header().Get("Location"))
+		}
+	})
+}
+
+func TestSAMLACS(t *testing.T) {
+	idp := setTestSAML(t)
+
+	externalApp := testServer()
+	defer externalApp.Close()
+	os.Setenv("API_HOST", externalApp.URL)
+
+	t.Run("returns Unauthorized with invalid relay state", func(t *testing.T) {
+		body := strings.NewReader("SAMLResponse=invalid&RelayState=invalid")
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, body, formHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Unauthorized with invalid response", func(t *testing.T) {
+		samlResponseForm(t, idp, newSAMLEmail)
+		body := strings.NewReader("SAMLResponse=invalid&RelayState=" + testSAMLRelayState)
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, body, formHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns Forbidden for email of another domain", func(t *testing.T) {
+		form := samlResponseForm(t, idp, "jane@globex.com")
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, strings.NewReader(form), formHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusForbidden)
+	})
+
+	t.Run("returns Conflict for existing account", func(t *testing.T) {
+		form := samlResponseForm(t, idp, "admin@acme.com")
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, strings.NewReader(form), formHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusConflict)
+	})
+
+	form := samlResponseForm(t, idp, newSAMLEmail)
+
+	t.Run("returns OK with tokens of the tenant", func(t *testing.T) {
+		key, err := authtest.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, strings.NewReader(form), formHeaders, key)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if claims := accessClaims(t, recorder.Body.Bytes(), key); claims.Tenant != "acme" {
+			t.Errorf("got unexpected claims %+v", claims)
+		}
+	})
+
+	t.Run("returns Unauthorized for replayed response", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/saml/acs?tenant=acme", SAMLACS, strings.NewReader(form), formHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+}
+
+func TestSaveSAMLConnection(t *testing.T) {
+	idp := authtest.NewFakeSAMLIdP(t)
+
+	t.Run("returns UnprocessableEntity with blank tenant", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"idp_metadata": "<xml/>"}`))
+		recorder := performRequest(t, "POST", "/internal/saml/connections", SaveSAMLConnection, body, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns UnprocessableEntity for tenant without organization", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"tenant": "initech", "domains": ["initech.com"], "idp_metadata": "<xml/>"}`))
+		recorder := performRequest(t, "POST", "/internal/saml/connections", SaveSAMLConnection, body, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns UnprocessableEntity with blank domains", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"tenant": "acme", "domains": [" "], "idp_metadata": "<xml/>"}`))
+		recorder := performRequest(t, "POST", "/internal/saml/connections", SaveSAMLConnection, body, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+
+		if !strings.Contains(recorder.Body.String(), blankSAMLDomains.Error()) {
+			t.Errorf("expected %q error, got %q", blankSAMLDomains, recorder.Body.String())
+		}
+	})
+
+	t.Run("returns UnprocessableEntity with invalid metadata", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"tenant": "acme", "domains": ["acme.com"], "idp_metadata": "<xml/>"}`))
+		recorder := performRequest(t, "POST", "/internal/saml/connections", SaveSAMLConnection, body, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK with identity provider entity ID", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"tenant": "acme", "domains": ["ACME.com"], "idp_metadata": ` + strconv.Quote(idp.Metadata(t)) + `}`))
+		recorder := performRequest(t, "POST", "/internal/saml/connections", SaveSAMLConnection, body, internalJSONHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), `"idp_entity_id":"https://idp.local/metadata"`) {
+			t.Errorf("expected entity ID, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestDeleteSAMLConnection(t *testing.T) {
+	setTestSAML(t)
+
+	t.Run("returns UnprocessableEntity for unknown tenant", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/saml/connections/delete?tenant=unknown", DeleteSAMLConnection, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("returns OK for existing tenant", func(t *testing.T) {
+		recorder := performRequest(t, "DELETE", "/internal/saml/connections/delete?tenant=acme", DeleteSAMLConnection, nil, internalHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+const passkeySessionTTL = 5 * time.Minute
+const invalidPasskeySession = handlerErr("Invalid WebAuthn session")
+const invalidPasskey = handlerErr("Invalid WebAuthn credential")
+
+type passkeySession struct {
+	Data   webauthn.SessionData `json:"data"`
+	UserID int64                `json:"user_id"`
+	MFAID  string               `json:"mfa_id,omitempty"`
+}
+
+type passkeyBeginParams struct {
+	Email    string `json:"email"`
+	MFAToken string `json:"mfa_token"`
+}
+
+type passkeyOptions struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+type passkeyTransports struct {
+	Response struct {
+		Transports []string `json:"transports"`
+	} `json:"response"`
+}
+
+// BeginPasskeyRegistration starts WebAuthn registration ceremony for the current user
+func BeginPasskeyRegistration(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		user, err := passkeyUser(deps, claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		exclusions := make([]protocol.CredentialDescriptor, len(user.Credentials))
+		for i, c := range user.Credentials {
+			exclusions[i] = protocol.CredentialDescriptor{Type: protocol.PublicKeyCredentialType, CredentialID: c.CredentialID}
+		}
+
+		residentKey := true
+		options, session, err := deps.WebAuthn.BeginRegistration(
+			user,
+			webauthn.WithExclusions(exclusions),
+			webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+				RequireResidentKey: &residentKey,
+				UserVerification:   protocol.VerificationPreferred,
+			}),
+		)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		id, err := storePasskeySession(deps, &passkeySession{Data: *session, UserID: user.User.ID})
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, &passkeyOptions{SessionID: id, Options: options})
+	})))))
+}
+
+// FinishPasskeyRegistration verifies attestation and stores a new passkey of the current user
+func FinishPasskeyRegistration(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(authenticatedHandler(deps, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r)
+		if !ok {
+			respondInvalidToken(w)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		session, err := loadPasskeySession(deps, r.FormValue("session_id"))
+		if err != nil || session.UserID != claims.UserID {
+			respondError(w, http.StatusUnprocessableEntity, invalidPasskeySession)
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidPasskey)
+			return
+		}
+
+		var transports passkeyTransports
+		json.Unmarshal(body, &transports)
+
+		user, err := passkeyUser(deps, claims.UserID)
+		if err != nil {
+			respondInvalidToken(w)
+			return
+		}
+
+		credential, err := deps.WebAuthn.CreateCredential(user, session.Data, parsed)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, invalidPasskey)
+			return
+		}
+
+		passkey := &models.WebAuthnCredential{
+			UserID:          user.User.ID,
+			CredentialID:    credential.ID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			AAGUID:          credential.Authenticator.AAGUID,
+			SignCount:       credential.Authenticator.SignCount,
+			Transports:      transports.Response.Transports,
+		}
+		if passkey.Transports == nil {
+			passkey.Transports = []string{}
+		}
+
+		if err = deps.DB.CreateWebAuthnCredential(passkey); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		respond(w, http.StatusOK, passkey)
+	})))))
+}
+
+// BeginPasskeyLogin starts WebAuthn assertion ceremony. The passkey can be used as a second factor
+// with MFA challenge token or as a primary login method with or without user email.
+func BeginPasskeyLogin(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params passkeyBeginParams
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+		dec := json.NewDecoder(r.Body)
+		err := dec.Decode(&params)
+		if err != nil {
+			respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		var options *protocol.CredentialAssertion
+		session := &passkeySession{}
+
+		switch {
+		case len(params.MFAToken) > 0:
+			claims, err := auth.ValidateLinkToken(params.MFAToken, mfaPurpose, deps.Keys.RefreshVerify)
+			if err != nil {
+				respondInvalidToken(w)
+				return
+			}
+
+			if _, err = deps.DB.GetCacheValue(mfaPurpose + ":" + claims.Id); err != nil {
+				respondInvalidToken(w)
+				return
+			}
+
+			user, err := passkeyUser(deps, claims.UserID)
+			if err != nil || len(user.Credentials) == 0 {
+				respondInvalidToken(w)
+				return
+			}
+
+			var data *webauthn.SessionData
+			options, data, err = deps.WebAuthn.BeginLogin(user)
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+
+			session.Data, session.UserID, session.MFAID = *data, user.User.ID, claims.Id
+		case len(params.Email) > 0:
+			found, err := deps.DB.UserByEmail(auth.NormalizeEmail(params.Email))
+			if err != nil {
+				respondInvalidToken(w)
+				return
+			}
+
+			user, err := passkeyUser(deps, found.ID)
+			if err != nil || len(user.Credentials) == 0 {
+				respondInvalidToken(w)
+				return
+			}
+
+			var data *webauthn.SessionData
+			options, data, err = deps.WebAuthn.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationRequired))
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+
+			session.Data, session.UserID = *data, user.User.ID
+		default:
+			// Discoverable credential login where the user is identified by the returned user handle
+			challenge, err := protocol.CreateChallenge()
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+
+			options = &protocol.CredentialAssertion{Response: protocol.PublicKeyCredentialRequestOptions{
+				Challenge:        challenge,
+				Timeout:          deps.WebAuthn.Config.Timeout,
+				RelyingPartyID:   deps.WebAuthn.Config.RPID,
+				UserVerification: protocol.VerificationRequired,
+			}}
+			session.Data = webauthn.SessionData{
+				Challenge:        base64.RawURLEncoding.EncodeToString(challenge),
+				UserVerification: protocol.VerificationRequired,
+			}
+		}
+
+		id, err := storePasskeySession(deps, session)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		respond(w, http.StatusOK, &passkeyOptions{SessionID: id, Options: options})
+	}))))
+}
+
+// FinishPasskeyLogin verifies WebAuthn assertion and returns access and refresh tokens
+func FinishPasskeyLogin(deps *Deps) http.Handler {
+	return logHandler(deps, jsonHandler(postHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := loadPasskeySession(deps, r.FormValue("session_id"))
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, invalidPasskeySession)
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialRequestResponseBody(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, invalidPasskey)
+			return
+		}
+
+		userID := session.UserID
+		if userID == 0 {
+			userID, err = auth.UserIDFromWebAuthn(parsed.Response.UserHandle)
+			if err != nil {
+				respondError(w, http.StatusUnauthorized, invalidPasskey)
+				return
+			}
+
+			session.Data.UserID = auth.WebAuthnUserID(userID)
+		}
+
+		user, err := passkeyUser(deps, userID)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, invalidPasskey)
+			return
+		}
+
+		credential, err := deps.WebAuthn.ValidateLogin(user, session.Data, parsed)
+		if err != nil || credential.Authenticator.CloneWarning {
+			respondError(w, http.StatusUnauthorized, invalidPasskey)
+			return
+		}
+
+		if err = deps.DB.UpdateWebAuthnSignCount(credential.ID, credential.Authenticator.SignCount); err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		if len(session.MFAID) > 0 {
+			del, err := deps.DB.DeleteCache(mfaPurpose + ":" + session.MFAID)
+			if del == 0 || err != nil {
+				respondInvalidToken(w)
+				return
+			}
+
+			login, err := assessLogin(deps, w, r, user.User)
+			if err != nil {
+				deps.Logger.RequestError(r, err)
+				respondInternalError(w)
+				return
+			}
+			recordLogin(deps, r, user.User, login)
+		}
+
+		userClaims, err := tokenClaims(deps, user.User, r.Header.Get(tenantHeader))
+		if err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := auth.Token(userClaims, deps.Keys)
+		if err != nil {
+			deps.Logger.RequestError(r, err)
+			respondInternalError(w)
+			return
+		}
+
+		err = saveTokenDetails(deps, user.User.ID, token)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		auditLoginSuccess(deps, r, user.User.ID, "passkey")
+		respond(w, http.StatusOK, token)
+	}))))
+}
+
+func passkeyUser(deps *Deps, userID int64) (*auth.PasskeyUser, error) {
+	user, err := deps.DB.UserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := deps.DB.WebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.PasskeyUser{User: user, Credentials: credentials}, nil
+}
+
+func storePasskeySession(deps *Deps, session *passkeySession) (string, error) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	if err = deps.DB.StoreCache("webauthn:"+id, payload, passkeySessionTTL); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// loadPasskeySession returns the ceremony session and removes it so it can't be used twice
+func loadPasskeySession(deps *Deps, id string) (*passkeySession, error) {
+	if len(id) == 0 {
+		return nil, invalidPasskeySession
+	}
+
+	payload, err := deps.DB.GetCacheValue("webauthn:" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	if del, err := deps.DB.DeleteCache("webauthn:" + id); del == 0 || err != nil {
+		return nil, invalidPasskeySession
+	}
+
+	var session passkeySession
+	if err = json.Unmarshal([]byte(payload), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/auth"
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestBeginPasskeyRegistration(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns Unauthorized without token", func(t *testing.T) {
+		h := map[string]string{contentTypeHeader: jsonContentType}
+		recorder := performRequest(t, "POST", "/webauthn/register/begin", BeginPasskeyRegistration, nil, h, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK with creation options", func(t *testing.T) {
+		recorder := performRequest(t, "POST", "/webauthn/register/begin", BeginPasskeyRegistration, nil, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+
+		if !strings.Contains(recorder.Body.String(), `"session_id"`) {
+			t.Errorf("expected session ID, got %q", recorder.Body.String())
+		}
+	})
+}
+
+func TestFinishPasskeyRegistration(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns UnprocessableEntity with invalid session", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{}`))
+		recorder := performRequest(t, "POST", "/webauthn/register/finish?session_id=invalid", FinishPasskeyRegistration, body, authHeaders(t, privateKey, 1), privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestBeginPasskeyLogin(t *testing.T) {
+	privateKey, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns Unauthorized for user without passkeys", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "test@mail.com"}`))
+		recorder := performRequest(t, "POST", "/webauthn/login/begin", BeginPasskeyLogin, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+
+	t.Run("returns OK for user with passkeys", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"email": "mfa@mail.com"}`))
+		recorder := performRequest(t, "POST", "/webauthn/login/begin", BeginPasskeyLogin, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns OK with MFA challenge token", func(t *testing.T) {
+		challenge, _, _ := auth.LinkToken(mfaTestUser.ID, mfaPurpose, "", time.Minute, privateKey)
+		body := bytes.NewBuffer([]byte(`{"mfa_token": "` + challenge + `"}`))
+		recorder := performRequest(t, "POST", "/webauthn/login/begin", BeginPasskeyLogin, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+
+	t.Run("returns OK for discoverable login", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{}`))
+		recorder := performRequest(t, "POST", "/webauthn/login/begin", BeginPasskeyLogin, body, jsonHeaders, privateKey)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusOK)
+	})
+}
+
+func TestFinishPasskeyLogin(t *testing.T) {
+	t.Run("returns Unauthorized with invalid session", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{}`))
+		recorder := performRequest(t, "POST", "/webauthn/login/finish?session_id=invalid", FinishPasskeyLogin, body, jsonHeaders, nil)
+
+		authtest.AssertStatusCode(t, recorder, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,285 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Directory represents an LDAP or Active Directory server used to authenticate users of the email domains
+type Directory struct {
+	Name               string   `json:"name"`
+	Domains            []string `json:"domains"`
+	URL                string   `json:"url"`
+	StartTLS           bool     `json:"start_tls"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+	BindDN             string   `json:"bind_dn"`
+	BindPassword       string   `json:"bind_password"`
+	BaseDN             string   `json:"base_dn"`
+	// UserFilter is a search filter where %s is replaced with the escaped user email
+	UserFilter     string `json:"user_filter"`
+	EmailAttribute string `json:"email_attribute"`
+	GroupAttribute string `json:"group_attribute"`
+	// GroupRoles maps group DNs to local role names
+	GroupRoles map[string][]string `json:"group_roles"`
+	// DefaultRoles are assigned to every user of the directory
+	DefaultRoles []string `json:"default_roles"`
+
+	groups map[string][]string
+}
+
+// Registry contains configured directories indexed by email domain
+type Registry map[string]*Directory
+
+// Entry represents an authenticated directory user
+type Entry struct {
+	DN     string
+	Email  string
+	Groups []string
+}
+
+type ldapErr string
+
+func (e ldapErr) Error() string { return string(e) }
+
+// ErrInvalidCredentials is returned when the user isn't found or the password doesn't match
+const ErrInvalidCredentials = ldapErr("Invalid directory credentials")
+
+const (
+	errAmbiguousUser   = ldapErr("Directory search returned multiple users")
+	errMissingSettings = ldapErr("Directory URL and base DN are required")
+)
+
+const defaultUserFilter = "(mail=%s)"
+const defaultEmailAttribute = "mail"
+const defaultGroupAttribute = "memberOf"
+const requestTimeout = 10 * time.Second
+
+// Load loads directories from the JSON file specified in the environment
+func Load() (Registry, error) {
+	path := os.Getenv("LDAP_DIRECTORIES_PATH")
+	if len(path) == 0 {
+		return Registry{}, nil
+	}
+
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var directories []*Directory
+	if err = json.Unmarshal(c, &directories); err != nil {
+		return nil, err
+	}
+
+	registry := make(Registry)
+	for _, d := range directories {
+		if err = d.Init(); err != nil {
+			return nil, err
+		}
+
+		for _, domain := range d.Domains {
+			registry[strings.ToLower(domain)] = d
+		}
+	}
+
+	return registry, nil
+}
+
+// ForEmail returns a directory responsible for the domain of the email
+func (r Registry) ForEmail(email string) (*Directory, bool) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, false
+	}
+
+	d, ok := r[strings.ToLower(email[at+1:])]
+
+	return d, ok
+}
+
+// Init validates settings and parses group DNs of the role mapping
+func (d *Directory) Init() error {
+	if len(d.UserFilter) == 0 {
+		d.UserFilter = defaultUserFilter
+	}
+	if len(d.EmailAttribute) == 0 {
+		d.EmailAttribute = defaultEmailAttribute
+	}
+	if len(d.GroupAttribute) == 0 {
+		d.GroupAttribute = defaultGroupAttribute
+	}
+	if len(d.URL) == 0 || len(d.BaseDN) == 0 {
+		return errMissingSettings
+	}
+
+	d.groups = make(map[string][]string)
+	for group, roles := range d.GroupRoles {
+		dn, err := normalizeDN(group)
+		if err != nil {
+			return fmt.Errorf("Invalid group DN %q: %v", group, err)
+		}
+
+		d.groups[dn] = append(d.groups[dn], roles...)
+	}
+
+	return nil
+}
+
+// Authenticate finds the user by email with the service account and binds as the user with the password
+func (d *Directory) Authenticate(email, password string) (*Entry, error) {
+	// An empty password would be treated by the server as a successful unauthenticated bind
+	if len(email) == 0 || len(password) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if len(d.BindDN) > 0 {
+		if err = conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(requestTimeout.Seconds()), false,
+		fmt.Sprintf(d.UserFilter, ldap.EscapeFilter(email)),
+		[]string{d.EmailAttribute, d.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+	default:
+		return nil, errAmbiguousUser
+	}
+
+	found := result.Entries[0]
+	if err = conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, err
+	}
+
+	entry := &Entry{
+		DN:     found.DN,
+		Email:  found.GetEqualFoldAttributeValue(d.EmailAttribute),
+		Groups: found.GetEqualFoldAttributeValues(d.GroupAttribute),
+	}
+	if len(entry.Email) == 0 {
+		entry.Email = email
+	}
+
+	return entry, nil
+}
+
+// Roles maps groups of the entry to local role names
+func (d *Directory) Roles(entry *Entry) []string {
+	roles := make([]string, 0, len(d.DefaultRoles))
+	seen := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				roles = append(roles, name)
+			}
+		}
+	}
+
+	add(d.DefaultRoles)
+	for _, group := range entry.Groups {
+		dn, err := normalizeDN(group)
+		if err != nil {
+			continue
+		}
+
+		add(d.groups[dn])
+	}
+
+	return roles
+}
+
+// ManagedRoles returns all roles the directory grants so that roles granted otherwise are kept on synchronization
+func (d *Directory) ManagedRoles() []string {
+	roles := append([]string{}, d.DefaultRoles...)
+	for _, names := range d.GroupRoles {
+		roles = append(roles, names...)
+	}
+
+	return roles
+}
+
+// normalizeDN returns the DN in a canonical form so that DNs differing only in case and spacing match
+func normalizeDN(s string) (string, error) {
+	dn, err := ldap.ParseDN(s)
+	if err != nil {
+		return "", err
+	}
+
+	rdns := make([]string, len(dn.RDNs))
+	for i, rdn := range dn.RDNs {
+		attrs := make([]string, len(rdn.Attributes))
+		for j, attr := range rdn.Attributes {
+			attrs[j] = strings.ToLower(attr.Type) + "=" + strings.ToLower(attr.Value)
+		}
+		rdns[i] = strings.Join(attrs, "+")
+	}
+
+	return strings.Join(rdns, ","), nil
+}
+
+// tlsConfig verifies the server certificate against the host of the directory URL.
+// StartTLS doesn't derive the server name from the connection so it has to be set explicitly.
+func (d *Directory) tlsConfig() (*tls.Config, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: d.InsecureSkipVerify}, nil
+}
+
+func (d *Directory) dial() (*ldap.Conn, error) {
+	tlsConfig, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(
+		d.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: requestTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(requestTimeout)
+
+	if d.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,148 @@
+package ldapauth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+const serviceDN = "cn=service,ou=apps,dc=corp,dc=local"
+const staffDN = "cn=Jane Doe,ou=staff,dc=corp,dc=local"
+
+func testDirectory(t *testing.T) (*Directory, *authtest.FakeLDAP) {
+	t.Helper()
+
+	server := authtest.NewFakeLDAP(t,
+		authtest.LDAPEntry{DN: serviceDN, Password: "service"},
+		authtest.LDAPEntry{
+			DN:       staffDN,
+			Password: "secret",
+			Attributes: map[string][]string{
+				"objectClass": {"user"},
+				"mail":        {"jane@corp.local"},
+				"memberOf":    {"CN=Admins,OU=Groups,DC=corp,DC=local", "cn=unknown,dc=corp,dc=local"},
+			},
+		},
+		authtest.LDAPEntry{DN: "cn=twin1,dc=corp,dc=local", Attributes: map[string][]string{"mail": {"twin@corp.local"}}},
+		authtest.LDAPEntry{DN: "cn=twin2,dc=corp,dc=local", Attributes: map[string][]string{"mail": {"twin@corp.local"}}},
+	)
+
+	d := &Directory{
+		Name:         "corp",
+		Domains:      []string{"corp.local"},
+		URL:          server.URL,
+		BindDN:       serviceDN,
+		BindPassword: "service",
+		BaseDN:       "dc=corp,dc=local",
+		UserFilter:   "(&(objectClass=user)(mail=%s))",
+		GroupRoles:   map[string][]string{"cn=admins,ou=groups,dc=corp,dc=local": {"admin", "staff"}},
+		DefaultRoles: []string{"staff"},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return d, server
+}
+
+func TestAuthenticate(t *testing.T) {
+	d, server := testDirectory(t)
+	defer server.Close()
+
+	t.Run("returns entry with valid credentials", func(t *testing.T) {
+		entry, err := d.Authenticate("jane@corp.local", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.DN != staffDN || entry.Email != "jane@corp.local" || len(entry.Groups) != 2 {
+			t.Errorf("got unexpected entry %+v", entry)
+		}
+	})
+
+	t.Run("returns error with invalid password", func(t *testing.T) {
+		_, err := d.Authenticate("jane@corp.local", "invalid")
+
+		authtest.AssertError(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("returns error with empty password", func(t *testing.T) {
+		_, err := d.Authenticate("jane@corp.local", "")
+
+		authtest.AssertError(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("returns error for unknown user", func(t *testing.T) {
+		_, err := d.Authenticate("john@corp.local", "secret")
+
+		authtest.AssertError(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("escapes email in the filter", func(t *testing.T) {
+		_, err := d.Authenticate("*)(mail=*", "secret")
+
+		authtest.AssertError(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("returns error for ambiguous user", func(t *testing.T) {
+		d := *d
+		d.UserFilter = "(mail=%s)"
+		_, err := d.Authenticate("twin@corp.local", "secret")
+
+		authtest.AssertError(t, errAmbiguousUser, err)
+	})
+
+	t.Run("returns error with invalid service account", func(t *testing.T) {
+		d := *d
+		d.BindPassword = "invalid"
+		_, err := d.Authenticate("jane@corp.local", "secret")
+
+		if err == nil || err == ErrInvalidCredentials {
+			t.Errorf("expected bind error, got %v", err)
+		}
+	})
+}
+
+func TestRoles(t *testing.T) {
+	d, server := testDirectory(t)
+	defer server.Close()
+
+	roles := d.Roles(&Entry{Groups: []string{"CN=Admins,OU=Groups,DC=corp,DC=local", "cn=unknown,dc=corp,dc=local"}})
+	if !reflect.DeepEqual(roles, []string{"staff", "admin"}) {
+		t.Errorf("got unexpected roles %v", roles)
+	}
+}
+
+func TestManagedRoles(t *testing.T) {
+	d := &Directory{DefaultRoles: []string{"staff"}, GroupRoles: map[string][]string{"cn=admins": {"admin"}}}
+
+	if roles := d.ManagedRoles(); !reflect.DeepEqual(roles, []string{"staff", "admin"}) {
+		t.Errorf("got unexpected roles %v", roles)
+	}
+}
+
+func TestForEmail(t *testing.T) {
+	d := &Directory{Name: "corp"}
+	registry := Registry{"corp.local": d}
+
+	if found, ok := registry.ForEmail("Jane@CORP.local"); !ok || found != d {
+		t.Error("expected directory for the domain")
+	}
+	if _, ok := registry.ForEmail("jane@mail.com"); ok {
+		t.Error("expected no directory for other domains")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	d := &Directory{URL: "ldap://dc1.corp.local:389", StartTLS: true}
+
+	config, err := d.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ServerName != "dc1.corp.local" || config.InsecureSkipVerify {
+		t.Errorf("got unexpected TLS config %+v", config)
+	}
+}
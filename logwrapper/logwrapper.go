@@ -29,6 +29,9 @@ func New() *StandardLogger {
 var requestDetails = Event{0, "%d %s %s %s %s"}
 var requestError = Event{1, "%s %s %s %s caused %q"}
 var fatalError = Event{2, "Application stopped: %s"}
+var mailError = Event{3, "Mail delivery to %v failed on attempt %d: %q"}
+var securityEvent = Event{4, "Security event %s for user %d from %s: %s"}
+var tlsReloadError = Event{5, "TLS certificates reload failed: %q"}
 
 // RequestDetails logs an HTTP request details
 func (l *StandardLogger) RequestDetails(r *http.Request, code int) {
@@ -44,3 +47,18 @@ func (l *StandardLogger) RequestError(r *http.Request, err error) {
 func (l *StandardLogger) FatalError(err error) {
 	l.Fatalf(fatalError.message, err)
 }
+
+// MailError logs about failed mail delivery attempts
+func (l *StandardLogger) MailError(to []string, attempt int, err error) {
+	l.Errorf(mailError.message, to, attempt, err)
+}
+
+// SecurityEvent logs events users and administrators should be aware of like logins from new devices
+func (l *StandardLogger) SecurityEvent(r *http.Request, name string, userID int64, details string) {
+	l.Warnf(securityEvent.message, name, userID, r.RemoteAddr, details)
+}
+
+// TLSReloadError logs about failed reloads of TLS certificates
+func (l *StandardLogger) TLSReloadError(err error) {
+	l.Errorf(tlsReloadError.message, err)
+}
@@ -0,0 +1,99 @@
+package mailer
+
+// defaults contains built-in templates which can be overridden
+// by files in the templates directory
+var defaults = map[string]Template{
+	"magic_link": {
+		Text: `{{define "subject"}}Your login link{{end}}Hello,
+
+Use the link below to log in. It expires in {{.ExpiresIn}} and can be used only once.
+
+{{.URL}}
+
+If you didn't request this email, you can safely ignore it.
+`,
+		HTML: `<p>Hello,</p>
+<p>Use the link below to log in. It expires in {{.ExpiresIn}} and can be used only once.</p>
+<p><a href="{{.URL}}">Log in</a></p>
+<p>If you didn't request this email, you can safely ignore it.</p>
+`,
+	},
+	"invitation": {
+		Text: `{{define "subject"}}You have been invited{{end}}Hello,
+{{if .Inviter}}
+{{.Inviter}} has invited you to create an account.{{else}}
+You have been invited to create an account.{{end}} The invitation expires on {{.ExpiresAt}}.
+
+{{.URL}}
+
+If you weren't expecting this invitation, you can safely ignore this email.
+`,
+		HTML: `<p>Hello,</p>
+<p>{{if .Inviter}}{{.Inviter}} has invited you{{else}}You have been invited{{end}} to create an account. The invitation expires on {{.ExpiresAt}}.</p>
+<p><a href="{{.URL}}">Accept the invitation</a></p>
+<p>If you weren't expecting this invitation, you can safely ignore this email.</p>
+`,
+	},
+	"account_locked": {
+		Text: `{{define "subject"}}Your account has been locked{{end}}Hello,
+
+We noticed too many failed login attempts to your account from {{.IP}}. Logging in is blocked for {{.LockedFor}}.
+
+If it wasn't you, we recommend changing your password.
+`,
+		HTML: `<p>Hello,</p>
+<p>We noticed too many failed login attempts to your account from {{.IP}}. Logging in is blocked for {{.LockedFor}}.</p>
+<p>If it wasn't you, we recommend changing your password.</p>
+`,
+	},
+	"registration_attempt": {
+		Text: `{{define "subject"}}Someone tried to sign up with your email{{end}}Hello,
+
+Someone tried to create a new account with {{.Email}}, but you already have an account.
+
+If it was you, just log in with your existing account. Otherwise you can safely ignore this email.
+`,
+		HTML: `<p>Hello,</p>
+<p>Someone tried to create a new account with {{.Email}}, but you already have an account.</p>
+<p>If it was you, just log in with your existing account. Otherwise you can safely ignore this email.</p>
+`,
+	},
+	"login_code": {
+		Text: `{{define "subject"}}Your login code{{end}}Hello,
+
+We noticed a login to your account from a new device or location. Use the code below to confirm it. It expires in {{.ExpiresIn}}.
+
+{{.Code}}
+
+If it wasn't you, change your password right away.
+`,
+		HTML: `<p>Hello,</p>
+<p>We noticed a login to your account from a new device or location. Use the code below to confirm it. It expires in {{.ExpiresIn}}.</p>
+<p><strong>{{.Code}}</strong></p>
+<p>If it wasn't you, change your password right away.</p>
+`,
+	},
+	"new_login": {
+		Text: `{{define "subject"}}New login to your account{{end}}Hello,
+
+Your account was just used to log in from a new device or location.
+
+Device: {{.Device}}
+IP address: {{.IP}}{{if .Location}}
+Location: {{.Location}}{{end}}
+Time: {{.Time}}
+
+If it was you, you can ignore this email. Otherwise change your password right away.
+`,
+		HTML: `<p>Hello,</p>
+<p>Your account was just used to log in from a new device or location.</p>
+<ul>
+<li>Device: {{.Device}}</li>
+<li>IP address: {{.IP}}</li>{{if .Location}}
+<li>Location: {{.Location}}</li>{{end}}
+<li>Time: {{.Time}}</li>
+</ul>
+<p>If it was you, you can ignore this email. Otherwise change your password right away.</p>
+`,
+	},
+}
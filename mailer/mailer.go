@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/maxshend/tiny_goauth/logwrapper"
+)
+
+// Message represents a rendered email message
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Transport is the interface that wraps a method to deliver rendered messages
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Mailer is the interface that wraps a method to send templated emails
+type Mailer interface {
+	Deliver(to, template, locale string, data interface{}) error
+	Close()
+}
+
+type mailerErr string
+
+func (e mailerErr) Error() string { return string(e) }
+
+const (
+	errUnknownTransport = mailerErr("Unknown mailer transport")
+	errUnknownTemplate  = mailerErr("Unknown mail template")
+	errQueueClosed      = mailerErr("Mail queue is closed")
+	errQueueFull        = mailerErr("Mail queue is full")
+	errInvalidAddress   = mailerErr("Invalid mail address")
+)
+
+const defaultFrom = "no-reply@tiny-goauth.local"
+const defaultLocale = "en"
+const defaultDropPath = "tmp/mails"
+
+// New creates a queued mailer configured from the environment
+func New(logger *logwrapper.StandardLogger) (Mailer, error) {
+	transport, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := LoadTemplates(os.Getenv("MAILER_TEMPLATES_PATH"))
+	if err != nil {
+		return nil, err
+	}
+
+	from := os.Getenv("MAILER_FROM")
+	if len(from) == 0 {
+		from = defaultFrom
+	}
+
+	locale := os.Getenv("MAILER_DEFAULT_LOCALE")
+	if len(locale) == 0 {
+		locale = defaultLocale
+	}
+
+	opts := QueueOptions{From: from, Locale: locale}
+	opts.Workers, _ = strconv.Atoi(os.Getenv("MAILER_WORKERS"))
+	opts.MaxAttempts, _ = strconv.Atoi(os.Getenv("MAILER_MAX_ATTEMPTS"))
+
+	return NewQueue(transport, templates, logger, opts), nil
+}
+
+func newTransport() (Transport, error) {
+	switch os.Getenv("MAILER_TRANSPORT") {
+	case "smtp":
+		return &SMTPTransport{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	case "file":
+		path := os.Getenv("MAILER_DROP_PATH")
+		if len(path) == 0 {
+			path = defaultDropPath
+		}
+
+		return &FileTransport{Dir: path}, nil
+	case "", "log":
+		return &LogTransport{Writer: os.Stdout}, nil
+	}
+
+	return nil, errUnknownTransport
+}
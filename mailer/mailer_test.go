@@ -0,0 +1,189 @@
+package mailer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/logwrapper"
+)
+
+func TestTemplatesRender(t *testing.T) {
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates.Add("greeting", "", Template{
+		Text: `{{define "subject"}}Hello{{end}}Hi, {{.Name}}`,
+		HTML: `<p>Hi, {{.Name}}</p>`,
+	})
+	templates.Add("greeting", "de", Template{Text: `{{define "subject"}}Hallo{{end}}Hallo, {{.Name}}`})
+
+	t.Run("renders subject, text and html", func(t *testing.T) {
+		msg, err := templates.Render("greeting", "en", map[string]string{"Name": "<Bob>"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Subject != "Hello" || msg.Text != "Hi, <Bob>" || msg.HTML != "<p>Hi, &lt;Bob&gt;</p>" {
+			t.Errorf("got unexpected message %+v", msg)
+		}
+	})
+
+	t.Run("renders locale variant", func(t *testing.T) {
+		msg, err := templates.Render("greeting", "de", map[string]string{"Name": "Bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Subject != "Hallo" || msg.Text != "Hallo, Bob" {
+			t.Errorf("got unexpected message %+v", msg)
+		}
+	})
+
+	t.Run("returns error for unknown template", func(t *testing.T) {
+		_, err := templates.Render("unknown", "en", nil)
+
+		authtest.AssertError(t, errUnknownTemplate, err)
+	})
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "greeting.fr.txt"), []byte(`{{define "subject"}}Salut{{end}}Salut`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := templates.Render("greeting", "fr", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Subject != "Salut" {
+		t.Errorf("expected overridden template, got %+v", msg)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := &FileTransport{Dir: dir}
+	err = transport.Send(&Message{From: "from@mail.com", To: []string{"to@mail.com"}, Subject: "Test", Text: "text", HTML: "<p>html</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file got %d", len(files))
+	}
+
+	c, _ := ioutil.ReadFile(files[0])
+	if !strings.Contains(string(c), "To: to@mail.com") || !strings.Contains(string(c), "multipart/alternative") {
+		t.Errorf("got unexpected message %q", c)
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	t.Run("returns error for addresses injecting headers", func(t *testing.T) {
+		for _, msg := range []*Message{
+			{From: "from@mail.com\r\nBcc: spy@mail.com", To: []string{"to@mail.com"}},
+			{From: "from@mail.com", To: []string{"to@mail.com\nBcc: spy@mail.com"}},
+			{From: "from@mail.com", To: []string{"invalid"}},
+		} {
+			_, err := msg.Bytes()
+
+			authtest.AssertError(t, errInvalidAddress, err)
+		}
+	})
+
+	t.Run("accepts addresses with display names", func(t *testing.T) {
+		msg := &Message{From: "Tiny Goauth <from@mail.com>", To: []string{"to@mail.com"}, Text: "text"}
+		if _, err := msg.Bytes(); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
+
+func TestQueue(t *testing.T) {
+	templates, _ := LoadTemplates("")
+	templates.Add("greeting", "", Template{Text: `{{define "subject"}}Hello{{end}}Hi`})
+
+	logger := logwrapper.New()
+	logger.SetOutput(ioutil.Discard)
+
+	t.Run("delivers messages in background", func(t *testing.T) {
+		transport := &testTransport{}
+		q := NewQueue(transport, templates, logger, QueueOptions{})
+
+		if err := q.Deliver("to@mail.com", "greeting", "", nil); err != nil {
+			t.Fatal(err)
+		}
+		q.Close()
+
+		if len(transport.sent) != 1 || transport.sent[0].To[0] != "to@mail.com" {
+			t.Errorf("expected message to be sent, got %+v", transport.sent)
+		}
+	})
+
+	t.Run("retries failed deliveries", func(t *testing.T) {
+		transport := &testTransport{failures: 2}
+		q := NewQueue(transport, templates, logger, QueueOptions{Backoff: time.Millisecond})
+
+		q.Deliver("to@mail.com", "greeting", "", nil)
+		q.Close()
+
+		if transport.attempts != 3 || len(transport.sent) != 1 {
+			t.Errorf("expected 3 attempts and 1 sent message, got %d and %d", transport.attempts, len(transport.sent))
+		}
+	})
+
+	t.Run("returns error after close", func(t *testing.T) {
+		q := NewQueue(&testTransport{}, templates, logger, QueueOptions{})
+		q.Close()
+
+		authtest.AssertError(t, errQueueClosed, q.Deliver("to@mail.com", "greeting", "", nil))
+	})
+}
+
+type testTransport struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     []*Message
+}
+
+func (t *testTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts++
+	if t.attempts <= t.failures {
+		return errors.New("failed")
+	}
+
+	t.sent = append(t.sent, msg)
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/logwrapper"
+)
+
+// QueueOptions contains settings of the mail queue
+type QueueOptions struct {
+	From        string
+	Locale      string
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Size        int
+}
+
+// Queue renders templated emails and sends them in background with retries
+type Queue struct {
+	transport Transport
+	templates *Templates
+	logger    *logwrapper.StandardLogger
+	opts      QueueOptions
+	messages  chan *Message
+	mu        sync.RWMutex
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+const defaultWorkers = 2
+const defaultMaxAttempts = 5
+const defaultBackoff = time.Second
+const defaultQueueSize = 100
+
+// NewQueue creates a mail queue and starts its workers
+func NewQueue(transport Transport, templates *Templates, logger *logwrapper.StandardLogger, opts QueueOptions) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.Size <= 0 {
+		opts.Size = defaultQueueSize
+	}
+	if len(opts.From) == 0 {
+		opts.From = defaultFrom
+	}
+	if len(opts.Locale) == 0 {
+		opts.Locale = defaultLocale
+	}
+
+	q := &Queue{
+		transport: transport,
+		templates: templates,
+		logger:    logger,
+		opts:      opts,
+		messages:  make(chan *Message, opts.Size),
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// Deliver renders the template and enqueues the message for sending
+func (q *Queue) Deliver(to, template, locale string, data interface{}) error {
+	if len(locale) == 0 {
+		locale = q.opts.Locale
+	}
+
+	msg, err := q.templates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+
+	msg.From = q.opts.From
+	msg.To = []string{to}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Close stops accepting new messages and waits for enqueued ones to be processed
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for msg := range q.messages {
+		q.send(msg)
+	}
+}
+
+func (q *Queue) send(msg *Message) {
+	backoff := q.opts.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := q.transport.Send(msg)
+		if err == nil {
+			return
+		}
+
+		q.logger.MailError(msg.To, attempt, err)
+		if attempt >= q.opts.MaxAttempts {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
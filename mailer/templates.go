@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Template represents sources of a mail template.
+// Text source must define a "subject" template along with the body.
+type Template struct {
+	Text string
+	HTML string
+}
+
+// Templates contains mail templates indexed by name and locale
+type Templates struct {
+	sources map[string]Template
+}
+
+// LoadTemplates loads built-in templates and overrides them with files from dir.
+// Files are named <name>[.<locale>].txt and <name>[.<locale>].html
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{sources: make(map[string]Template)}
+	for k, v := range defaults {
+		t.sources[k] = v
+	}
+
+	if len(dir) == 0 {
+		return t, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(file.Name())
+		if ext != ".txt" && ext != ".html" {
+			continue
+		}
+
+		c, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.TrimSuffix(file.Name(), ext)
+		src := t.sources[key]
+		if ext == ".txt" {
+			src.Text = string(c)
+		} else {
+			src.HTML = string(c)
+		}
+		t.sources[key] = src
+	}
+
+	return t, nil
+}
+
+// Add registers template source with the name and optional locale
+func (t *Templates) Add(name, locale string, src Template) {
+	t.sources[templateKey(name, locale)] = src
+}
+
+// Render renders template with the name falling back to the default locale variant
+func (t *Templates) Render(name, locale string, data interface{}) (*Message, error) {
+	src, ok := t.sources[templateKey(name, locale)]
+	if !ok {
+		if src, ok = t.sources[name]; !ok {
+			return nil, errUnknownTemplate
+		}
+	}
+
+	text, err := template.New(name).Parse(src.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, body bytes.Buffer
+	if err = text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err = text.Execute(&body, data); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{Subject: strings.TrimSpace(subject.String()), Text: body.String()}
+
+	if len(src.HTML) == 0 {
+		return msg, nil
+	}
+
+	html, err := htmltemplate.New(name).Parse(src.HTML)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBody bytes.Buffer
+	if err = html.Execute(&htmlBody, data); err != nil {
+		return nil, err
+	}
+	msg.HTML = htmlBody.String()
+
+	return msg, nil
+}
+
+func templateKey(name, locale string) string {
+	if len(locale) == 0 {
+		return name
+	}
+
+	return name + "." + locale
+}
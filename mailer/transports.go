@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTPTransport delivers messages through an SMTP server
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// FileTransport drops messages as .eml files into a directory
+type FileTransport struct {
+	Dir string
+}
+
+// LogTransport writes messages to a writer
+type LogTransport struct {
+	Writer io.Writer
+}
+
+// Send delivers a message through an SMTP server
+func (t *SMTPTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var a smtp.Auth
+	if len(t.Username) > 0 {
+		a = smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(t.Host, t.Port), a, msg.From, msg.To, body)
+}
+
+// Send writes a message to a new file in the drop directory
+func (t *FileTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), uuid.New().String())
+
+	return ioutil.WriteFile(filepath.Join(t.Dir, name), body, 0644)
+}
+
+// Send writes a message to the writer
+func (t *LogTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(t.Writer, "%s\n", body)
+
+	return err
+}
+
+// Bytes returns a message encoded in MIME format.
+// Addresses are validated to keep them from injecting headers.
+func (m *Message) Bytes() ([]byte, error) {
+	for _, address := range append([]string{m.From}, m.To...) {
+		if err := validateAddress(address); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(m.HTML) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(m.Text)
+
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{contentTypeHeader: {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = io.WriteString(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func validateAddress(address string) error {
+	if strings.ContainsAny(address, "\r\n") {
+		return errInvalidAddress
+	}
+	if _, err := mail.ParseAddress(address); err != nil {
+		return errInvalidAddress
+	}
+
+	return nil
+}
+
+const contentTypeHeader = "Content-Type"
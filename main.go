@@ -7,8 +7,16 @@ import (
 
 	"github.com/maxshend/tiny_goauth/auth"
 	"github.com/maxshend/tiny_goauth/db"
+	"github.com/maxshend/tiny_goauth/geoip"
 	"github.com/maxshend/tiny_goauth/handlers"
+	"github.com/maxshend/tiny_goauth/ldapauth"
 	"github.com/maxshend/tiny_goauth/logwrapper"
+	"github.com/maxshend/tiny_goauth/mailer"
+	"github.com/maxshend/tiny_goauth/mtls"
+	"github.com/maxshend/tiny_goauth/oauth"
+	"github.com/maxshend/tiny_goauth/policy"
+	"github.com/maxshend/tiny_goauth/samlauth"
+	"github.com/maxshend/tiny_goauth/sms"
 	"github.com/maxshend/tiny_goauth/validations"
 )
 
@@ -38,12 +46,93 @@ func main() {
 		logger.FatalError(err)
 	}
 
+	relyingParty, err := auth.WebAuthn()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	providers, err := oauth.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	directories, err := ldapauth.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	rateLimits, err := handlers.LoadRateLimits()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	locator, err := geoip.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+	if locator != nil {
+		defer locator.Close()
+	}
+
+	rolePolicy, err := handlers.LoadRolePolicy()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	policyEngine, err := policy.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	internalAuth, err := handlers.LoadInternalAuth()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	tlsServer, err := mtls.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+	if tlsServer != nil {
+		done := make(chan struct{})
+		defer close(done)
+
+		go tlsServer.Watch(done, logger.TLSReloadError)
+	}
+
+	samlSP, err := samlauth.Load()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	smsSender, err := sms.New()
+	if err != nil {
+		logger.FatalError(err)
+	}
+
+	mail, err := mailer.New(logger)
+	if err != nil {
+		logger.FatalError(err)
+	}
+	defer mail.Close()
+
 	deps := &handlers.Deps{
-		DB:         dbInst,
-		Validator:  validator,
-		Translator: translator,
-		Logger:     logger,
-		Keys:       keys,
+		DB:           dbInst,
+		Validator:    validator,
+		Translator:   translator,
+		Logger:       logger,
+		Keys:         keys,
+		Mailer:       mail,
+		WebAuthn:     relyingParty,
+		SMS:          smsSender,
+		Providers:    providers,
+		Directories:  directories,
+		SAML:         samlSP,
+		RateLimits:   rateLimits,
+		GeoIP:        locator,
+		InternalAuth: internalAuth,
+		RolePolicy:   rolePolicy,
+		Policy:       policyEngine,
 	}
 	server := http.Server{
 		Addr:         ":" + os.Getenv("APP_PORT"),
@@ -54,11 +143,93 @@ func main() {
 
 	http.Handle("/email/register", handlers.EmailRegister(deps))
 	http.Handle("/email/login", handlers.EmailLogin(deps))
+	http.Handle("/email/login/mfa", handlers.EmailLoginMFA(deps))
+	http.Handle("/email/magic-link", handlers.MagicLink(deps))
+	http.Handle("/email/magic-link/consume", handlers.ConsumeMagicLink(deps))
+	http.Handle("/invitations/accept", handlers.AcceptInvitation(deps))
+	http.Handle("/phone/otp", handlers.PhoneOTP(deps))
+	http.Handle("/phone/login", handlers.PhoneLogin(deps))
+	http.Handle("/oauth/authorize", handlers.OAuthAuthorize(deps))
+	http.Handle("/oauth/callback", handlers.OAuthCallback(deps))
+	http.Handle("/identities", handlers.ListIdentities(deps))
+	http.Handle("/identities/link", handlers.LinkIdentity(deps))
+	http.Handle("/identities/delete", handlers.UnlinkIdentity(deps))
+	http.Handle("/saml/metadata", handlers.SAMLMetadata(deps))
+	http.Handle("/saml/login", handlers.SAMLLogin(deps))
+	http.Handle("/saml/acs", handlers.SAMLACS(deps))
 	http.Handle("/logout", handlers.Logout(deps))
 	http.Handle("/refresh", handlers.Refresh(deps))
-	http.Handle("/internal/users/delete", handlers.DeleteUser(deps))
-	http.Handle("/internal/roles", handlers.CreateRoles(deps))
-	http.Handle("/internal/roles/delete", handlers.DeleteRoles(deps))
+	http.Handle("/authorize", handlers.AuthorizeAction(deps))
+	http.Handle("/mfa/totp/enroll", handlers.EnrollTOTP(deps))
+	http.Handle("/mfa/totp/confirm", handlers.ConfirmTOTP(deps))
+	http.Handle("/mfa/totp", handlers.DisableTOTP(deps))
+	http.Handle("/webauthn/register/begin", handlers.BeginPasskeyRegistration(deps))
+	http.Handle("/webauthn/register/finish", handlers.FinishPasskeyRegistration(deps))
+	http.Handle("/webauthn/login/begin", handlers.BeginPasskeyLogin(deps))
+	http.Handle("/webauthn/login/finish", handlers.FinishPasskeyLogin(deps))
+	// The internal API is served on a separate listener when the admin port is specified
+	admin := http.DefaultServeMux
+	adminPort := os.Getenv("ADMIN_PORT")
+	if len(adminPort) > 0 {
+		admin = http.NewServeMux()
+	}
+
+	admin.Handle("/internal/users/delete", handlers.DeleteUser(deps))
+	admin.Handle("/internal/users/unlock", handlers.UnlockUser(deps))
+	admin.Handle("/internal/roles", handlers.CreateRoles(deps))
+	admin.Handle("/internal/roles/delete", handlers.DeleteRoles(deps))
+	admin.Handle("/internal/roles/parent", handlers.SetRoleParent(deps))
+	admin.Handle("/internal/roles/tree", handlers.RoleTree(deps))
+	admin.Handle("/internal/roles/members", handlers.RoleMembers(deps))
+	admin.Handle("/internal/roles/permissions", handlers.SetRolePermissions(deps))
+	admin.Handle("/internal/roles/permissions/list", handlers.RolePermissions(deps))
+	admin.Handle("/internal/permissions", handlers.SavePermission(deps))
+	admin.Handle("/internal/permissions/list", handlers.ListPermissions(deps))
+	admin.Handle("/internal/permissions/delete", handlers.DeletePermission(deps))
+	admin.Handle("/internal/users/authorize", handlers.Authorize(deps))
+	admin.Handle("/internal/users/roles", handlers.UserRoles(deps))
+	admin.Handle("/internal/users/roles/add", handlers.AddUserRoles(deps))
+	admin.Handle("/internal/users/roles/remove", handlers.RemoveUserRoles(deps))
+	admin.Handle("/internal/audit-events", handlers.AuditEvents(deps))
+	admin.Handle("/internal/saml/connections", handlers.SaveSAMLConnection(deps))
+	admin.Handle("/internal/saml/connections/list", handlers.ListSAMLConnections(deps))
+	admin.Handle("/internal/saml/connections/delete", handlers.DeleteSAMLConnection(deps))
+	admin.Handle("/internal/api-keys", handlers.CreateAPIKey(deps))
+	admin.Handle("/internal/api-keys/list", handlers.ListAPIKeys(deps))
+	admin.Handle("/internal/api-keys/rotate", handlers.RotateAPIKey(deps))
+	admin.Handle("/internal/api-keys/delete", handlers.RevokeAPIKey(deps))
+	admin.Handle("/internal/invitations", handlers.CreateInvitation(deps))
+	admin.Handle("/internal/invitations/list", handlers.ListInvitations(deps))
+	admin.Handle("/internal/invitations/delete", handlers.RevokeInvitation(deps))
+	admin.Handle("/internal/organizations", handlers.CreateOrganization(deps))
+	admin.Handle("/internal/organizations/list", handlers.ListOrganizations(deps))
+	admin.Handle("/internal/organizations/delete", handlers.DeleteOrganization(deps))
+	admin.Handle("/internal/organizations/members", handlers.SetMember(deps))
+	admin.Handle("/internal/organizations/members/list", handlers.ListMembers(deps))
+	admin.Handle("/internal/organizations/members/delete", handlers.RemoveMember(deps))
+
+	if len(adminPort) > 0 {
+		adminServer := http.Server{
+			Addr:         ":" + adminPort,
+			Handler:      admin,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		}
+
+		go func() {
+			logger.FatalError(listen(&adminServer, tlsServer, true))
+		}()
+	}
+
+	logger.FatalError(listen(&server, tlsServer, false))
+}
+
+// listen serves TLS when certificates are configured, client certificates are required only on the internal listener
+func listen(server *http.Server, tlsServer *mtls.Server, internal bool) error {
+	if tlsServer == nil {
+		return server.ListenAndServe()
+	}
 
-	logger.FatalError(server.ListenAndServe())
+	server.TLSConfig = tlsServer.TLSConfig(internal)
+	return server.ListenAndServeTLS("", "")
 }
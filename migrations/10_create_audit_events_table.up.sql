@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_events(
+  id BIGSERIAL PRIMARY KEY,
+  actor_id INT,
+  subject_id INT,
+  action VARCHAR(100) NOT NULL,
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent VARCHAR(512) NOT NULL DEFAULT '',
+  outcome VARCHAR(20) NOT NULL,
+  metadata JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS index_audit_events_on_actor_id ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS index_audit_events_on_subject_id ON audit_events (subject_id);
+CREATE INDEX IF NOT EXISTS index_audit_events_on_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS index_audit_events_on_created_at ON audit_events (created_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys(
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(100) NOT NULL,
+  prefix VARCHAR(16) UNIQUE NOT NULL,
+  secret_hash VARCHAR(64) NOT NULL,
+  scopes VARCHAR(50)[] NOT NULL DEFAULT '{}',
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS permissions(
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(100) UNIQUE NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions(
+  id SERIAL PRIMARY KEY,
+  role_id INT NOT NULL REFERENCES roles ON DELETE CASCADE,
+  permission_id INT NOT NULL REFERENCES permissions ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_role_permissions_on_role_id_and_permission_id ON role_permissions (role_id, permission_id);
+CREATE INDEX IF NOT EXISTS index_role_permissions_on_permission_id ON role_permissions (permission_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS index_roles_on_parent_id;
+ALTER TABLE roles DROP COLUMN IF EXISTS parent_id;
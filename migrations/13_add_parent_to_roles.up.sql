@@ -0,0 +1,3 @@
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES roles ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS index_roles_on_parent_id ON roles (parent_id);
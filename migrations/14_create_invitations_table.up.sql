@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS invitations(
+  id SERIAL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL,
+  roles VARCHAR(50)[] NOT NULL DEFAULT '{}',
+  inviter VARCHAR(255) NOT NULL DEFAULT '',
+  token_id VARCHAR(36) UNIQUE NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  accepted_at TIMESTAMP,
+  user_id INT REFERENCES users ON DELETE SET NULL,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS index_invitations_on_email ON invitations (email);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS organizations(
+  id SERIAL PRIMARY KEY,
+  slug VARCHAR(100) UNIQUE NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE TABLE IF NOT EXISTS organization_members(
+  id SERIAL PRIMARY KEY,
+  organization_id INT NOT NULL REFERENCES organizations ON DELETE CASCADE,
+  user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_organization_members_on_organization_id_and_user_id ON organization_members (organization_id, user_id);
+CREATE INDEX IF NOT EXISTS index_organization_members_on_user_id ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_roles(
+  id SERIAL PRIMARY KEY,
+  member_id INT NOT NULL REFERENCES organization_members ON DELETE CASCADE,
+  role_id INT NOT NULL REFERENCES roles ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_organization_roles_on_member_id_and_role_id ON organization_roles (member_id, role_id);
+CREATE INDEX IF NOT EXISTS index_organization_roles_on_role_id ON organization_roles (role_id);
@@ -0,0 +1 @@
+ALTER TABLE saml_connections DROP COLUMN IF EXISTS domains;
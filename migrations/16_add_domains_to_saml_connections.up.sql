@@ -0,0 +1 @@
+ALTER TABLE saml_connections ADD COLUMN IF NOT EXISTS domains VARCHAR(255)[] NOT NULL DEFAULT '{}';
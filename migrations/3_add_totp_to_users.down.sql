@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS index_recovery_codes_on_user_id_and_code_hash;
+DROP TABLE IF EXISTS recovery_codes CASCADE;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
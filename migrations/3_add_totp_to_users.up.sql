@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS recovery_codes(
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+  code_hash VARCHAR(64) NOT NULL,
+  used_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_recovery_codes_on_user_id_and_code_hash ON recovery_codes (user_id, code_hash);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS index_webauthn_credentials_on_user_id;
+DROP TABLE IF EXISTS webauthn_credentials CASCADE;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials(
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+  credential_id BYTEA UNIQUE NOT NULL,
+  public_key BYTEA NOT NULL,
+  attestation_type VARCHAR(50) NOT NULL DEFAULT '',
+  aaguid BYTEA,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  transports VARCHAR(20)[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc'),
+  last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS index_webauthn_credentials_on_user_id ON webauthn_credentials (user_id);
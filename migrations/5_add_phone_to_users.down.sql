@@ -0,0 +1,6 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_or_phone;
+DELETE FROM users WHERE email IS NULL;
+UPDATE users SET password = '' WHERE password IS NULL;
+ALTER TABLE users ALTER COLUMN password SET NOT NULL;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS index_identities_on_user_id;
+DROP INDEX IF EXISTS index_identities_on_provider_and_subject;
+DROP TABLE IF EXISTS identities CASCADE;
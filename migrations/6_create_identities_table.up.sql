@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS identities(
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+  provider VARCHAR(50) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  metadata JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_identities_on_provider_and_subject ON identities (provider, subject);
+CREATE INDEX IF NOT EXISTS index_identities_on_user_id ON identities (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS saml_connections(
+  id SERIAL PRIMARY KEY,
+  tenant VARCHAR(100) UNIQUE NOT NULL,
+  idp_entity_id VARCHAR(1024) NOT NULL,
+  idp_metadata TEXT NOT NULL,
+  email_attribute VARCHAR(255) NOT NULL DEFAULT '',
+  role_attribute VARCHAR(255) NOT NULL DEFAULT '',
+  attribute_roles JSONB NOT NULL DEFAULT '{}',
+  default_roles VARCHAR(50)[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
@@ -0,0 +1,25 @@
+-- Emails are normalized by the application before they are stored. Existing
+-- rows are normalized here except for IDNA conversion of domains, which has
+-- to be done by the application. Accounts whose emails collide after the
+-- normalization have to be merged manually before the migration can proceed.
+DO $$
+DECLARE
+  collisions TEXT;
+BEGIN
+  SELECT STRING_AGG(normalized || ' (user ids ' || ids || ')', '; ') INTO collisions FROM (
+    SELECT LOWER(NORMALIZE(TRIM(email), NFC)) AS normalized, STRING_AGG(id::TEXT, ', ' ORDER BY id) AS ids
+    FROM users
+    WHERE email IS NOT NULL
+    GROUP BY LOWER(NORMALIZE(TRIM(email), NFC))
+    HAVING COUNT(*) > 1
+  ) AS duplicates;
+
+  IF collisions IS NOT NULL THEN
+    RAISE EXCEPTION 'Users with colliding emails have to be merged before normalizing emails: %', collisions;
+  END IF;
+END $$;
+
+UPDATE users SET email = LOWER(NORMALIZE(TRIM(email), NFC))
+WHERE email IS NOT NULL AND email <> LOWER(NORMALIZE(TRIM(email), NFC));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_lower_email_key ON users (LOWER(email));
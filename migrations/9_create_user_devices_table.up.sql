@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_devices(
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users ON DELETE CASCADE,
+  device_hash VARCHAR(64) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  location VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc'),
+  last_seen_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'utc')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_user_devices_on_user_id_and_device_hash ON user_devices (user_id, device_hash);
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditEvent represents an authentication or administration event in audit_events table
+type AuditEvent struct {
+	ID        int64                  `db:"id" json:"id"`
+	ActorID   int64                  `db:"actor_id" json:"actor_id,omitempty"`
+	SubjectID int64                  `db:"subject_id" json:"subject_id,omitempty"`
+	Action    string                 `db:"action" json:"action"`
+	IP        string                 `db:"ip" json:"ip"`
+	UserAgent string                 `db:"user_agent" json:"user_agent"`
+	Outcome   string                 `db:"outcome" json:"outcome"`
+	Metadata  map[string]interface{} `db:"metadata" json:"metadata"`
+	CreatedAt time.Time              `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter contains conditions of audit events queries.
+// Zero values don't restrict the result.
+type AuditFilter struct {
+	ActorID   int64
+	SubjectID int64
+	Action    string
+	Outcome   string
+	IP        string
+	From      time.Time
+	To        time.Time
+	// Before is the cursor returning events older than the event with this ID
+	Before int64
+	Limit  int
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// Identity represents an external identity linked to a user in identities table
+type Identity struct {
+	ID        int64                  `db:"id" json:"id"`
+	UserID    int64                  `db:"user_id" json:"user_id"`
+	Provider  string                 `db:"provider" json:"provider"`
+	Subject   string                 `db:"subject" json:"subject"`
+	Metadata  map[string]interface{} `db:"metadata" json:"metadata"`
+	CreatedAt time.Time              `db:"created_at" json:"created_at"`
+}
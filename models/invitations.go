@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Invitation represents an invitation to register with pre-set roles in invitations table
+type Invitation struct {
+	ID      int64    `db:"id" json:"id"`
+	Email   string   `db:"email" json:"email"`
+	Roles   []string `db:"roles" json:"roles"`
+	Inviter string   `db:"inviter" json:"inviter"`
+	// TokenID identifies the signed invite token, the token itself isn't stored
+	TokenID    string     `db:"token_id" json:"-"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	AcceptedAt *time.Time `db:"accepted_at" json:"accepted_at"`
+	UserID     *int64     `db:"user_id" json:"user_id"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Pending reports whether the invitation can still be accepted
+func (i *Invitation) Pending(now time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
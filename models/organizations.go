@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Organization represents a tenant with its own members and their roles in organizations table
+type Organization struct {
+	ID        int64     `db:"id" json:"id"`
+	Slug      string    `db:"slug" json:"slug"`
+	Name      string    `db:"name" json:"name"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Membership represents roles of the user in the organization.
+// EffectiveRoles are assigned roles and all roles they inherit.
+type Membership struct {
+	Organization   string    `db:"organization" json:"organization"`
+	UserID         int64     `db:"user_id" json:"user_id"`
+	Roles          []string  `db:"roles" json:"roles"`
+	EffectiveRoles []string  `db:"effective_roles" json:"effective_roles"`
+	Permissions    []string  `db:"permissions" json:"permissions"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
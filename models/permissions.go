@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// Permission represents an operation roles can be granted in permissions table
+type Permission struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Role represents a role in roles table.
+// A role inherits all roles having it as the parent.
+type Role struct {
+	ID     int64  `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Parent string `db:"parent" json:"parent,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// SAMLConnection represents SAML identity provider settings of a tenant in saml_connections table
+type SAMLConnection struct {
+	ID          int64  `db:"id" json:"id"`
+	Tenant      string `db:"tenant" json:"tenant"`
+	IdPEntityID string `db:"idp_entity_id" json:"idp_entity_id"`
+	IdPMetadata string `db:"idp_metadata" json:"idp_metadata"`
+	// Domains are email domains the identity provider may assert users of
+	Domains []string `db:"domains" json:"domains"`
+	// EmailAttribute is the assertion attribute with user email, NameID is used when it's blank
+	EmailAttribute string `db:"email_attribute" json:"email_attribute"`
+	RoleAttribute  string `db:"role_attribute" json:"role_attribute"`
+	// AttributeRoles maps values of the role attribute to local role names
+	AttributeRoles map[string][]string `db:"attribute_roles" json:"attribute_roles"`
+	DefaultRoles   []string            `db:"default_roles" json:"default_roles"`
+	CreatedAt      time.Time           `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// UserDevice represents a device the user logged in from in user_devices table
+type UserDevice struct {
+	ID         int64     `db:"id" json:"id"`
+	UserID     int64     `db:"user_id" json:"user_id"`
+	DeviceHash string    `db:"device_hash" json:"-"`
+	UserAgent  string    `db:"user_agent" json:"user_agent"`
+	IP         string    `db:"ip" json:"ip"`
+	Location   string    `db:"location" json:"location"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"last_seen_at"`
+}
@@ -4,12 +4,27 @@ import (
 	"time"
 )
 
-// User represents data of a user in users table
+// User represents data of a user in users table.
+// EffectiveRoles are assigned roles and all roles they inherit.
 type User struct {
-	ID        int64                  `db:"id" json:"id"`
-	Email     string                 `db:"email" json:"email" validate:"required,email,unique_user"`
-	Password  string                 `db:"password" json:"password,omitempty" validate:"required,password"`
-	Payload   map[string]interface{} `json:"payload"`
-	Roles     []string               `db:"roles" json:"roles" validate:"roles"`
-	CreatedAt time.Time              `db:"created_at" json:"created_at"`
+	ID             int64                  `db:"id" json:"id"`
+	Email          string                 `db:"email" json:"email" validate:"required,email,unique_user"`
+	Password       string                 `db:"password" json:"password,omitempty" validate:"required,password"`
+	Phone          string                 `db:"phone" json:"phone,omitempty"`
+	Payload        map[string]interface{} `json:"payload"`
+	Roles          []string               `db:"roles" json:"roles" validate:"roles"`
+	EffectiveRoles []string               `db:"effective_roles" json:"-"`
+	Permissions    []string               `db:"permissions" json:"-"`
+	TOTPSecret     string                 `db:"totp_secret" json:"-"`
+	TOTPEnabled    bool                   `db:"totp_enabled" json:"-"`
+	CreatedAt      time.Time              `db:"created_at" json:"created_at"`
+}
+
+// DisplayName returns the email of the user or the phone if the user has no email
+func (u *User) DisplayName() string {
+	if len(u.Email) == 0 {
+		return u.Phone
+	}
+
+	return u.Email
 }
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// WebAuthnCredential represents data of a passkey in webauthn_credentials table
+type WebAuthnCredential struct {
+	ID              int64     `db:"id" json:"id"`
+	UserID          int64     `db:"user_id" json:"-"`
+	CredentialID    []byte    `db:"credential_id" json:"credential_id"`
+	PublicKey       []byte    `db:"public_key" json:"-"`
+	AttestationType string    `db:"attestation_type" json:"attestation_type"`
+	AAGUID          []byte    `db:"aaguid" json:"aaguid"`
+	SignCount       uint32    `db:"sign_count" json:"-"`
+	Transports      []string  `db:"transports" json:"transports"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+}
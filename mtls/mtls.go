@@ -0,0 +1,210 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Server serves TLS with the certificate and the client CA bundle reloaded when their files change
+type Server struct {
+	CertPath     string
+	KeyPath      string
+	ClientCAPath string
+	// RequireClientCert rejects connections to the internal listener without a valid client certificate.
+	// Otherwise and on the public listener client certificates are verified only when presented.
+	RequireClientCert bool
+
+	mu       sync.RWMutex
+	public   *tls.Config
+	internal *tls.Config
+	modified time.Time
+}
+
+// Permissions maps client certificate identities to allowed internal operations.
+// Identities are "cn:<common name>", "dns:<name>", "uri:<uri>" or "email:<address>",
+// operations are internal API paths or "*" for all of them.
+type Permissions map[string][]string
+
+type mtlsErr string
+
+func (e mtlsErr) Error() string { return string(e) }
+
+const errMissingClientCA = mtlsErr("Client CA bundle doesn't contain certificates")
+
+const reloadInterval = 30 * time.Second
+
+// Load loads TLS settings specified in the environment.
+// It returns nil when TLS isn't configured.
+func Load() (*Server, error) {
+	s := &Server{
+		CertPath:          os.Getenv("TLS_CERT_PATH"),
+		KeyPath:           os.Getenv("TLS_KEY_PATH"),
+		ClientCAPath:      os.Getenv("TLS_CLIENT_CA_PATH"),
+		RequireClientCert: os.Getenv("TLS_CLIENT_AUTH") == "require",
+	}
+	if len(s.CertPath) == 0 || len(s.KeyPath) == 0 {
+		return nil, nil
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload reads the certificate, the key and the client CA bundle
+// and builds separate configurations of the public and the internal listeners
+func (s *Server) Reload() error {
+	modified := s.lastModified()
+
+	cert, err := tls.LoadX509KeyPair(s.CertPath, s.KeyPath)
+	if err != nil {
+		return err
+	}
+
+	public := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	internal := public.Clone()
+
+	if len(s.ClientCAPath) > 0 {
+		pem, err := ioutil.ReadFile(s.ClientCAPath)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errMissingClientCA
+		}
+
+		// The public listener serves the internal API too when there is no separate listener
+		public.ClientCAs = pool
+		public.ClientAuth = tls.VerifyClientCertIfGiven
+
+		internal.ClientCAs = pool
+		internal.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.RequireClientCert {
+			internal.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	s.mu.Lock()
+	s.public = public
+	s.internal = internal
+	s.modified = modified
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Watch reloads files when their modification time changes until the channel is closed.
+// Failed reloads keep the previous configuration and are passed to the error handler.
+func (s *Server) Watch(done <-chan struct{}, onError func(error)) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.mu.RLock()
+			changed := s.lastModified().After(s.modified)
+			s.mu.RUnlock()
+
+			if changed {
+				if err := s.Reload(); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// TLSConfig returns the configuration of the listener serving the most recently loaded files.
+// Only the internal listener requires client certificates.
+func (s *Server) TLSConfig(internal bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+
+			if internal {
+				return s.internal, nil
+			}
+
+			return s.public, nil
+		},
+	}
+}
+
+func (s *Server) lastModified() time.Time {
+	var latest time.Time
+	for _, path := range []string{s.CertPath, s.KeyPath, s.ClientCAPath} {
+		if len(path) == 0 {
+			continue
+		}
+
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
+
+// LoadPermissions reads client certificate permissions from the JSON file
+func LoadPermissions(path string) (Permissions, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Permissions
+	if err = json.Unmarshal(c, &p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Allowed returns the identity of the certificate which is allowed to perform the operation
+func (p Permissions) Allowed(cert *x509.Certificate, operation string) (string, bool) {
+	for _, identity := range Identities(cert) {
+		for _, allowed := range p[identity] {
+			if allowed == "*" || allowed == operation {
+				return identity, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Identities returns the subject common name and subject alternative names of the certificate
+func Identities(cert *x509.Certificate) []string {
+	var identities []string
+	if len(cert.Subject.CommonName) > 0 {
+		identities = append(identities, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, "dns:"+name)
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, "uri:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, "email:"+email)
+	}
+
+	return identities
+}
@@ -0,0 +1,247 @@
+package mtls
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func writeCertificate(t *testing.T, dir, name string) {
+	t.Helper()
+
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, s *Server) string {
+	t.Helper()
+
+	config, err := s.TLSConfig(false).GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Subject.CommonName
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeCertificate(t, dir, "first")
+
+	defer os.Unsetenv("TLS_CERT_PATH")
+	defer os.Unsetenv("TLS_KEY_PATH")
+	defer os.Unsetenv("TLS_CLIENT_CA_PATH")
+	defer os.Unsetenv("TLS_CLIENT_AUTH")
+
+	t.Run("returns nil without certificate", func(t *testing.T) {
+		s, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s != nil {
+			t.Errorf("expected nil, got %+v", s)
+		}
+	})
+
+	os.Setenv("TLS_CERT_PATH", filepath.Join(dir, "cert.pem"))
+	os.Setenv("TLS_KEY_PATH", filepath.Join(dir, "key.pem"))
+
+	t.Run("doesn't verify client certificates without CA bundle", func(t *testing.T) {
+		s, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.public.ClientAuth != tls.NoClientCert || s.internal.ClientAuth != tls.NoClientCert || servedCommonName(t, s) != "first" {
+			t.Errorf("got unexpected configs %+v, %+v", s.public, s.internal)
+		}
+	})
+
+	os.Setenv("TLS_CLIENT_CA_PATH", filepath.Join(dir, "ca.pem"))
+
+	t.Run("verifies client certificates when given", func(t *testing.T) {
+		s, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, config := range []*tls.Config{s.public, s.internal} {
+			if config.ClientAuth != tls.VerifyClientCertIfGiven || config.ClientCAs == nil {
+				t.Errorf("got unexpected config %+v", config)
+			}
+		}
+	})
+
+	t.Run("requires client certificates only on the internal listener", func(t *testing.T) {
+		os.Setenv("TLS_CLIENT_AUTH", "require")
+
+		s, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		internal, err := s.TLSConfig(true).GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := s.TLSConfig(false).GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if internal.ClientAuth != tls.RequireAndVerifyClientCert || public.ClientAuth != tls.VerifyClientCertIfGiven {
+			t.Errorf("got unexpected client auth %v and %v", internal.ClientAuth, public.ClientAuth)
+		}
+	})
+
+	t.Run("returns error with invalid CA bundle", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.pem")
+		if err := ioutil.WriteFile(invalid, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("TLS_CLIENT_CA_PATH", invalid)
+		defer os.Setenv("TLS_CLIENT_CA_PATH", filepath.Join(dir, "ca.pem"))
+
+		_, err := Load()
+
+		authtest.AssertError(t, errMissingClientCA, err)
+	})
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeCertificate(t, dir, "first")
+
+	s := &Server{CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}
+	if err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	config := s.TLSConfig(true)
+
+	t.Run("serves new certificate", func(t *testing.T) {
+		writeCertificate(t, dir, "second")
+
+		if err := s.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		if name := servedCommonName(t, s); name != "second" {
+			t.Errorf("expected second certificate, got %q", name)
+		}
+		if _, err := config.GetConfigForClient(&tls.ClientHelloInfo{}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("keeps previous certificate when files are invalid", func(t *testing.T) {
+		if err := ioutil.WriteFile(s.KeyPath, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Reload(); err == nil {
+			t.Error("expected error")
+		}
+
+		if name := servedCommonName(t, s); name != "second" {
+			t.Errorf("expected second certificate, got %q", name)
+		}
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://corp.local/billing")
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffe}}
+	ops := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}, DNSNames: []string{"ops.corp.local"}}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}, EmailAddresses: []string{"dev@corp.local"}}
+
+	p := Permissions{
+		"uri:spiffe://corp.local/billing": {"/internal/users/delete"},
+		"dns:ops.corp.local":              {"*"},
+	}
+
+	t.Run("returns identities of certificate", func(t *testing.T) {
+		want := []string{"cn:unknown", "email:dev@corp.local"}
+
+		if got := Identities(unknown); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("allows mapped operations", func(t *testing.T) {
+		identity, ok := p.Allowed(billing, "/internal/users/delete")
+
+		if !ok || identity != "uri:spiffe://corp.local/billing" {
+			t.Errorf("expected allowed operation, got %q", identity)
+		}
+	})
+
+	t.Run("allows all operations with wildcard", func(t *testing.T) {
+		if _, ok := p.Allowed(ops, "/internal/roles"); !ok {
+			t.Error("expected allowed operation")
+		}
+	})
+
+	t.Run("denies other operations", func(t *testing.T) {
+		if _, ok := p.Allowed(billing, "/internal/roles"); ok {
+			t.Error("expected denied operation")
+		}
+		if _, ok := p.Allowed(unknown, "/internal/roles"); ok {
+			t.Error("expected denied operation")
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const keysRefreshInterval = time.Hour
+
+type keySet struct {
+	url       string
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// key returns a public key by its ID refreshing the key set when the key is unknown
+func (s *keySet) key(kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok && time.Since(s.fetchedAt) < keysRefreshInterval {
+		return key, nil
+	}
+
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, errInvalidIDToken
+	}
+
+	return key, nil
+}
+
+func (s *keySet) fetch() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errInvalidResponse
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return err
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	return nil
+}
+
+func (p *Provider) validateIDToken(tokenString, nonce string) (map[string]interface{}, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		m, ok := token.Method.(*jwt.SigningMethodRSA)
+		if !ok || m.Alg() != "RS256" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		return p.keys.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errInvalidIDToken
+	}
+
+	if len(p.Issuer) > 0 && !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errInvalidIDToken
+	}
+	if !hasAudience(claims, p.ClientID) {
+		return nil, errInvalidIDToken
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errInvalidIDToken
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// hasAudience checks the aud claim which can be a single string or an array of strings
+func hasAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, v := range aud {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,281 @@
+package oauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider represents an upstream OAuth2 or OpenID Connect identity provider
+type Provider struct {
+	Name         string   `json:"name"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Issuer       string   `json:"issuer"`
+	AuthURL      string   `json:"auth_url"`
+	TokenURL     string   `json:"token_url"`
+	UserInfoURL  string   `json:"userinfo_url"`
+	JWKSURL      string   `json:"jwks_url"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	// TrustEmail marks emails returned without email_verified claim as verified
+	TrustEmail bool `json:"trust_email"`
+
+	client *http.Client
+	keys   *keySet
+}
+
+// Registry contains configured providers indexed by name
+type Registry map[string]*Provider
+
+// Tokens represents a token endpoint response
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// Identity represents a user identity asserted by a provider
+type Identity struct {
+	Provider      string                 `json:"provider"`
+	Subject       string                 `json:"sub"`
+	Email         string                 `json:"email"`
+	EmailVerified bool                   `json:"email_verified"`
+	Name          string                 `json:"name"`
+	Claims        map[string]interface{} `json:"-"`
+}
+
+type oauthErr string
+
+func (e oauthErr) Error() string { return string(e) }
+
+const (
+	errUnknownProvider  = oauthErr("Unknown identity provider")
+	errInvalidResponse  = oauthErr("Identity provider returned invalid response")
+	errInvalidIDToken   = oauthErr("Invalid ID token")
+	errMissingSubject   = oauthErr("Identity provider didn't return a subject")
+	errMissingEndpoints = oauthErr("Identity provider endpoints are not configured")
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+const requestTimeout = 10 * time.Second
+
+// Load loads providers from the JSON file specified in the environment
+func Load() (Registry, error) {
+	path := os.Getenv("OAUTH_PROVIDERS_PATH")
+	if len(path) == 0 {
+		return Registry{}, nil
+	}
+
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []*Provider
+	if err = json.Unmarshal(c, &providers); err != nil {
+		return nil, err
+	}
+
+	registry := make(Registry)
+	for _, p := range providers {
+		if err = p.Init(nil); err != nil {
+			return nil, err
+		}
+
+		registry[p.Name] = p
+	}
+
+	return registry, nil
+}
+
+// Get returns a provider by its name
+func (r Registry) Get(name string) (*Provider, error) {
+	p, ok := r[name]
+	if !ok {
+		return nil, errUnknownProvider
+	}
+
+	return p, nil
+}
+
+// Init discovers provider endpoints from the issuer when they aren't configured explicitly
+func (p *Provider) Init(client *http.Client) error {
+	p.client = client
+	if p.client == nil {
+		p.client = &http.Client{Timeout: requestTimeout}
+	}
+
+	if len(p.Issuer) > 0 && (len(p.AuthURL) == 0 || len(p.TokenURL) == 0) {
+		var discovery struct {
+			AuthURL     string `json:"authorization_endpoint"`
+			TokenURL    string `json:"token_endpoint"`
+			UserInfoURL string `json:"userinfo_endpoint"`
+			JWKSURL     string `json:"jwks_uri"`
+		}
+
+		if err := p.getJSON(strings.TrimSuffix(p.Issuer, "/")+discoveryPath, "", &discovery); err != nil {
+			return err
+		}
+
+		p.AuthURL = firstNonEmpty(p.AuthURL, discovery.AuthURL)
+		p.TokenURL = firstNonEmpty(p.TokenURL, discovery.TokenURL)
+		p.UserInfoURL = firstNonEmpty(p.UserInfoURL, discovery.UserInfoURL)
+		p.JWKSURL = firstNonEmpty(p.JWKSURL, discovery.JWKSURL)
+	}
+
+	if len(p.AuthURL) == 0 || len(p.TokenURL) == 0 {
+		return errMissingEndpoints
+	}
+
+	if len(p.JWKSURL) > 0 {
+		p.keys = &keySet{url: p.JWKSURL, client: p.client}
+	}
+
+	return nil
+}
+
+// AuthCodeURL returns URL of the provider authorization endpoint
+func (p *Provider) AuthCodeURL(state, nonce, redirectURL string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", redirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	if len(nonce) > 0 {
+		params.Set("nonce", nonce)
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.AuthURL + sep + params.Encode()
+}
+
+// Exchange exchanges authorization code for provider tokens
+func (p *Provider) Exchange(code, redirectURL string) (*Tokens, error) {
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", code)
+	params.Set("redirect_uri", redirectURL)
+	params.Set("client_id", p.ClientID)
+	params.Set("client_secret", p.ClientSecret)
+
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokens Tokens
+	if err = p.doJSON(req, &tokens); err != nil {
+		return nil, err
+	}
+
+	if len(tokens.AccessToken) == 0 && len(tokens.IDToken) == 0 {
+		return nil, errInvalidResponse
+	}
+
+	return &tokens, nil
+}
+
+// Identity validates ID token or requests userinfo endpoint to get the user identity
+func (p *Provider) Identity(tokens *Tokens, nonce string) (*Identity, error) {
+	var claims map[string]interface{}
+	var err error
+
+	switch {
+	case len(tokens.IDToken) > 0 && p.keys != nil:
+		claims, err = p.validateIDToken(tokens.IDToken, nonce)
+	case len(p.UserInfoURL) > 0:
+		err = p.getJSON(p.UserInfoURL, tokens.AccessToken, &claims)
+	default:
+		err = errMissingEndpoints
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Provider: p.Name, Claims: claims}
+	identity.Subject = claimString(claims, "sub")
+	if len(identity.Subject) == 0 {
+		// Plain OAuth2 providers such as GitHub return a numeric id instead of the sub claim
+		identity.Subject = claimString(claims, "id")
+	}
+	if len(identity.Subject) == 0 {
+		return nil, errMissingSubject
+	}
+
+	identity.Email = claimString(claims, "email")
+	identity.Name = claimString(claims, "name")
+
+	verified, found := claims["email_verified"]
+	switch v := verified.(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	if !found {
+		identity.EmailVerified = p.TrustEmail
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) getJSON(url, accessToken string, result interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return p.doJSON(req, result)
+}
+
+func (p *Provider) doJSON(req *http.Request, result interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errInvalidResponse
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,130 @@
+package oauth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/maxshend/tiny_goauth/authtest"
+)
+
+func TestProviderInit(t *testing.T) {
+	idp := authtest.NewFakeIdP(t, "client")
+	defer idp.Close()
+
+	t.Run("discovers endpoints from the issuer", func(t *testing.T) {
+		p := &Provider{Name: "test", ClientID: "client", Issuer: idp.URL}
+		if err := p.Init(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.TokenURL != idp.URL+"/token" || p.JWKSURL != idp.URL+"/jwks" {
+			t.Errorf("got unexpected endpoints %+v", p)
+		}
+	})
+
+	t.Run("returns error without endpoints", func(t *testing.T) {
+		p := &Provider{Name: "test"}
+
+		authtest.AssertError(t, errMissingEndpoints, p.Init(nil))
+	})
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := &Provider{ClientID: "client", AuthURL: "https://idp.local/authorize", Scopes: []string{"openid", "email"}}
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", "https://app.local/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("state") != "state" || q.Get("nonce") != "nonce" || q.Get("scope") != "openid email" {
+		t.Errorf("got unexpected URL %q", u)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	idp := authtest.NewFakeIdP(t, "client")
+	defer idp.Close()
+	idp.Claims["sub"] = "123"
+	idp.Claims["email"] = "user@mail.com"
+	idp.Claims["email_verified"] = true
+	idp.Claims["nonce"] = "nonce"
+
+	p := &Provider{Name: "test", ClientID: "client", Issuer: idp.URL}
+	if err := p.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("validates ID token", func(t *testing.T) {
+		tokens, err := p.Exchange("valid", "https://app.local/callback")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identity, err := p.Identity(tokens, "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identity.Subject != "123" || identity.Email != "user@mail.com" || !identity.EmailVerified {
+			t.Errorf("got unexpected identity %+v", identity)
+		}
+	})
+
+	t.Run("returns error for another nonce", func(t *testing.T) {
+		tokens, _ := p.Exchange("valid", "https://app.local/callback")
+		_, err := p.Identity(tokens, "invalid")
+
+		authtest.AssertError(t, errInvalidIDToken, err)
+	})
+
+	t.Run("returns error for another audience", func(t *testing.T) {
+		other := &Provider{Name: "test", ClientID: "other", Issuer: idp.URL}
+		other.Init(nil)
+		tokens, _ := p.Exchange("valid", "https://app.local/callback")
+		_, err := other.Identity(tokens, "nonce")
+
+		authtest.AssertError(t, errInvalidIDToken, err)
+	})
+
+	t.Run("accepts array audience with the client", func(t *testing.T) {
+		idp.Claims["aud"] = []interface{}{"other", "client"}
+		defer delete(idp.Claims, "aud")
+
+		tokens, _ := p.Exchange("valid", "https://app.local/callback")
+		if _, err := p.Identity(tokens, "nonce"); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("returns error for array audience without the client", func(t *testing.T) {
+		idp.Claims["aud"] = []interface{}{"other"}
+		defer delete(idp.Claims, "aud")
+
+		tokens, _ := p.Exchange("valid", "https://app.local/callback")
+		_, err := p.Identity(tokens, "nonce")
+
+		authtest.AssertError(t, errInvalidIDToken, err)
+	})
+
+	t.Run("returns error for invalid code", func(t *testing.T) {
+		_, err := p.Exchange("invalid", "https://app.local/callback")
+
+		authtest.AssertError(t, errInvalidResponse, err)
+	})
+
+	t.Run("requests userinfo without ID token", func(t *testing.T) {
+		plain := &Provider{Name: "plain", ClientID: "client", AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token", UserInfoURL: idp.URL + "/userinfo"}
+		plain.Init(nil)
+
+		identity, err := plain.Identity(&Tokens{AccessToken: "access"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.EqualFold(identity.Email, "user@mail.com") {
+			t.Errorf("got unexpected identity %+v", identity)
+		}
+	})
+}
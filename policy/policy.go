@@ -0,0 +1,389 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Effects of rules
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Operators of conditions
+const (
+	Equals      = "equals"
+	NotEquals   = "not_equals"
+	In          = "in"
+	NotIn       = "not_in"
+	Contains    = "contains"
+	NotContains = "not_contains"
+	Greater     = "gt"
+	GreaterOrEq = "gte"
+	Less        = "lt"
+	LessOrEq    = "lte"
+	Exists      = "exists"
+)
+
+// AnyAction matches every action in actions of rules
+const AnyAction = "*"
+
+type policyErr string
+
+func (e policyErr) Error() string { return string(e) }
+
+const (
+	errBlankRuleName  = policyErr("Rule name can't be blank")
+	errInvalidEffect  = policyErr("Rule effect must be allow or deny")
+	errBlankActions   = policyErr("Rule must apply to at least one action")
+	errBlankAttribute = policyErr("Condition attribute can't be blank")
+	errInvalidValue   = policyErr("Condition value doesn't suit the operator")
+)
+
+// Condition compares the attribute with the value or with the value of another attribute.
+// Attributes are dot-separated paths like token.roles or resource.organization.
+// Conditions on missing attributes aren't satisfied in allow rules, negative ones included,
+// while in deny rules negative conditions are. Exists checks the presence explicitly.
+type Condition struct {
+	Attribute      string      `json:"attribute"`
+	Operator       string      `json:"operator"`
+	Value          interface{} `json:"value,omitempty"`
+	ValueAttribute string      `json:"value_attribute,omitempty"`
+}
+
+// Rule allows or denies the actions when all of its conditions are satisfied
+type Rule struct {
+	Name       string      `json:"name"`
+	Effect     string      `json:"effect"`
+	Actions    []string    `json:"actions"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Decision is the result of the evaluation.
+// Rule is the name of the rule which decided, requests which no rule matches are denied.
+type Decision struct {
+	Allowed bool         `json:"allowed"`
+	Rule    string       `json:"rule,omitempty"`
+	Trace   []Evaluation `json:"trace,omitempty"`
+}
+
+// Evaluation explains whether the rule applying to the action matched.
+// Failed is the first unsatisfied condition and Actual is the value of its attribute.
+type Evaluation struct {
+	Rule    string      `json:"rule"`
+	Matched bool        `json:"matched"`
+	Failed  *Condition  `json:"failed,omitempty"`
+	Actual  interface{} `json:"actual,omitempty"`
+}
+
+// Engine evaluates rules in order, the first matching rule decides
+type Engine struct {
+	rules    []Rule
+	location *time.Location
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Load loads rules from the JSON file or from all JSON files of the directory specified in the environment.
+// Files of the directory are evaluated in order of their names. Without rules every request is denied.
+func Load() (*Engine, error) {
+	location := time.UTC
+	if name := os.Getenv("POLICY_TIMEZONE"); len(name) > 0 {
+		var err error
+		if location, err = time.LoadLocation(name); err != nil {
+			return nil, err
+		}
+	}
+
+	path := os.Getenv("POLICY_RULES_PATH")
+	if len(path) == 0 {
+		return New(nil, location)
+	}
+
+	paths := []string{path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		if paths, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+	}
+
+	var rules []Rule
+	for _, p := range paths {
+		c, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		var f rulesFile
+		if err = json.Unmarshal(c, &f); err != nil {
+			return nil, fmt.Errorf("Invalid policy file %q: %v", p, err)
+		}
+
+		rules = append(rules, f.Rules...)
+	}
+
+	return New(rules, location)
+}
+
+// New validates the rules and returns the engine evaluating time attributes in the location
+func New(rules []Rule, location *time.Location) (*Engine, error) {
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid rule %q: %v", rule.Name, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("Duplicate rule %q", rule.Name)
+		}
+
+		names[rule.Name] = true
+	}
+
+	return &Engine{rules: rules, location: location}, nil
+}
+
+// Evaluate decides whether the action is allowed with the attributes at the time.
+// The engine adds the action and the env namespace with the time, hour and weekday to the attributes.
+// Explain adds evaluations of all rules applying to the action up to the matching one.
+func (e *Engine) Evaluate(action string, attributes map[string]interface{}, now time.Time, explain bool) *Decision {
+	now = now.In(e.location)
+
+	input := make(map[string]interface{}, len(attributes)+2)
+	for k, v := range attributes {
+		input[k] = v
+	}
+	input["action"] = action
+	input["env"] = map[string]interface{}{
+		"time":    now.Format(time.RFC3339),
+		"hour":    now.Hour(),
+		"weekday": strings.ToLower(now.Weekday().String()),
+	}
+
+	decision := &Decision{}
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.applies(action) {
+			continue
+		}
+
+		evaluation := rule.evaluate(input)
+		if explain {
+			decision.Trace = append(decision.Trace, evaluation)
+		}
+		if evaluation.Matched {
+			decision.Allowed = rule.Effect == Allow
+			decision.Rule = rule.Name
+			break
+		}
+	}
+
+	return decision
+}
+
+func (r *Rule) validate() error {
+	if len(r.Name) == 0 {
+		return errBlankRuleName
+	}
+	if r.Effect != Allow && r.Effect != Deny {
+		return errInvalidEffect
+	}
+	if len(r.Actions) == 0 {
+		return errBlankActions
+	}
+
+	for _, c := range r.Conditions {
+		if len(c.Attribute) == 0 {
+			return errBlankAttribute
+		}
+
+		switch c.Operator {
+		case Equals, NotEquals, Contains, NotContains, Exists:
+		case In, NotIn:
+			if _, ok := list(c.Value); !ok && len(c.ValueAttribute) == 0 {
+				return errInvalidValue
+			}
+		case Greater, GreaterOrEq, Less, LessOrEq:
+			if _, ok := number(c.Value); !ok && len(c.ValueAttribute) == 0 {
+				return errInvalidValue
+			}
+		default:
+			return fmt.Errorf("Unknown operator %q", c.Operator)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) applies(action string) bool {
+	for _, a := range r.Actions {
+		if a == AnyAction || a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) evaluate(input map[string]interface{}) Evaluation {
+	for i := range r.Conditions {
+		c := &r.Conditions[i]
+
+		actual, found := lookup(input, c.Attribute)
+		expected, known := c.Value, true
+		if len(c.ValueAttribute) > 0 {
+			expected, known = lookup(input, c.ValueAttribute)
+		}
+
+		var ok bool
+		switch {
+		case c.Operator == Exists:
+			ok = found
+		case !found || !known:
+			// Missing attributes satisfy negative conditions only in deny rules so that rules fail closed
+			ok = c.negative() && r.Effect == Deny
+		default:
+			ok = c.satisfied(actual, expected)
+		}
+
+		if !ok {
+			return Evaluation{Rule: r.Name, Failed: c, Actual: actual}
+		}
+	}
+
+	return Evaluation{Rule: r.Name, Matched: true}
+}
+
+// satisfied compares the actual value of the attribute with the expected one
+func (c *Condition) satisfied(actual, expected interface{}) bool {
+	switch c.Operator {
+	case Equals:
+		return equal(actual, expected)
+	case NotEquals:
+		return !equal(actual, expected)
+	case In:
+		return includes(expected, actual)
+	case NotIn:
+		return !includes(expected, actual)
+	case Contains:
+		return includes(actual, expected)
+	case NotContains:
+		return !includes(actual, expected)
+	}
+
+	a, ok := number(actual)
+	if !ok {
+		return false
+	}
+	b, ok := number(expected)
+	if !ok {
+		return false
+	}
+
+	switch c.Operator {
+	case Greater:
+		return a > b
+	case GreaterOrEq:
+		return a >= b
+	case Less:
+		return a < b
+	case LessOrEq:
+		return a <= b
+	}
+
+	return false
+}
+
+func (c *Condition) negative() bool {
+	return c.Operator == NotEquals || c.Operator == NotIn || c.Operator == NotContains
+}
+
+// lookup returns the value at the dot-separated path of nested maps
+func lookup(input map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = input
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func includes(items, item interface{}) bool {
+	values, ok := list(items)
+	if !ok {
+		return false
+	}
+
+	for _, v := range values {
+		if equal(v, item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equal compares numbers regardless of their types and other values deeply
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func list(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+
+	result := make([]interface{}, rv.Len())
+	for i := range result {
+		result[i] = rv.Index(i).Interface()
+	}
+
+	return result, true
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
@@ -0,0 +1,314 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRules = []Rule{
+	{
+		Name:    "suspended users",
+		Effect:  Deny,
+		Actions: []string{AnyAction},
+		Conditions: []Condition{
+			{Attribute: "user.payload.suspended", Operator: Equals, Value: true},
+		},
+	},
+	{
+		Name:    "editors edit documents of their organization during business hours",
+		Effect:  Allow,
+		Actions: []string{"documents:edit"},
+		Conditions: []Condition{
+			{Attribute: "token.roles", Operator: Contains, Value: "editor"},
+			{Attribute: "resource.organization", Operator: Equals, ValueAttribute: "token.tenant"},
+			{Attribute: "env.hour", Operator: GreaterOrEq, Value: float64(9)},
+			{Attribute: "env.hour", Operator: Less, Value: float64(17)},
+			{Attribute: "env.weekday", Operator: NotIn, Value: []interface{}{"saturday", "sunday"}},
+		},
+	},
+	{
+		Name:    "owners read documents",
+		Effect:  Allow,
+		Actions: []string{"documents:read"},
+		Conditions: []Condition{
+			{Attribute: "resource.owner_id", Operator: Equals, ValueAttribute: "user.id"},
+		},
+	},
+}
+
+// businessHours is Wednesday noon
+var businessHours = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func testAttributes(tenant string, resource map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"token":    map[string]interface{}{"user_id": int64(1), "roles": []string{"editor"}, "tenant": tenant},
+		"user":     map[string]interface{}{"id": int64(1), "payload": map[string]interface{}{}},
+		"resource": resource,
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	engine, err := New(testRules, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		action     string
+		attributes map[string]interface{}
+		now        time.Time
+		allowed    bool
+		rule       string
+	}{
+		{
+			"allows editors in their organization", "documents:edit",
+			testAttributes("acme", map[string]interface{}{"organization": "acme"}),
+			businessHours, true, testRules[1].Name,
+		},
+		{
+			"denies editors in another organization", "documents:edit",
+			testAttributes("acme", map[string]interface{}{"organization": "globex"}),
+			businessHours, false, "",
+		},
+		{
+			"denies editors after business hours", "documents:edit",
+			testAttributes("acme", map[string]interface{}{"organization": "acme"}),
+			businessHours.Add(6 * time.Hour), false, "",
+		},
+		{
+			"denies editors on weekends", "documents:edit",
+			testAttributes("acme", map[string]interface{}{"organization": "acme"}),
+			businessHours.Add(72 * time.Hour), false, "",
+		},
+		{
+			"compares numbers of different types", "documents:read",
+			testAttributes("", map[string]interface{}{"owner_id": float64(1)}),
+			businessHours, true, testRules[2].Name,
+		},
+		{
+			"denies actions without rules", "documents:delete",
+			testAttributes("acme", map[string]interface{}{"organization": "acme"}),
+			businessHours, false, "",
+		},
+		{
+			"applies deny rules first", "documents:read",
+			map[string]interface{}{
+				"user":     map[string]interface{}{"id": int64(1), "payload": map[string]interface{}{"suspended": true}},
+				"resource": map[string]interface{}{"owner_id": float64(1)},
+			},
+			businessHours, false, testRules[0].Name,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decision := engine.Evaluate(tc.action, tc.attributes, tc.now, false)
+
+			if decision.Allowed != tc.allowed || decision.Rule != tc.rule || decision.Trace != nil {
+				t.Errorf("got unexpected decision %+v", decision)
+			}
+		})
+	}
+
+	t.Run("doesn't match missing value attributes", func(t *testing.T) {
+		attributes := testAttributes("acme", map[string]interface{}{"organization": nil})
+		delete(attributes["token"].(map[string]interface{}), "tenant")
+
+		if decision := engine.Evaluate("documents:edit", attributes, businessHours, false); decision.Allowed {
+			t.Errorf("got unexpected decision %+v", decision)
+		}
+	})
+}
+
+func TestEvaluateMissingAttributes(t *testing.T) {
+	rules := []Rule{
+		{
+			Name:    "banned users",
+			Effect:  Deny,
+			Actions: []string{"documents:read"},
+			Conditions: []Condition{
+				{Attribute: "user.payload.status", Operator: NotEquals, Value: "active"},
+			},
+		},
+		{
+			Name:    "everyone except guests",
+			Effect:  Allow,
+			Actions: []string{AnyAction},
+			Conditions: []Condition{
+				{Attribute: "user.roles", Operator: NotContains, Value: "guest"},
+				{Attribute: "resource.organization", Operator: NotIn, ValueAttribute: "user.blocked_organizations"},
+			},
+		},
+	}
+	engine, err := New(rules, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		action  string
+		user    map[string]interface{}
+		allowed bool
+		rule    string
+	}{
+		{
+			"allows with present attributes", "documents:edit",
+			map[string]interface{}{"roles": []string{"editor"}, "blocked_organizations": []string{"globex"}},
+			true, "everyone except guests",
+		},
+		{
+			"doesn't allow by negative condition on missing attribute", "documents:edit",
+			map[string]interface{}{"blocked_organizations": []string{"globex"}},
+			false, "",
+		},
+		{
+			"doesn't allow by negative condition on missing value attribute", "documents:edit",
+			map[string]interface{}{"roles": []string{"editor"}},
+			false, "",
+		},
+		{
+			"denies by negative condition on missing attribute", "documents:read",
+			map[string]interface{}{"roles": []string{"editor"}, "blocked_organizations": []string{"globex"}},
+			false, "banned users",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attributes := map[string]interface{}{"user": tc.user, "resource": map[string]interface{}{"organization": "acme"}}
+			decision := engine.Evaluate(tc.action, attributes, businessHours, false)
+
+			if decision.Allowed != tc.allowed || decision.Rule != tc.rule {
+				t.Errorf("got unexpected decision %+v", decision)
+			}
+		})
+	}
+}
+
+func TestEvaluateExplain(t *testing.T) {
+	engine, err := New(testRules, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := engine.Evaluate("documents:edit", testAttributes("acme", map[string]interface{}{"organization": "globex"}), businessHours, true)
+	if len(decision.Trace) != 2 {
+		t.Fatalf("expected evaluations of rules applying to the action, got %+v", decision.Trace)
+	}
+
+	evaluation := decision.Trace[1]
+	if evaluation.Matched || evaluation.Failed.Attribute != "resource.organization" || evaluation.Actual != "globex" {
+		t.Errorf("got unexpected evaluation %+v", evaluation)
+	}
+}
+
+func TestEvaluateLocation(t *testing.T) {
+	location := time.FixedZone("UTC+10", 10*60*60)
+	engine, err := New(testRules, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Noon in UTC is 22:00 in the location
+	decision := engine.Evaluate("documents:edit", testAttributes("acme", map[string]interface{}{"organization": "acme"}), businessHours, false)
+	if decision.Allowed {
+		t.Errorf("got unexpected decision %+v", decision)
+	}
+}
+
+func TestNew(t *testing.T) {
+	invalid := map[string]Rule{
+		"blank name":       {Effect: Allow, Actions: []string{"read"}},
+		"unknown effect":   {Name: "rule", Effect: "permit", Actions: []string{"read"}},
+		"blank actions":    {Name: "rule", Effect: Allow},
+		"blank attribute":  {Name: "rule", Effect: Allow, Actions: []string{"read"}, Conditions: []Condition{{Operator: Exists}}},
+		"unknown operator": {Name: "rule", Effect: Allow, Actions: []string{"read"}, Conditions: []Condition{{Attribute: "a", Operator: "like"}}},
+		"invalid list":     {Name: "rule", Effect: Allow, Actions: []string{"read"}, Conditions: []Condition{{Attribute: "a", Operator: In, Value: "b"}}},
+		"invalid number":   {Name: "rule", Effect: Allow, Actions: []string{"read"}, Conditions: []Condition{{Attribute: "a", Operator: Less, Value: "9"}}},
+	}
+	for name, rule := range invalid {
+		t.Run("returns error with "+name, func(t *testing.T) {
+			if _, err := New([]Rule{rule}, time.UTC); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	t.Run("returns error with duplicate rules", func(t *testing.T) {
+		if _, err := New([]Rule{testRules[0], testRules[0]}, time.UTC); err == nil || !strings.Contains(err.Error(), "Duplicate") {
+			t.Errorf("expected duplicate error, got %v", err)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"b.json": `{"rules": [{"name": "everyone reads", "effect": "allow", "actions": ["read"]}]}`,
+		"a.json": `{"rules": [{"name": "nobody reads", "effect": "deny", "actions": ["read"]}]}`,
+		"notes":  `not a policy`,
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Unsetenv("POLICY_RULES_PATH")
+
+	t.Run("loads files of the directory in order of names", func(t *testing.T) {
+		os.Setenv("POLICY_RULES_PATH", dir)
+
+		engine, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decision := engine.Evaluate("read", nil, time.Now(), false); decision.Allowed || decision.Rule != "nobody reads" {
+			t.Errorf("got unexpected decision %+v", decision)
+		}
+	})
+
+	t.Run("loads the file", func(t *testing.T) {
+		os.Setenv("POLICY_RULES_PATH", filepath.Join(dir, "b.json"))
+
+		engine, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decision := engine.Evaluate("read", nil, time.Now(), false); !decision.Allowed {
+			t.Errorf("got unexpected decision %+v", decision)
+		}
+	})
+
+	t.Run("returns error with invalid file", func(t *testing.T) {
+		os.Setenv("POLICY_RULES_PATH", filepath.Join(dir, "notes"))
+
+		if _, err := Load(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("denies everything without rules", func(t *testing.T) {
+		os.Unsetenv("POLICY_RULES_PATH")
+
+		engine, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decision := engine.Evaluate("read", nil, time.Now(), false); decision.Allowed {
+			t.Errorf("got unexpected decision %+v", decision)
+		}
+	})
+}
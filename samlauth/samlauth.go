@@ -0,0 +1,294 @@
+package samlauth
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+// SP is the SAML service provider shared by tenant connections
+type SP struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+	// BaseURL is the public URL of the service used to build metadata and ACS endpoints
+	BaseURL string
+}
+
+// Assertion contains validated data of the SAML assertion
+type Assertion struct {
+	ID         string
+	NameID     string
+	Email      string
+	Attributes map[string][]string
+	ExpiresAt  time.Time
+}
+
+type samlErr string
+
+func (e samlErr) Error() string { return string(e) }
+
+const (
+	errMissingIDPSSO       = samlErr("Metadata doesn't contain identity provider SSO descriptor")
+	errMetadataUnavailable = samlErr("Identity provider metadata is unavailable")
+	errInvalidKey          = samlErr("Service provider key must be an RSA private key")
+	errInvalidResponse     = samlErr("Invalid SAML response")
+	errMultipleAssertions  = samlErr("SAML response must contain exactly one assertion")
+	errMissingAudience     = samlErr("SAML assertion must be restricted to the service provider audience")
+	errMissingEmail        = samlErr("SAML assertion doesn't contain user email")
+)
+
+const metadataEndpoint = "/saml/metadata"
+const acsEndpoint = "/saml/acs"
+const maxMetadataSize = 1048576
+const requestTimeout = 10 * time.Second
+
+// Load loads the service provider key pair specified in the environment.
+// It returns nil when SAML isn't configured.
+func Load() (*SP, error) {
+	certPath, keyPath := os.Getenv("SAML_SP_CERT_PATH"), os.Getenv("SAML_SP_KEY_PATH")
+	if len(certPath) == 0 || len(keyPath) == 0 {
+		return nil, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errInvalidKey
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("SAML_SP_BASE_URL")
+	if len(baseURL) == 0 {
+		baseURL = os.Getenv("APP_HOST")
+	}
+
+	return &SP{Key: key, Certificate: cert, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// ParseMetadata parses identity provider metadata and returns its entity ID
+func ParseMetadata(data []byte) (string, error) {
+	entity, err := samlsp.ParseMetadata(data)
+	if err != nil {
+		return "", err
+	}
+	if len(entity.IDPSSODescriptors) == 0 {
+		return "", errMissingIDPSSO
+	}
+
+	return entity.EntityID, nil
+}
+
+// FetchMetadata downloads identity provider metadata
+func FetchMetadata(metadataURL string) ([]byte, error) {
+	client := &http.Client{Timeout: requestTimeout}
+
+	resp, err := client.Get(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errMetadataUnavailable
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxMetadataSize))
+}
+
+// ServiceProvider returns the service provider configured for the tenant connection
+func (sp *SP) ServiceProvider(c *models.SAMLConnection) (*saml.ServiceProvider, error) {
+	idp, err := samlsp.ParseMetadata([]byte(c.IdPMetadata))
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, err := url.Parse(sp.endpoint(metadataEndpoint, c.Tenant))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(sp.endpoint(acsEndpoint, c.Tenant))
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               sp.Key,
+		Certificate:       sp.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idp,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		AllowIDPInitiated: false,
+	}, nil
+}
+
+// Metadata returns service provider metadata of the tenant connection
+func (sp *SP) Metadata(c *models.SAMLConnection) ([]byte, error) {
+	provider, err := sp.ServiceProvider(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.MarshalIndent(provider.Metadata(), "", "  ")
+}
+
+// AuthnRequestURL returns the identity provider URL with a new authentication request and the request ID
+func (sp *SP) AuthnRequestURL(c *models.SAMLConnection, relayState string) (string, string, error) {
+	provider, err := sp.ServiceProvider(c)
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := provider.MakeAuthenticationRequest(
+		provider.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	location, err := req.Redirect(relayState, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	return location.String(), req.ID, nil
+}
+
+// ParseResponse validates signature, issuer, audience, recipient and time window of the base64 encoded
+// SAML response to the authentication request
+func (sp *SP) ParseResponse(c *models.SAMLConnection, response, requestID string) (*Assertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return nil, errInvalidResponse
+	}
+
+	// Only the first assertion is verified by the signature check so responses
+	// with injected assertions are rejected before parsing
+	if err = singleAssertion(raw); err != nil {
+		return nil, err
+	}
+
+	provider, err := sp.ServiceProvider(c)
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, err := provider.ParseXMLResponse(raw, []string{requestID}, provider.AcsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(assertion.Conditions.AudienceRestrictions) == 0 {
+		return nil, errMissingAudience
+	}
+
+	result := &Assertion{
+		ID:         assertion.ID,
+		Attributes: make(map[string][]string),
+		ExpiresAt:  assertion.Conditions.NotOnOrAfter,
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		result.NameID = assertion.Subject.NameID.Value
+	}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			for _, v := range attr.Values {
+				result.Attributes[attr.Name] = append(result.Attributes[attr.Name], v.Value)
+				if len(attr.FriendlyName) > 0 && attr.FriendlyName != attr.Name {
+					result.Attributes[attr.FriendlyName] = append(result.Attributes[attr.FriendlyName], v.Value)
+				}
+			}
+		}
+	}
+
+	if len(c.EmailAttribute) > 0 {
+		if values := result.Attributes[c.EmailAttribute]; len(values) > 0 {
+			result.Email = values[0]
+		}
+	} else if strings.Contains(result.NameID, "@") {
+		result.Email = result.NameID
+	}
+	if len(result.Email) == 0 {
+		return nil, errMissingEmail
+	}
+	if len(result.NameID) == 0 {
+		result.NameID = result.Email
+	}
+
+	return result, nil
+}
+
+// ReplayTTL returns how long the assertion ID has to be remembered to reject replayed responses
+func (a *Assertion) ReplayTTL() time.Duration {
+	ttl := time.Until(a.ExpiresAt) + saml.MaxClockSkew
+	if ttl < saml.MaxClockSkew {
+		return saml.MaxClockSkew
+	}
+
+	return ttl
+}
+
+// Roles maps values of the role attribute to local role names
+func Roles(c *models.SAMLConnection, assertion *Assertion) []string {
+	roles := make([]string, 0, len(c.DefaultRoles))
+	seen := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				roles = append(roles, name)
+			}
+		}
+	}
+
+	add(c.DefaultRoles)
+	if len(c.RoleAttribute) > 0 {
+		for _, value := range assertion.Attributes[c.RoleAttribute] {
+			add(c.AttributeRoles[value])
+		}
+	}
+
+	return roles
+}
+
+func (sp *SP) endpoint(path, tenant string) string {
+	return sp.BaseURL + path + "?tenant=" + url.QueryEscape(tenant)
+}
+
+func singleAssertion(raw []byte) error {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		return errInvalidResponse
+	}
+
+	count := len(doc.FindElements("//Assertion")) + len(doc.FindElements("//EncryptedAssertion"))
+	if count != 1 {
+		return errMultipleAssertions
+	}
+
+	return nil
+}
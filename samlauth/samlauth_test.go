@@ -0,0 +1,184 @@
+package samlauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/maxshend/tiny_goauth/authtest"
+	"github.com/maxshend/tiny_goauth/models"
+)
+
+func testSP(t *testing.T) (*SP, *authtest.FakeSAMLIdP, *models.SAMLConnection) {
+	t.Helper()
+
+	key, err := authtest.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := authtest.NewFakeSAMLIdP(t)
+	sp := &SP{Key: key, Certificate: authtest.GenerateCertificate(t, key), BaseURL: "https://sp.local"}
+	c := &models.SAMLConnection{
+		Tenant:         "acme",
+		IdPMetadata:    idp.Metadata(t),
+		EmailAttribute: "email",
+		RoleAttribute:  "groups",
+		AttributeRoles: map[string][]string{"admins": {"admin"}},
+		DefaultRoles:   []string{"staff"},
+	}
+
+	return sp, idp, c
+}
+
+func testSession() *saml.Session {
+	return &saml.Session{
+		NameID: "jane",
+		CustomAttributes: []saml.Attribute{
+			{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: "jane@acme.com"}}},
+			{Name: "groups", Values: []saml.AttributeValue{{Type: "xs:string", Value: "admins"}}},
+		},
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	idp := authtest.NewFakeSAMLIdP(t)
+
+	t.Run("returns entity ID of the identity provider", func(t *testing.T) {
+		entityID, err := ParseMetadata([]byte(idp.Metadata(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if entityID != "https://idp.local/metadata" {
+			t.Errorf("got unexpected entity ID %q", entityID)
+		}
+	})
+
+	t.Run("returns error for service provider metadata", func(t *testing.T) {
+		sp, _, c := testSP(t)
+		metadata, err := sp.Metadata(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParseMetadata(metadata)
+
+		authtest.AssertError(t, errMissingIDPSSO, err)
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	sp, _, c := testSP(t)
+
+	metadata, err := sp.Metadata(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(metadata), `Location="https://sp.local/saml/acs?tenant=acme"`) {
+		t.Errorf("expected ACS location in metadata, got %s", metadata)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	sp, idp, c := testSP(t)
+	spMetadata, err := sp.Metadata(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, requestID, err := sp.AuthnRequestURL(c, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := url.Parse(location); u.Host != "idp.local" || u.Query().Get("RelayState") != "state" {
+		t.Fatalf("got unexpected authentication request URL %q", location)
+	}
+
+	t.Run("returns assertion for valid response", func(t *testing.T) {
+		assertion, err := sp.ParseResponse(c, idp.Response(t, spMetadata, location, testSession()), requestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assertion.NameID != "jane" || assertion.Email != "jane@acme.com" || len(assertion.ID) == 0 {
+			t.Errorf("got unexpected assertion %+v", assertion)
+		}
+		if roles := Roles(c, assertion); !reflect.DeepEqual(roles, []string{"staff", "admin"}) {
+			t.Errorf("got unexpected roles %v", roles)
+		}
+	})
+
+	t.Run("returns error for another request", func(t *testing.T) {
+		_, err := sp.ParseResponse(c, idp.Response(t, spMetadata, location, testSession()), "another")
+
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error for another tenant", func(t *testing.T) {
+		other := *c
+		other.Tenant = "other"
+		_, err := sp.ParseResponse(&other, idp.Response(t, spMetadata, location, testSession()), requestID)
+
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error for expired response", func(t *testing.T) {
+		response := idp.Response(t, spMetadata, location, testSession())
+
+		saml.TimeNow = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { saml.TimeNow = func() time.Time { return time.Now().UTC() } }()
+
+		_, err := sp.ParseResponse(c, response, requestID)
+
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error for tampered response", func(t *testing.T) {
+		raw := idp.ResponseXML(t, spMetadata, location, testSession())
+		i := bytes.LastIndex(raw, []byte("<xenc:CipherValue>")) + len("<xenc:CipherValue>")
+		if raw[i] == 'A' {
+			raw[i] = 'B'
+		} else {
+			raw[i] = 'A'
+		}
+
+		_, err := sp.ParseResponse(c, base64.StdEncoding.EncodeToString(raw), requestID)
+
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("returns error for injected assertion", func(t *testing.T) {
+		raw := string(idp.ResponseXML(t, spMetadata, location, testSession()))
+		start := strings.Index(raw, "<saml:EncryptedAssertion")
+		end := strings.Index(raw, "</saml:EncryptedAssertion>") + len("</saml:EncryptedAssertion>")
+		raw = raw[:end] + raw[start:end] + raw[end:]
+
+		_, err := sp.ParseResponse(c, base64.StdEncoding.EncodeToString([]byte(raw)), requestID)
+
+		authtest.AssertError(t, errMultipleAssertions, err)
+	})
+
+	t.Run("returns error without email", func(t *testing.T) {
+		session := testSession()
+		session.CustomAttributes = nil
+
+		_, err := sp.ParseResponse(c, idp.Response(t, spMetadata, location, session), requestID)
+
+		authtest.AssertError(t, errMissingEmail, err)
+	})
+}
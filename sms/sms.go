@@ -0,0 +1,70 @@
+package sms
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sender is the interface that wraps a method to send text messages
+type Sender interface {
+	Send(to, body string) error
+}
+
+// LogSender writes text messages to a writer
+type LogSender struct {
+	Writer io.Writer
+}
+
+// FileSender drops text messages as files into a directory
+type FileSender struct {
+	Dir string
+}
+
+type smsErr string
+
+func (e smsErr) Error() string { return string(e) }
+
+const errUnknownTransport = smsErr("Unknown SMS transport")
+
+const defaultDropPath = "tmp/sms"
+
+// New creates a text message sender configured from the environment
+func New() (Sender, error) {
+	switch os.Getenv("SMS_TRANSPORT") {
+	case "file":
+		path := os.Getenv("SMS_DROP_PATH")
+		if len(path) == 0 {
+			path = defaultDropPath
+		}
+
+		return &FileSender{Dir: path}, nil
+	case "", "log":
+		return &LogSender{Writer: os.Stdout}, nil
+	}
+
+	return nil, errUnknownTransport
+}
+
+// Send writes a text message to the writer
+func (s *LogSender) Send(to, body string) error {
+	_, err := fmt.Fprintf(s.Writer, "SMS to %s: %s\n", to, body)
+
+	return err
+}
+
+// Send writes a text message to a new file in the drop directory
+func (s *FileSender) Send(to, body string) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.txt", time.Now().UnixNano(), uuid.New().String())
+
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), []byte("To: "+to+"\n\n"+body+"\n"), 0644)
+}
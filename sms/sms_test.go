@@ -0,0 +1,46 @@
+package sms
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+	sender := &LogSender{Writer: &buf}
+
+	if err := sender.Send("+15550000001", "Your code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "+15550000001") || !strings.Contains(buf.String(), "123456") {
+		t.Errorf("got unexpected output %q", buf.String())
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender := &FileSender{Dir: dir}
+	if err = sender.Send("+15550000001", "Your code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file got %d", len(files))
+	}
+
+	c, _ := ioutil.ReadFile(files[0])
+	if !strings.Contains(string(c), "To: +15550000001") {
+		t.Errorf("got unexpected message %q", c)
+	}
+}